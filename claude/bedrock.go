@@ -17,24 +17,28 @@ limitations under the License.
 package claude
 
 import (
+	"encoding/json"
 	"fmt"
 
 	"github.com/spf13/cast"
 
 	weboai "github.com/bytemind-io/corekit/openai"
+	"github.com/bytemind-io/corekit/token"
 	"github.com/sashabaranov/go-openai"
 )
 
 // BedrockRequest is the bedrock request. https://us-east-1.console.aws.amazon.com/bedrock/home?region=us-east-1#/providers?model=anthropic.claude-3-haiku-20240307-v1:0
 // AWS Bedrock [官网](https://us-east-1.console.aws.amazon.com/bedrock/home?region=us-east-1#/providers?model=anthropic.claude-3-haiku-20240307-v1:0)
 type BedrockRequest struct {
-	AnthropicVersion  string   `json:"anthropic_version"`
-	MaxTokens         int      `json:"max_tokens,omitempty"`
-	System            string   `json:"system,omitempty"`
-	Messages          Messages `json:"messages"`
-	MaxTokensToSample float32  `json:"max_tokens_to_sample,omitempty"`
-	Temperature       float32  `json:"temperature,omitempty"`
-	TopP              float32  `json:"top_p,omitempty"`
+	AnthropicVersion  string      `json:"anthropic_version"`
+	MaxTokens         int         `json:"max_tokens,omitempty"`
+	System            string      `json:"system,omitempty"`
+	Messages          Messages    `json:"messages"`
+	MaxTokensToSample float32     `json:"max_tokens_to_sample,omitempty"`
+	Temperature       float32     `json:"temperature,omitempty"`
+	TopP              float32     `json:"top_p,omitempty"`
+	Tools             []Tool      `json:"tools,omitempty"`
+	ToolChoice        *ToolChoice `json:"tool_choice,omitempty"`
 }
 
 // OpenaiConvertSonnet is the sonnet.TODO only use in Charlie W. Johnson.
@@ -46,15 +50,11 @@ func OpenaiConvertSonnet(in openai.ChatCompletionRequest) (*BedrockRequest, erro
 
 	req := &BedrockRequest{
 		AnthropicVersion: version,
-		MaxTokens:        in.MaxTokens,
+		MaxTokens:        token.ClampMaxTokens(in.Model, in.MaxTokens),
 		Temperature:      in.Temperature,
 		TopP:             in.TopP,
 	}
 
-	if in.MaxTokens <= 0 {
-		req.MaxTokens = 1024
-	}
-
 	if in.Temperature <= 0 {
 		req.Temperature = 1.0
 	}
@@ -65,61 +65,49 @@ func OpenaiConvertSonnet(in openai.ChatCompletionRequest) (*BedrockRequest, erro
 	)
 
 	for idx, message := range in.Messages {
-		if message.Role == openai.ChatMessageRoleSystem {
-			if in.Messages[idx+1].Role != openai.ChatMessageRoleUser {
+		prev := ""
+		if idx > 0 {
+			prev = in.Messages[idx-1].Role
+		}
+
+		switch message.Role {
+		case openai.ChatMessageRoleSystem:
+			if idx+1 >= len(in.Messages) || in.Messages[idx+1].Role != openai.ChatMessageRoleUser {
 				return nil, fmt.Errorf("system must be followed by user message")
 			}
 
 			sysCnt++
 			req.System = message.Content
-		} else {
-			if message.Role == openai.ChatMessageRoleUser {
-				if idx == 0 {
-					msgs = append(msgs, Message{
-						Role: openai.ChatMessageRoleUser,
-						Content: []Content{
-							{
-								Type: "text",
-								Text: message.Content,
-							},
-						},
-					})
-					continue
-				}
-
-				if in.Messages[idx-1].Role == openai.ChatMessageRoleSystem || in.Messages[idx-1].Role == openai.ChatMessageRoleAssistant {
-					msgs = append(msgs, Message{
-						Role: openai.ChatMessageRoleUser,
-						Content: []Content{
-							{
-								Type: "text",
-								Text: message.Content,
-							},
-						},
-					})
-					continue
-				}
-
+		case openai.ChatMessageRoleUser:
+			if idx != 0 && prev != openai.ChatMessageRoleSystem && prev != openai.ChatMessageRoleAssistant && prev != openai.ChatMessageRoleTool {
 				return nil, fmt.Errorf("user must be followed by system and assistant message")
 			}
+			msgs = appendMessage(msgs, openai.ChatMessageRoleUser, Content{Type: "text", Text: message.Content})
+		case openai.ChatMessageRoleAssistant:
+			if prev != openai.ChatMessageRoleUser && prev != openai.ChatMessageRoleTool {
+				return nil, fmt.Errorf("assistant must be followed by user message")
+			}
 
-			if message.Role == openai.ChatMessageRoleAssistant {
-				if in.Messages[idx-1].Role != openai.ChatMessageRoleUser {
-					return nil, fmt.Errorf("assistant must be followed by user message")
+			var contents []Content
+			if message.Content != "" || len(message.ToolCalls) == 0 {
+				contents = append(contents, Content{Type: "text", Text: message.Content})
+			}
+			for _, call := range message.ToolCalls {
+				content, err := ToolUseContent(call)
+				if err != nil {
+					return nil, err
 				}
-
-				msgs = append(msgs, Message{
-					Role: openai.ChatMessageRoleAssistant,
-					Content: []Content{
-						{
-							Type: "text",
-							Text: message.Content,
-						},
-					},
-				})
-				continue
+				contents = append(contents, content)
+			}
+			msgs = appendMessage(msgs, openai.ChatMessageRoleAssistant, contents...)
+		case openai.ChatMessageRoleTool:
+			if prev != openai.ChatMessageRoleAssistant && prev != openai.ChatMessageRoleTool {
+				return nil, fmt.Errorf("tool must be followed by assistant or tool message")
 			}
-			return nil, fmt.Errorf("role must be system, user or assistant")
+			// the tool results are sent back as the next user message.
+			msgs = appendMessage(msgs, openai.ChatMessageRoleUser, ToolResultContent(message))
+		default:
+			return nil, fmt.Errorf("role must be system, user, assistant or tool")
 		}
 	}
 
@@ -127,10 +115,33 @@ func OpenaiConvertSonnet(in openai.ChatCompletionRequest) (*BedrockRequest, erro
 		return nil, fmt.Errorf("system message must be only one.(%d)", sysCnt)
 	}
 
+	tools, err := ConvertOpenaiTools(in.Tools)
+	if err != nil {
+		return nil, err
+	}
+	req.Tools = tools
+	if len(tools) != 0 {
+		if req.ToolChoice, err = ConvertOpenaiToolChoice(in.ToolChoice); err != nil {
+			return nil, err
+		}
+	}
+
 	req.Messages = msgs
 	return req, nil
 }
 
+// appendMessage appends the contents as a message of the role, they are merged into the last
+// message of the same role since Claude requires the roles to alternate.
+func appendMessage(msgs Messages, role string, contents ...Content) Messages {
+	if len(msgs) != 0 && msgs[len(msgs)-1].Role == role {
+		if last, ok := msgs[len(msgs)-1].Content.([]Content); ok {
+			msgs[len(msgs)-1].Content = append(last, contents...)
+			return msgs
+		}
+	}
+	return append(msgs, Message{Role: role, Content: contents})
+}
+
 // OpenaiWebConvertSonnet is the sonnet.TODO only use in Charlie W. Johnson.[将Openai网页数据抓换成Bedrock]
 func OpenaiWebConvertSonnet(r weboai.ChatCompletionRequest) (*BedrockRequest, error) {
 	version, ok := AnthropicVersion[r.Model]
@@ -140,15 +151,11 @@ func OpenaiWebConvertSonnet(r weboai.ChatCompletionRequest) (*BedrockRequest, er
 
 	req := &BedrockRequest{
 		AnthropicVersion: version,
-		MaxTokens:        r.MaxTokens,
+		MaxTokens:        token.ClampMaxTokens(r.Model, r.MaxTokens),
 		Temperature:      r.Temperature,
 		TopP:             r.TopP,
 	}
 
-	if r.MaxTokens <= 0 {
-		req.MaxTokens = 4096
-	}
-
 	if r.Temperature <= 0 {
 		req.Temperature = 1.0
 	}
@@ -255,14 +262,23 @@ type Contents []Content
 
 // Content is the content.
 type Content struct {
-	Type   string  `json:"type"`
-	Source *Source `json:"source,omitempty"`
-	Text   string  `json:"text,omitempty"`
+	Type        string          `json:"type"`
+	Source      *Source         `json:"source,omitempty"`
+	Text        string          `json:"text,omitempty"`
+	ID          string          `json:"id,omitempty"`           // tool_use
+	Name        string          `json:"name,omitempty"`         // tool_use
+	Input       json.RawMessage `json:"input,omitempty"`        // tool_use
+	ToolUseID   string          `json:"tool_use_id,omitempty"`  // tool_result
+	Content     interface{}     `json:"content,omitempty"`      // tool_result, a string or the blocks
+	IsError     bool            `json:"is_error,omitempty"`     // tool_result
+	PartialJSON string          `json:"partial_json,omitempty"` // input_json_delta
+	StopReason  string          `json:"stop_reason,omitempty"`  // message_delta
 }
 
 // Source is the source.
 type Source struct {
 	Type      string `json:"type"`
-	MediaType string `json:"media_type"`
-	Data      string `json:"data"`
+	MediaType string `json:"media_type,omitempty"`
+	Data      string `json:"data,omitempty"`
+	URL       string `json:"url,omitempty"`
 }
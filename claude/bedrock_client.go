@@ -0,0 +1,289 @@
+/*
+Copyright 2024 The corego Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package claude
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/bytemind-io/corekit"
+)
+
+// bedrockService is the signing name of the bedrock runtime.
+const bedrockService = "bedrock"
+
+// BedrockConfig is the configuration of the bedrock runtime client.
+type BedrockConfig struct {
+	Region          string `json:",optional,env=AWS_REGION,default=us-east-1"   envconfig:"AWS_REGION"            default:"us-east-1"`
+	AccessKeyID     string `json:",optional,env=AWS_ACCESS_KEY_ID"              envconfig:"AWS_ACCESS_KEY_ID"`
+	SecretAccessKey string `json:",optional,env=AWS_SECRET_ACCESS_KEY"          envconfig:"AWS_SECRET_ACCESS_KEY"`
+	SessionToken    string `json:",optional,env=AWS_SESSION_TOKEN"              envconfig:"AWS_SESSION_TOKEN"` // 临时凭证
+	Endpoint        string `json:",optional,env=BEDROCK_ENDPOINT"               envconfig:"BEDROCK_ENDPOINT"`  // 默认 https://bedrock-runtime.{region}.amazonaws.com
+}
+
+// Validate validates the BedrockConfig.
+func (c BedrockConfig) Validate() error {
+	if c.Region == "" {
+		return fmt.Errorf("aws region is required")
+	}
+	if c.AccessKeyID == "" || c.SecretAccessKey == "" {
+		return fmt.Errorf("aws access key id and secret access key are required")
+	}
+	if c.Endpoint != "" {
+		if _, err := url.Parse(c.Endpoint); err != nil {
+			return fmt.Errorf("invalid bedrock endpoint: %w", err)
+		}
+	}
+	return nil
+}
+
+// BedrockClient calls the Claude models of the bedrock runtime.[Bedrock客户端]
+type BedrockClient struct {
+	endpoint   string
+	signer     *Signer
+	httpClient *http.Client
+	now        func() time.Time
+}
+
+// NewBedrockClient creates a BedrockClient, http.DefaultClient is used if httpClient is nil.
+func NewBedrockClient(c BedrockConfig, httpClient *http.Client) (*BedrockClient, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	endpoint := c.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://bedrock-runtime.%s.amazonaws.com", c.Region)
+	}
+	return &BedrockClient{
+		endpoint: strings.TrimRight(endpoint, "/"),
+		signer: NewSigner(Credentials{
+			AccessKeyID:     c.AccessKeyID,
+			SecretAccessKey: c.SecretAccessKey,
+			SessionToken:    c.SessionToken,
+		}, c.Region, bedrockService),
+		httpClient: httpClient,
+		now:        time.Now,
+	}, nil
+}
+
+// do sends the signed request of the model action and returns the response of status 200,
+// in is a BedrockRequest or a ConverseRequest.
+func (c *BedrockClient) do(ctx context.Context, modelID, action, accept string, in interface{}) (*http.Response, error) {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return nil, err
+	}
+
+	// the model id is a path segment, its colon must be escaped.
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint+"/model/"+uriEncode(modelID)+"/"+action, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", accept)
+	c.signer.Sign(req, body, c.now())
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, bedrockError(resp)
+	}
+	return resp, nil
+}
+
+// bedrockError converts an error response of bedrock to an openai APIError.
+func bedrockError(resp *http.Response) error {
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	var body struct {
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(data, &body); err != nil || body.Message == "" {
+		body.Message = strings.TrimSpace(string(data))
+	}
+	if errorType := resp.Header.Get("X-Amzn-Errortype"); errorType != "" {
+		// e.g. ValidationException:http://internal.amazon.com/coral/com.amazon.bedrock/
+		errorType, _, _ = strings.Cut(errorType, ":")
+		body.Message = errorType + ": " + body.Message
+	}
+	return corekit.NewError(resp.StatusCode, body.Message)
+}
+
+// InvokeModel invokes the model with the request, e.g. anthropic.claude-3-haiku-20240307-v1:0.
+func (c *BedrockClient) InvokeModel(ctx context.Context, modelID string, in *BedrockRequest) (*ClaudeResponse, error) {
+	resp, err := c.do(ctx, modelID, "invoke", "application/json", in)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var out ClaudeResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("decode bedrock response: %w", err)
+	}
+	return &out, nil
+}
+
+// InvokeModelWithResponseStream invokes the model with the request and streams the Claude events,
+// the stream must be closed.
+func (c *BedrockClient) InvokeModelWithResponseStream(ctx context.Context, modelID string, in *BedrockRequest) (*BedrockStream, error) {
+	resp, err := c.do(ctx, modelID, "invoke-with-response-stream", "application/vnd.amazon.eventstream", in)
+	if err != nil {
+		return nil, err
+	}
+	return &BedrockStream{body: resp.Body, decoder: NewEventStreamDecoder(resp.Body)}, nil
+}
+
+// Converse calls the model-agnostic Converse API of the model, e.g. meta.llama3-70b-instruct-v1:0.
+func (c *BedrockClient) Converse(ctx context.Context, modelID string, in *ConverseRequest) (*ConverseResponse, error) {
+	resp, err := c.do(ctx, modelID, "converse", "application/json", in)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var out ConverseResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("decode converse response: %w", err)
+	}
+	return &out, nil
+}
+
+// ConverseStream calls the ConverseStream API of the model, the stream must be closed.
+func (c *BedrockClient) ConverseStream(ctx context.Context, modelID string, in *ConverseRequest) (*ConverseStream, error) {
+	resp, err := c.do(ctx, modelID, "converse-stream", "application/vnd.amazon.eventstream", in)
+	if err != nil {
+		return nil, err
+	}
+	return &ConverseStream{body: resp.Body, decoder: NewEventStreamDecoder(resp.Body)}, nil
+}
+
+// ConverseStream is the stream of the ConverseStream API.
+type ConverseStream struct {
+	body    io.ReadCloser
+	decoder *EventStreamDecoder
+}
+
+// Recv returns the next event, io.EOF at the end of the stream. Unlike InvokeModelWithResponseStream,
+// the payload is the event itself.
+func (s *ConverseStream) Recv() (*ConverseStreamEvent, error) {
+	message, err := s.decoder.Decode()
+	if err != nil {
+		return nil, err
+	}
+
+	switch message.Header(":message-type") {
+	case "exception":
+		return nil, bedrockStreamError(message.Header(":exception-type"), message.Payload)
+	case "error":
+		return nil, corekit.NewError(http.StatusInternalServerError, message.Header(":error-code")+": "+message.Header(":error-message"))
+	}
+
+	event := ConverseStreamEvent{Type: message.Header(":event-type")}
+	if err := json.Unmarshal(message.Payload, &event); err != nil {
+		return nil, fmt.Errorf("decode converse event: %w", err)
+	}
+	return &event, nil
+}
+
+// Close closes the stream.
+func (s *ConverseStream) Close() error {
+	return s.body.Close()
+}
+
+// BedrockStream is the stream of InvokeModelWithResponseStream.
+type BedrockStream struct {
+	body    io.ReadCloser
+	decoder *EventStreamDecoder
+}
+
+// Recv returns the next Claude event, io.EOF at the end of the stream. An exception of
+// the stream is returned as an openai APIError.
+func (s *BedrockStream) Recv() (*ClaudeResponse, error) {
+	for {
+		message, err := s.decoder.Decode()
+		if err != nil {
+			return nil, err
+		}
+
+		switch message.Header(":message-type") {
+		case "exception":
+			return nil, bedrockStreamError(message.Header(":exception-type"), message.Payload)
+		case "error":
+			return nil, corekit.NewError(http.StatusInternalServerError, message.Header(":error-code")+": "+message.Header(":error-message"))
+		}
+		if message.Header(":event-type") != "chunk" {
+			continue
+		}
+
+		// the bytes are base64 in JSON, they are decoded by the []byte field.
+		var chunk struct {
+			Bytes []byte `json:"bytes"`
+		}
+		if err := json.Unmarshal(message.Payload, &chunk); err != nil {
+			return nil, fmt.Errorf("decode bedrock chunk: %w", err)
+		}
+		var event ClaudeResponse
+		if err := json.Unmarshal(chunk.Bytes, &event); err != nil {
+			return nil, fmt.Errorf("decode bedrock event: %w", err)
+		}
+		return &event, nil
+	}
+}
+
+// Close closes the stream.
+func (s *BedrockStream) Close() error {
+	return s.body.Close()
+}
+
+// bedrockStreamExceptions are the status codes of the stream exceptions.
+var bedrockStreamExceptions = map[string]int{
+	"internalServerException":     http.StatusInternalServerError,
+	"modelStreamErrorException":   http.StatusFailedDependency,
+	"modelTimeoutException":       http.StatusRequestTimeout,
+	"serviceUnavailableException": http.StatusServiceUnavailable,
+	"throttlingException":         http.StatusTooManyRequests,
+	"validationException":         http.StatusBadRequest,
+}
+
+// bedrockStreamError converts an exception of the stream to an openai APIError.
+func bedrockStreamError(exceptionType string, payload []byte) error {
+	var body struct {
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(payload, &body); err != nil || body.Message == "" {
+		body.Message = string(payload)
+	}
+	code, ok := bedrockStreamExceptions[exceptionType]
+	if !ok {
+		code = http.StatusInternalServerError
+	}
+	return corekit.NewError(code, exceptionType+": "+body.Message)
+}
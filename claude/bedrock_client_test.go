@@ -0,0 +1,116 @@
+package claude
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	sysopenai "github.com/sashabaranov/go-openai"
+)
+
+func TestSignerVanilla(t *testing.T) {
+	// get-vanilla of the AWS Signature Version 4 test suite.
+	req, _ := http.NewRequest(http.MethodGet, "https://example.amazonaws.com/", nil)
+	signer := NewSigner(Credentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}, "us-east-1", "service")
+	signer.Sign(req, nil, time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+		"SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Errorf("got %s", got)
+	}
+}
+
+// encodeEventStreamMessage encodes a message with string headers like bedrock.
+func encodeEventStreamMessage(headers [][2]string, payload []byte) []byte {
+	var hdr bytes.Buffer
+	for _, h := range headers {
+		hdr.WriteByte(byte(len(h[0])))
+		hdr.WriteString(h[0])
+		hdr.WriteByte(eventStreamString)
+		binary.Write(&hdr, binary.BigEndian, uint16(len(h[1])))
+		hdr.WriteString(h[1])
+	}
+
+	var msg bytes.Buffer
+	binary.Write(&msg, binary.BigEndian, uint32(eventStreamPreludeLen+hdr.Len()+len(payload)+4))
+	binary.Write(&msg, binary.BigEndian, uint32(hdr.Len()))
+	binary.Write(&msg, binary.BigEndian, crc32.ChecksumIEEE(msg.Bytes()))
+	msg.Write(hdr.Bytes())
+	msg.Write(payload)
+	binary.Write(&msg, binary.BigEndian, crc32.ChecksumIEEE(msg.Bytes()))
+	return msg.Bytes()
+}
+
+// chunkFrame encodes a Claude event as a bedrock chunk.
+func chunkFrame(event string) []byte {
+	payload := `{"bytes":"` + base64.StdEncoding.EncodeToString([]byte(event)) + `"}`
+	return encodeEventStreamMessage([][2]string{
+		{":event-type", "chunk"}, {":content-type", "application/json"}, {":message-type", "event"},
+	}, []byte(payload))
+}
+
+func TestBedrockClientStream(t *testing.T) {
+	var stream bytes.Buffer
+	stream.Write(chunkFrame(`{"type":"message_start","message":{"id":"msg_1","role":"assistant","model":"claude-3-haiku","usage":{"input_tokens":9,"output_tokens":1}}}`))
+	stream.Write(chunkFrame(`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hi"}}`))
+	stream.Write(encodeEventStreamMessage([][2]string{
+		{":exception-type", "throttlingException"}, {":content-type", "application/json"}, {":message-type", "exception"},
+	}, []byte(`{"message":"Too many requests"}`)))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.EscapedPath() != "/model/anthropic.claude-3-haiku-20240307-v1%3A0/invoke-with-response-stream" {
+			t.Errorf("unexpected path: %s", r.URL.EscapedPath())
+		}
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") || r.Header.Get("X-Amz-Date") == "" {
+			t.Errorf("unsigned request: %v", r.Header)
+		}
+		w.Header().Set("Content-Type", "application/vnd.amazon.eventstream")
+		w.Write(stream.Bytes())
+	}))
+	defer server.Close()
+
+	client, err := NewBedrockClient(BedrockConfig{Region: "us-east-1", AccessKeyID: "AKID", SecretAccessKey: "SECRET", Endpoint: server.URL}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.InvokeModelWithResponseStream(context.Background(), "anthropic.claude-3-haiku-20240307-v1:0", &BedrockRequest{MaxTokens: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Close()
+
+	event, err := resp.Recv()
+	if err != nil || event.Type != EventMessageStart || event.Message.Usage.InputTokens != 9 {
+		t.Fatalf("unexpected event: %+v, %v", event, err)
+	}
+	event, err = resp.Recv()
+	if err != nil || event.Delta.Text != "Hi" {
+		t.Fatalf("unexpected event: %+v, %v", event, err)
+	}
+	_, err = resp.Recv()
+	var apiErr *sysopenai.APIError
+	if !errors.As(err, &apiErr) || apiErr.HTTPStatusCode != http.StatusTooManyRequests {
+		t.Errorf("expected a throttling error, got %v", err)
+	}
+	if _, err := resp.Recv(); !errors.Is(err, io.EOF) {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+}
+
+func TestEventStreamDecoderCRC(t *testing.T) {
+	frame := chunkFrame(`{"type":"ping"}`)
+	frame[len(frame)-5] ^= 0xff
+	if _, err := NewEventStreamDecoder(bytes.NewReader(frame)).Decode(); err == nil || !strings.Contains(err.Error(), "crc") {
+		t.Errorf("expected a crc error, got %v", err)
+	}
+}
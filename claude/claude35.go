@@ -17,7 +17,13 @@ limitations under the License.
 package claude
 
 import (
+	"context"
+	"fmt"
+	"strings"
+
 	"github.com/bytemind-io/corekit/openai"
+	"github.com/bytemind-io/corekit/token"
+	sysopenai "github.com/sashabaranov/go-openai"
 	"github.com/spf13/cast"
 )
 
@@ -31,17 +37,28 @@ type ClaudeRequest struct {
 	Stream            bool        `json:"stream"`
 	Metadata          interface{} `json:"metadata,omitempty"`
 	MaxTokensToSample float64     `json:"max_tokens_to_sample,omitempty"`
-	Temperature       float64     `json:"temperature"`
-	TopP              float64     `json:"top_p"`
-	TopK              float64     `json:"top_k"`
+	Temperature       *float64    `json:"temperature,omitempty"` // 为空时 Claude 默认 1, 显式的 0 需保留
+	TopP              *float64    `json:"top_p,omitempty"`
+	TopK              *float64    `json:"top_k,omitempty"`
+	StopSequences     []string    `json:"stop_sequences,omitempty"`
+	Tools             []Tool      `json:"tools,omitempty"`
+	ToolChoice        *ToolChoice `json:"tool_choice,omitempty"`
+}
+
+// Metadata is the metadata of a request, UserID is an opaque id of the end user.
+type Metadata struct {
+	UserID string `json:"user_id,omitempty"`
 }
 
 // OpenaiConvertClaude is the request for chat. https://docs.anthropic.com/claude/reference/messages_post
 func OpenaiConvertClaude(r openai.ChatCompletionRequest) *ClaudeRequest {
 	req := &ClaudeRequest{
-		Model:     r.Model,
-		Stream:    r.Stream,
-		MaxTokens: r.MaxTokens,
+		Model:       r.Model,
+		Stream:      r.Stream,
+		MaxTokens:   token.ClampMaxTokens(r.Model, r.MaxTokens),
+		Temperature: sampling(r.Temperature),
+		TopP:        sampling(r.TopP),
+		TopK:        sampling(r.TopK),
 	}
 
 	for _, message := range r.Messages {
@@ -83,3 +100,151 @@ func OpenaiConvertClaude(r openai.ChatCompletionRequest) *ClaudeRequest {
 	}
 	return req
 }
+
+// sampling returns the sampling parameter of an openai request, zero is unset since go-openai
+// cannot tell an explicit zero from an omitted parameter.
+func sampling(v float32) *float64 {
+	if v == 0 {
+		return nil
+	}
+	f := float64(v)
+	return &f
+}
+
+// OpenaiAPIConvertClaude converts a request of the openai API to Claude.[OpenAI请求转换]
+// The image_url parts are converted with ImageSource and max_tokens defaults to the max output of
+// the model. A temperature above 1 is clipped to 1, it is not scaled from the 0-2 range of openai
+// to the 0-1 range of Claude, so 1.5 and 2 are both sent as 1. A temperature or top_p of 0 is treated
+// as unset, so Claude applies its default.
+func OpenaiAPIConvertClaude(ctx context.Context, in sysopenai.ChatCompletionRequest) (*ClaudeRequest, error) {
+	if in.Model == "" {
+		return nil, fmt.Errorf("model is required")
+	}
+	if len(in.Messages) == 0 {
+		return nil, fmt.Errorf("messages are required")
+	}
+	if in.MaxTokens < 0 {
+		return nil, fmt.Errorf("max_tokens must not be negative")
+	}
+	if in.N > 1 {
+		return nil, fmt.Errorf("n must be 1")
+	}
+	if in.Temperature < 0 || in.TopP < 0 || in.TopP > 1 {
+		return nil, fmt.Errorf("invalid temperature %v or top_p %v", in.Temperature, in.TopP)
+	}
+
+	req := &ClaudeRequest{
+		Model:         in.Model,
+		MaxTokens:     token.ClampMaxTokens(in.Model, in.MaxTokens),
+		Stream:        in.Stream,
+		Temperature:   sampling(in.Temperature),
+		TopP:          sampling(in.TopP),
+		StopSequences: in.Stop,
+	}
+	if req.Temperature != nil && *req.Temperature > 1 {
+		*req.Temperature = 1
+	}
+	if in.User != "" {
+		req.Metadata = Metadata{UserID: in.User}
+	}
+
+	var system []string
+	for _, message := range in.Messages {
+		switch message.Role {
+		case sysopenai.ChatMessageRoleSystem:
+			system = append(system, messageText(message))
+		case sysopenai.ChatMessageRoleUser:
+			contents, err := userContents(ctx, message)
+			if err != nil {
+				return nil, err
+			}
+			if len(contents) == 0 {
+				return nil, fmt.Errorf("user message is empty")
+			}
+			req.Messages = appendMessage(req.Messages, sysopenai.ChatMessageRoleUser, contents...)
+		case sysopenai.ChatMessageRoleAssistant:
+			var contents []Content
+			if text := messageText(message); text != "" {
+				contents = append(contents, Content{Type: "text", Text: text})
+			}
+			for _, call := range message.ToolCalls {
+				content, err := ToolUseContent(call)
+				if err != nil {
+					return nil, err
+				}
+				contents = append(contents, content)
+			}
+			if len(contents) == 0 {
+				return nil, fmt.Errorf("assistant message is empty")
+			}
+			req.Messages = appendMessage(req.Messages, sysopenai.ChatMessageRoleAssistant, contents...)
+		case sysopenai.ChatMessageRoleTool:
+			req.Messages = appendMessage(req.Messages, sysopenai.ChatMessageRoleUser, ToolResultContent(message))
+		default:
+			return nil, fmt.Errorf("unsupported role: %s", message.Role)
+		}
+	}
+	req.System = strings.Join(system, "\n\n")
+	if len(req.Messages) == 0 {
+		return nil, fmt.Errorf("a user message is required")
+	}
+
+	tools, err := ConvertOpenaiTools(in.Tools)
+	if err != nil {
+		return nil, err
+	}
+	req.Tools = tools
+	if len(tools) != 0 {
+		if req.ToolChoice, err = ConvertOpenaiToolChoice(in.ToolChoice); err != nil {
+			return nil, err
+		}
+	}
+	return req, nil
+}
+
+// messageText returns the content of the message, or its text parts joined.
+func messageText(message sysopenai.ChatCompletionMessage) string {
+	if message.Content != "" {
+		return message.Content
+	}
+	var text strings.Builder
+	for _, part := range message.MultiContent {
+		if part.Type == sysopenai.ChatMessagePartTypeText {
+			text.WriteString(part.Text)
+		}
+	}
+	return text.String()
+}
+
+// userContents converts the content of a user message to text and image blocks, the empty text is left out.
+func userContents(ctx context.Context, message sysopenai.ChatCompletionMessage) ([]Content, error) {
+	if len(message.MultiContent) == 0 {
+		if message.Content == "" {
+			return nil, nil
+		}
+		return []Content{{Type: "text", Text: message.Content}}, nil
+	}
+
+	contents := make([]Content, 0, len(message.MultiContent))
+	for _, part := range message.MultiContent {
+		switch part.Type {
+		case sysopenai.ChatMessagePartTypeText:
+			// Claude rejects an empty text block.
+			if part.Text != "" {
+				contents = append(contents, Content{Type: "text", Text: part.Text})
+			}
+		case sysopenai.ChatMessagePartTypeImageURL:
+			if part.ImageURL == nil {
+				return nil, fmt.Errorf("image_url is required")
+			}
+			source, err := ImageSource(ctx, part.ImageURL.URL)
+			if err != nil {
+				return nil, err
+			}
+			contents = append(contents, Content{Type: "image", Source: source})
+		default:
+			return nil, fmt.Errorf("unsupported content type: %s", part.Type)
+		}
+	}
+	return contents, nil
+}
@@ -0,0 +1,133 @@
+package claude
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	sysopenai "github.com/sashabaranov/go-openai"
+)
+
+func TestOpenaiAPIConvertClaude(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 1, 1))); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(buf.Bytes())
+	}))
+	defer server.Close()
+
+	in := sysopenai.ChatCompletionRequest{
+		Model:       "claude-3-5-sonnet-20240620",
+		Temperature: 1.5,
+		Stop:        []string{"END"},
+		User:        "user-1",
+		Messages: []sysopenai.ChatCompletionMessage{
+			{Role: sysopenai.ChatMessageRoleSystem, Content: "Be brief."},
+			{Role: sysopenai.ChatMessageRoleUser, MultiContent: []sysopenai.ChatMessagePart{
+				{Type: sysopenai.ChatMessagePartTypeText, Text: "Compare these."},
+				{Type: sysopenai.ChatMessagePartTypeImageURL, ImageURL: &sysopenai.ChatMessageImageURL{URL: server.URL + "/a.png"}},
+				{Type: sysopenai.ChatMessagePartTypeImageURL, ImageURL: &sysopenai.ChatMessageImageURL{URL: "data:image/jpeg;base64,/9j/AA=="}},
+			}},
+		},
+	}
+	req, err := OpenaiAPIConvertClaude(context.Background(), in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.System != "Be brief." || req.Temperature == nil || *req.Temperature != 1 || req.MaxTokens <= 0 || req.StopSequences[0] != "END" {
+		t.Errorf("unexpected request: %+v", req)
+	}
+	if metadata, ok := req.Metadata.(Metadata); !ok || metadata.UserID != "user-1" {
+		t.Errorf("unexpected metadata: %+v", req.Metadata)
+	}
+
+	contents := req.Messages[0].Content.([]Content)
+	if len(contents) != 3 || contents[1].Source.MediaType != "image/png" || contents[1].Source.Data != base64.StdEncoding.EncodeToString(buf.Bytes()) {
+		t.Errorf("unexpected http image: %+v", contents[1].Source)
+	}
+	if contents[2].Source.MediaType != "image/jpeg" || contents[2].Source.Data != "/9j/AA==" {
+		t.Errorf("unexpected data image: %+v", contents[2].Source)
+	}
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "top_p") || strings.Contains(string(data), "top_k") {
+		t.Errorf("unset sampling parameters are serialized: %s", data)
+	}
+
+	in.MaxTokens = -1
+	if _, err := OpenaiAPIConvertClaude(context.Background(), in); err == nil {
+		t.Error("expected an error for the negative max_tokens")
+	}
+}
+
+func TestClaudeRequestSampling(t *testing.T) {
+	var req ClaudeRequest
+	if err := json.Unmarshal([]byte(`{"model":"claude-3-5-sonnet-20240620","max_tokens":10,"temperature":0,"messages":[]}`), &req); err != nil {
+		t.Fatal(err)
+	}
+	// an explicit zero is kept, otherwise Claude defaults to 1.
+	data, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"temperature":0`) || strings.Contains(string(data), "top_p") {
+		t.Errorf("unexpected request: %s", data)
+	}
+	out, err := req.Openai()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// go-openai omits the zero, so it is unset.
+	if out.Temperature != 0 || out.TopP != 0 {
+		t.Errorf("unexpected openai sampling: %v, %v", out.Temperature, out.TopP)
+	}
+
+	converted, err := OpenaiAPIConvertClaude(context.Background(), sysopenai.ChatCompletionRequest{
+		Model:    "claude-3-5-sonnet-20240620",
+		TopP:     0.5,
+		Messages: []sysopenai.ChatCompletionMessage{{Role: sysopenai.ChatMessageRoleUser, Content: "hello"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if converted.Temperature != nil || converted.TopP == nil || *converted.TopP != 0.5 {
+		t.Errorf("unexpected sampling: %v, %v", converted.Temperature, converted.TopP)
+	}
+}
+
+func TestOpenaiAPIConvertClaudeEmptyText(t *testing.T) {
+	req, err := OpenaiAPIConvertClaude(context.Background(), sysopenai.ChatCompletionRequest{
+		Model: "claude-3-5-sonnet-20240620",
+		Messages: []sysopenai.ChatCompletionMessage{{Role: sysopenai.ChatMessageRoleUser, MultiContent: []sysopenai.ChatMessagePart{
+			{Type: sysopenai.ChatMessagePartTypeText, Text: ""},
+			{Type: sysopenai.ChatMessagePartTypeText, Text: "hello"},
+		}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Claude rejects the empty text block.
+	if contents := req.Messages[0].Content.([]Content); len(contents) != 1 || contents[0].Text != "hello" {
+		t.Errorf("unexpected contents: %+v", contents)
+	}
+
+	_, err = OpenaiAPIConvertClaude(context.Background(), sysopenai.ChatCompletionRequest{
+		Model:    "claude-3-5-sonnet-20240620",
+		Messages: []sysopenai.ChatCompletionMessage{{Role: sysopenai.ChatMessageRoleUser}},
+	})
+	if err == nil {
+		t.Error("expected an error for the empty user message")
+	}
+}
@@ -0,0 +1,605 @@
+/*
+Copyright 2024 The corego Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package claude
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	sysopenai "github.com/sashabaranov/go-openai"
+)
+
+// the stop reasons of the Converse API.
+const (
+	ConverseStopEndTurn             = "end_turn"
+	ConverseStopToolUse             = "tool_use"
+	ConverseStopMaxTokens           = "max_tokens"
+	ConverseStopSequence            = "stop_sequence"
+	ConverseStopGuardrailIntervened = "guardrail_intervened"
+	ConverseStopContentFiltered     = "content_filtered"
+)
+
+// ConverseRequest is the request of the model-agnostic Converse API of bedrock.
+// https://docs.aws.amazon.com/bedrock/latest/APIReference/API_runtime_Converse.html
+type ConverseRequest struct {
+	Messages                     []ConverseMessage        `json:"messages"`
+	System                       []ConverseContent        `json:"system,omitempty"`
+	InferenceConfig              *ConverseInferenceConfig `json:"inferenceConfig,omitempty"`
+	ToolConfig                   *ConverseToolConfig      `json:"toolConfig,omitempty"`
+	AdditionalModelRequestFields interface{}              `json:"additionalModelRequestFields,omitempty"`
+}
+
+// ConverseMessage is a message of the Converse API.
+type ConverseMessage struct {
+	Role    string            `json:"role"`
+	Content []ConverseContent `json:"content"`
+}
+
+// ConverseContent is a content block, only one of the fields is set.
+type ConverseContent struct {
+	Text       string              `json:"text,omitempty"`
+	Image      *ConverseImage      `json:"image,omitempty"`
+	ToolUse    *ConverseToolUse    `json:"toolUse,omitempty"`
+	ToolResult *ConverseToolResult `json:"toolResult,omitempty"`
+	JSON       json.RawMessage     `json:"json,omitempty"` // toolResult
+}
+
+// MarshalJSON implements the json.Marshaler interface, a block without any field is an empty text
+// block, which omitempty would encode as {} that bedrock rejects.
+func (c ConverseContent) MarshalJSON() ([]byte, error) {
+	if c.Text == "" && c.Image == nil && c.ToolUse == nil && c.ToolResult == nil && c.JSON == nil {
+		return []byte(`{"text":""}`), nil
+	}
+	type alias ConverseContent
+	return json.Marshal(alias(c))
+}
+
+// ConverseImage is an image block, the bytes are base64 in JSON.
+type ConverseImage struct {
+	Format string `json:"format"` // png, jpeg, gif or webp
+	Source struct {
+		Bytes []byte `json:"bytes"`
+	} `json:"source"`
+}
+
+// ConverseToolUse is a tool use block.
+type ConverseToolUse struct {
+	ToolUseID string          `json:"toolUseId"`
+	Name      string          `json:"name"`
+	Input     json.RawMessage `json:"input,omitempty"`
+}
+
+// ConverseToolResult is a tool result block.
+type ConverseToolResult struct {
+	ToolUseID string            `json:"toolUseId"`
+	Content   []ConverseContent `json:"content"`
+	Status    string            `json:"status,omitempty"` // success or error
+}
+
+// ConverseInferenceConfig is the inference parameters of a request.
+type ConverseInferenceConfig struct {
+	MaxTokens     int      `json:"maxTokens,omitempty"`
+	Temperature   *float32 `json:"temperature,omitempty"`
+	TopP          *float32 `json:"topP,omitempty"`
+	StopSequences []string `json:"stopSequences,omitempty"`
+}
+
+// ConverseToolConfig is the tools of a request.
+type ConverseToolConfig struct {
+	Tools      []ConverseTool      `json:"tools"`
+	ToolChoice *ConverseToolChoice `json:"toolChoice,omitempty"`
+}
+
+// ConverseTool is a tool, ToolSpec is the only kind of tool.
+type ConverseTool struct {
+	ToolSpec *ConverseToolSpec `json:"toolSpec"`
+}
+
+// ConverseToolSpec is the specification of a tool.
+type ConverseToolSpec struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	InputSchema struct {
+		JSON interface{} `json:"json"`
+	} `json:"inputSchema"`
+}
+
+// ConverseToolChoice is how the model uses the tools, only one of the fields is set.
+type ConverseToolChoice struct {
+	Auto *struct{} `json:"auto,omitempty"`
+	Any  *struct{} `json:"any,omitempty"`
+	Tool *struct {
+		Name string `json:"name"`
+	} `json:"tool,omitempty"`
+}
+
+// ConverseUsage is the usage of the Converse API.
+type ConverseUsage struct {
+	InputTokens  int `json:"inputTokens"`
+	OutputTokens int `json:"outputTokens"`
+	TotalTokens  int `json:"totalTokens"`
+}
+
+// ConverseResponse is the response of the Converse API.
+type ConverseResponse struct {
+	Output struct {
+		Message *ConverseMessage `json:"message,omitempty"`
+	} `json:"output"`
+	StopReason string        `json:"stopReason"`
+	Usage      ConverseUsage `json:"usage"`
+	Metrics    *struct {
+		LatencyMs int64 `json:"latencyMs"`
+	} `json:"metrics,omitempty"`
+}
+
+// ConverseFinishReason maps the stop reason of the Converse API to the finish reason of openai.
+func ConverseFinishReason(stopReason string) sysopenai.FinishReason {
+	switch stopReason {
+	case ConverseStopEndTurn, ConverseStopSequence:
+		return sysopenai.FinishReasonStop
+	case ConverseStopMaxTokens:
+		return sysopenai.FinishReasonLength
+	case ConverseStopToolUse:
+		return sysopenai.FinishReasonToolCalls
+	case ConverseStopGuardrailIntervened, ConverseStopContentFiltered:
+		return sysopenai.FinishReasonContentFilter
+	}
+	return sysopenai.FinishReason(stopReason)
+}
+
+// ConverseStopReason maps the finish reason of openai to the stop reason of the Converse API.
+func ConverseStopReason(finishReason sysopenai.FinishReason) string {
+	switch finishReason {
+	case sysopenai.FinishReasonLength:
+		return ConverseStopMaxTokens
+	case sysopenai.FinishReasonToolCalls, sysopenai.FinishReasonFunctionCall:
+		return ConverseStopToolUse
+	case sysopenai.FinishReasonContentFilter:
+		return ConverseStopContentFiltered
+	}
+	return ConverseStopEndTurn
+}
+
+// appendConverseMessage appends the contents as a message of the role, they are merged into the last
+// message of the same role since the roles must alternate.
+func appendConverseMessage(msgs []ConverseMessage, role string, contents ...ConverseContent) []ConverseMessage {
+	if len(msgs) != 0 && msgs[len(msgs)-1].Role == role {
+		msgs[len(msgs)-1].Content = append(msgs[len(msgs)-1].Content, contents...)
+		return msgs
+	}
+	return append(msgs, ConverseMessage{Role: role, Content: contents})
+}
+
+// OpenaiConvertConverse converts a request of the openai API to the Converse API, the model is not
+// part of the body.[Converse请求转换] The image_url parts, also those of the tool results, are
+// converted with ImageSource. A tool_choice of none leaves out the tools since Converse has no such
+// choice.
+func OpenaiConvertConverse(ctx context.Context, in sysopenai.ChatCompletionRequest) (*ConverseRequest, error) {
+	if len(in.Messages) == 0 {
+		return nil, fmt.Errorf("messages are required")
+	}
+	if in.MaxTokens < 0 {
+		return nil, fmt.Errorf("max_tokens must not be negative")
+	}
+
+	req := &ConverseRequest{}
+	for _, message := range in.Messages {
+		switch message.Role {
+		case sysopenai.ChatMessageRoleSystem:
+			if text := messageText(message); text != "" {
+				req.System = append(req.System, ConverseContent{Text: text})
+			}
+		case sysopenai.ChatMessageRoleUser:
+			contents, err := converseUserContents(ctx, message)
+			if err != nil {
+				return nil, err
+			}
+			if len(contents) == 0 {
+				return nil, fmt.Errorf("user message is empty")
+			}
+			req.Messages = appendConverseMessage(req.Messages, sysopenai.ChatMessageRoleUser, contents...)
+		case sysopenai.ChatMessageRoleAssistant:
+			var contents []ConverseContent
+			if text := messageText(message); text != "" {
+				contents = append(contents, ConverseContent{Text: text})
+			}
+			for _, call := range message.ToolCalls {
+				content, err := ToolUseContent(call)
+				if err != nil {
+					return nil, err
+				}
+				contents = append(contents, ConverseContent{ToolUse: &ConverseToolUse{ToolUseID: content.ID, Name: content.Name, Input: content.Input}})
+			}
+			if len(contents) == 0 {
+				return nil, fmt.Errorf("assistant message is empty")
+			}
+			req.Messages = appendConverseMessage(req.Messages, sysopenai.ChatMessageRoleAssistant, contents...)
+		case sysopenai.ChatMessageRoleTool:
+			// the image parts of a tool result become image blocks of the result.
+			contents, err := converseUserContents(ctx, message)
+			if err != nil {
+				return nil, err
+			}
+			// a tool may return nothing, the result still needs a block.
+			if len(contents) == 0 {
+				contents = []ConverseContent{{Text: ""}}
+			}
+			req.Messages = appendConverseMessage(req.Messages, sysopenai.ChatMessageRoleUser, ConverseContent{ToolResult: &ConverseToolResult{
+				ToolUseID: message.ToolCallID,
+				Content:   contents,
+			}})
+		default:
+			return nil, fmt.Errorf("unsupported role: %s", message.Role)
+		}
+	}
+	if len(req.Messages) == 0 {
+		return nil, fmt.Errorf("a user message is required")
+	}
+
+	config := &ConverseInferenceConfig{MaxTokens: in.MaxTokens, StopSequences: in.Stop}
+	if in.Temperature != 0 {
+		config.Temperature = &in.Temperature
+	}
+	if in.TopP != 0 {
+		config.TopP = &in.TopP
+	}
+	if config.MaxTokens != 0 || config.Temperature != nil || config.TopP != nil || len(config.StopSequences) != 0 {
+		req.InferenceConfig = config
+	}
+
+	tools, err := ConvertOpenaiTools(in.Tools)
+	if err != nil {
+		return nil, err
+	}
+	choice, err := ConvertOpenaiToolChoice(in.ToolChoice)
+	if err != nil {
+		return nil, err
+	}
+	// Converse has no choice of none, the tools are left out instead.
+	if len(tools) != 0 && (choice == nil || choice.Type != ToolChoiceNone) {
+		req.ToolConfig = &ConverseToolConfig{}
+		for _, tool := range tools {
+			spec := &ConverseToolSpec{Name: tool.Name, Description: tool.Description}
+			spec.InputSchema.JSON = tool.InputSchema
+			req.ToolConfig.Tools = append(req.ToolConfig.Tools, ConverseTool{ToolSpec: spec})
+		}
+		if choice != nil {
+			req.ToolConfig.ToolChoice = converseToolChoice(*choice)
+		}
+	}
+	return req, nil
+}
+
+// converseToolChoice converts a tool choice of Claude, nil for ToolChoiceNone.
+func converseToolChoice(choice ToolChoice) *ConverseToolChoice {
+	switch choice.Type {
+	case ToolChoiceAuto:
+		return &ConverseToolChoice{Auto: &struct{}{}}
+	case ToolChoiceAny:
+		return &ConverseToolChoice{Any: &struct{}{}}
+	case ToolChoiceTool:
+		out := &ConverseToolChoice{Tool: &struct {
+			Name string `json:"name"`
+		}{}}
+		out.Tool.Name = choice.Name
+		return out
+	}
+	return nil
+}
+
+// converseUserContents converts the content of a user message to text and image blocks, the empty
+// text is left out.
+func converseUserContents(ctx context.Context, message sysopenai.ChatCompletionMessage) ([]ConverseContent, error) {
+	contents, err := userContents(ctx, message)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]ConverseContent, 0, len(contents))
+	for _, content := range contents {
+		if content.Type != "image" {
+			out = append(out, ConverseContent{Text: content.Text})
+			continue
+		}
+		data, err := base64.StdEncoding.DecodeString(content.Source.Data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid image data: %w", err)
+		}
+		image := &ConverseImage{Format: strings.TrimPrefix(content.Source.MediaType, "image/")}
+		image.Source.Bytes = data
+		out = append(out, ConverseContent{Image: image})
+	}
+	return out, nil
+}
+
+// Openai converts a request of the Converse API to openai, the model is given by the path of the request.
+func (r *ConverseRequest) Openai(model string) (*sysopenai.ChatCompletionRequest, error) {
+	req := &sysopenai.ChatCompletionRequest{Model: model}
+	if c := r.InferenceConfig; c != nil {
+		req.MaxTokens = c.MaxTokens
+		req.Stop = c.StopSequences
+		if c.Temperature != nil {
+			req.Temperature = *c.Temperature
+		}
+		if c.TopP != nil {
+			req.TopP = *c.TopP
+		}
+	}
+
+	var system []string
+	for _, content := range r.System {
+		if content.Text != "" {
+			system = append(system, content.Text)
+		}
+	}
+	if len(system) != 0 {
+		req.Messages = append(req.Messages, sysopenai.ChatCompletionMessage{Role: sysopenai.ChatMessageRoleSystem, Content: strings.Join(system, "\n")})
+	}
+
+	for _, message := range r.Messages {
+		var contents Contents
+		for _, content := range message.Content {
+			switch {
+			case content.Text != "":
+				contents = append(contents, Content{Type: "text", Text: content.Text})
+			case content.Image != nil:
+				contents = append(contents, Content{Type: "image", Source: &Source{
+					Type:      "base64",
+					MediaType: "image/" + content.Image.Format,
+					Data:      base64.StdEncoding.EncodeToString(content.Image.Source.Bytes),
+				}})
+			case content.ToolUse != nil:
+				contents = append(contents, Content{Type: ContentTypeToolUse, ID: content.ToolUse.ToolUseID, Name: content.ToolUse.Name, Input: content.ToolUse.Input})
+			case content.ToolResult != nil:
+				contents = append(contents, Content{
+					Type:      ContentTypeToolResult,
+					ToolUseID: content.ToolResult.ToolUseID,
+					Content:   converseResultText(content.ToolResult),
+					IsError:   content.ToolResult.Status == "error",
+				})
+			}
+		}
+
+		switch message.Role {
+		case sysopenai.ChatMessageRoleUser:
+			messages, err := userMessages(contents)
+			if err != nil {
+				return nil, err
+			}
+			req.Messages = append(req.Messages, messages...)
+		case sysopenai.ChatMessageRoleAssistant:
+			out := sysopenai.ChatCompletionMessage{Role: sysopenai.ChatMessageRoleAssistant}
+			for _, content := range contents {
+				if content.Type == ContentTypeToolUse {
+					out.ToolCalls = append(out.ToolCalls, content.ToolCall())
+				} else {
+					out.Content += content.Text
+				}
+			}
+			req.Messages = append(req.Messages, out)
+		default:
+			return nil, fmt.Errorf("unsupported role: %s", message.Role)
+		}
+	}
+
+	if c := r.ToolConfig; c != nil {
+		for _, tool := range c.Tools {
+			if tool.ToolSpec != nil {
+				req.Tools = append(req.Tools, Tool{Name: tool.ToolSpec.Name, Description: tool.ToolSpec.Description, InputSchema: tool.ToolSpec.InputSchema.JSON}.Openai())
+			}
+		}
+		if choice := c.ToolChoice; choice != nil {
+			switch {
+			case choice.Any != nil:
+				req.ToolChoice = "required"
+			case choice.Tool != nil:
+				req.ToolChoice = ToolChoice{Type: ToolChoiceTool, Name: choice.Tool.Name}.Openai()
+			default:
+				req.ToolChoice = "auto"
+			}
+		}
+	}
+	return req, nil
+}
+
+// converseResultText joins the text and JSON blocks of a tool result.
+func converseResultText(result *ConverseToolResult) string {
+	var text strings.Builder
+	for _, content := range result.Content {
+		text.WriteString(content.Text)
+		text.Write(content.JSON)
+	}
+	return text.String()
+}
+
+// Openai converts the ConverseResponse to an openai response of the model.
+func (r *ConverseResponse) Openai(model string) sysopenai.ChatCompletionResponse {
+	message := sysopenai.ChatCompletionMessage{Role: sysopenai.ChatMessageRoleAssistant}
+	if r.Output.Message != nil {
+		for _, content := range r.Output.Message.Content {
+			if content.ToolUse != nil {
+				message.ToolCalls = append(message.ToolCalls, Content{
+					ID:    content.ToolUse.ToolUseID,
+					Name:  content.ToolUse.Name,
+					Input: content.ToolUse.Input,
+				}.ToolCall())
+				continue
+			}
+			message.Content += content.Text
+		}
+	}
+
+	return sysopenai.ChatCompletionResponse{
+		ID:      "chatcmpl-" + uuid.NewString(),
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   model,
+		Choices: []sysopenai.ChatCompletionChoice{
+			{Index: 0, Message: message, FinishReason: ConverseFinishReason(r.StopReason)},
+		},
+		Usage: sysopenai.Usage{
+			PromptTokens:     r.Usage.InputTokens,
+			CompletionTokens: r.Usage.OutputTokens,
+			TotalTokens:      r.Usage.InputTokens + r.Usage.OutputTokens,
+		},
+	}
+}
+
+// ConvertOpenaiConverseResponse converts an openai response to the response of the Converse API,
+// the first choice is used.
+func ConvertOpenaiConverseResponse(resp sysopenai.ChatCompletionResponse) *ConverseResponse {
+	out := &ConverseResponse{
+		StopReason: ConverseStopEndTurn,
+		Usage: ConverseUsage{
+			InputTokens:  resp.Usage.PromptTokens,
+			OutputTokens: resp.Usage.CompletionTokens,
+			TotalTokens:  resp.Usage.PromptTokens + resp.Usage.CompletionTokens,
+		},
+	}
+	out.Output.Message = &ConverseMessage{Role: sysopenai.ChatMessageRoleAssistant, Content: []ConverseContent{}}
+	if len(resp.Choices) == 0 {
+		return out
+	}
+
+	choice := resp.Choices[0]
+	if choice.Message.Content != "" {
+		out.Output.Message.Content = append(out.Output.Message.Content, ConverseContent{Text: choice.Message.Content})
+	}
+	for _, call := range choice.Message.ToolCalls {
+		out.Output.Message.Content = append(out.Output.Message.Content, ConverseContent{ToolUse: &ConverseToolUse{
+			ToolUseID: call.ID,
+			Name:      call.Function.Name,
+			Input:     toolInput(call.Function.Arguments),
+		}})
+	}
+	out.StopReason = ConverseStopReason(choice.FinishReason)
+	return out
+}
+
+// ConverseStreamEvent is an event of ConverseStream, Type is the event type of the frame,
+// e.g. messageStart, contentBlockStart, contentBlockDelta, contentBlockStop, messageStop or metadata.
+type ConverseStreamEvent struct {
+	Type              string `json:"-"`
+	Role              string `json:"role,omitempty"`
+	ContentBlockIndex int    `json:"contentBlockIndex"`
+	Start             *struct {
+		ToolUse *ConverseToolUse `json:"toolUse,omitempty"`
+	} `json:"start,omitempty"`
+	Delta *struct {
+		Text    string `json:"text,omitempty"`
+		ToolUse *struct {
+			Input string `json:"input"`
+		} `json:"toolUse,omitempty"`
+	} `json:"delta,omitempty"`
+	StopReason string         `json:"stopReason,omitempty"`
+	Usage      *ConverseUsage `json:"usage,omitempty"`
+}
+
+// ConverseStreamConverter converts the events of ConverseStream to openai chunks.
+type ConverseStreamConverter struct {
+	id          string
+	model       string
+	created     int64
+	toolIndexes map[int]int // content block index to tool call index
+}
+
+// NewConverseStreamConverter creates a ConverseStreamConverter of the model.
+func NewConverseStreamConverter(model string) *ConverseStreamConverter {
+	return &ConverseStreamConverter{
+		id:          "chatcmpl-" + uuid.NewString(),
+		model:       model,
+		created:     time.Now().Unix(),
+		toolIndexes: make(map[int]int),
+	}
+}
+
+// chunk creates a chunk of the stream.
+func (c *ConverseStreamConverter) chunk(delta sysopenai.ChatCompletionStreamChoiceDelta, finishReason sysopenai.FinishReason) sysopenai.ChatCompletionStreamResponse {
+	return sysopenai.ChatCompletionStreamResponse{
+		ID:      c.id,
+		Object:  "chat.completion.chunk",
+		Created: c.created,
+		Model:   c.model,
+		Choices: []sysopenai.ChatCompletionStreamChoice{
+			{Index: 0, Delta: delta, FinishReason: finishReason},
+		},
+	}
+}
+
+// toolCall creates the chunk of a tool call delta of the content block.
+func (c *ConverseStreamConverter) toolCall(block int, call sysopenai.ToolCall) sysopenai.ChatCompletionStreamResponse {
+	index := c.toolIndexes[block]
+	call.Index = &index
+	call.Type = sysopenai.ToolTypeFunction
+	return c.chunk(sysopenai.ChatCompletionStreamChoiceDelta{ToolCalls: []sysopenai.ToolCall{call}}, "")
+}
+
+// Convert converts an event to the chunks to emit, metadata emits the usage chunk without choices.
+func (c *ConverseStreamConverter) Convert(event *ConverseStreamEvent) []sysopenai.ChatCompletionStreamResponse {
+	switch event.Type {
+	case "messageStart":
+		return []sysopenai.ChatCompletionStreamResponse{
+			c.chunk(sysopenai.ChatCompletionStreamChoiceDelta{Role: sysopenai.ChatMessageRoleAssistant}, ""),
+		}
+	case "contentBlockStart":
+		if event.Start != nil && event.Start.ToolUse != nil {
+			c.toolIndexes[event.ContentBlockIndex] = len(c.toolIndexes)
+			return []sysopenai.ChatCompletionStreamResponse{c.toolCall(event.ContentBlockIndex, sysopenai.ToolCall{
+				ID:       event.Start.ToolUse.ToolUseID,
+				Function: sysopenai.FunctionCall{Name: event.Start.ToolUse.Name},
+			})}
+		}
+	case "contentBlockDelta":
+		if event.Delta == nil {
+			return nil
+		}
+		if event.Delta.ToolUse != nil {
+			return []sysopenai.ChatCompletionStreamResponse{c.toolCall(event.ContentBlockIndex, sysopenai.ToolCall{
+				Function: sysopenai.FunctionCall{Arguments: event.Delta.ToolUse.Input},
+			})}
+		}
+		return []sysopenai.ChatCompletionStreamResponse{
+			c.chunk(sysopenai.ChatCompletionStreamChoiceDelta{Content: event.Delta.Text}, ""),
+		}
+	case "messageStop":
+		return []sysopenai.ChatCompletionStreamResponse{
+			c.chunk(sysopenai.ChatCompletionStreamChoiceDelta{}, ConverseFinishReason(event.StopReason)),
+		}
+	case "metadata":
+		if event.Usage == nil {
+			return nil
+		}
+		return []sysopenai.ChatCompletionStreamResponse{{
+			ID:      c.id,
+			Object:  "chat.completion.chunk",
+			Created: c.created,
+			Model:   c.model,
+			Choices: []sysopenai.ChatCompletionStreamChoice{},
+			Usage: &sysopenai.Usage{
+				PromptTokens:     event.Usage.InputTokens,
+				CompletionTokens: event.Usage.OutputTokens,
+				TotalTokens:      event.Usage.InputTokens + event.Usage.OutputTokens,
+			},
+		}}
+	}
+	return nil
+}
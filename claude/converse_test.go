@@ -0,0 +1,212 @@
+package claude
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	sysopenai "github.com/sashabaranov/go-openai"
+)
+
+func TestOpenaiConvertConverse(t *testing.T) {
+	req, err := OpenaiConvertConverse(context.Background(), sysopenai.ChatCompletionRequest{
+		Model:       "meta.llama3-70b-instruct-v1:0",
+		MaxTokens:   100,
+		Temperature: 0.5,
+		Stop:        []string{"\n\n"},
+		Messages: []sysopenai.ChatCompletionMessage{
+			{Role: sysopenai.ChatMessageRoleSystem, Content: "Be brief."},
+			{Role: sysopenai.ChatMessageRoleUser, MultiContent: []sysopenai.ChatMessagePart{
+				{Type: sysopenai.ChatMessagePartTypeText, Text: "What is this?"},
+				{Type: sysopenai.ChatMessagePartTypeImageURL, ImageURL: &sysopenai.ChatMessageImageURL{URL: "data:image/png;base64,iVBORw0KGgo="}},
+			}},
+			{Role: sysopenai.ChatMessageRoleAssistant, ToolCalls: []sysopenai.ToolCall{
+				{ID: "call_1", Type: sysopenai.ToolTypeFunction, Function: sysopenai.FunctionCall{Name: "lookup", Arguments: `{"q":"png"}`}},
+			}},
+			{Role: sysopenai.ChatMessageRoleTool, ToolCallID: "call_1", Content: "an image format"},
+		},
+		Tools: []sysopenai.Tool{
+			{Type: sysopenai.ToolTypeFunction, Function: &sysopenai.FunctionDefinition{Name: "lookup", Parameters: map[string]interface{}{"type": "object"}}},
+		},
+		ToolChoice: "required",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, _ := json.Marshal(req)
+	want := `{"messages":[{"role":"user","content":[{"text":"What is this?"},{"image":{"format":"png","source":{"bytes":"iVBORw0KGgo="}}}]},` +
+		`{"role":"assistant","content":[{"toolUse":{"toolUseId":"call_1","name":"lookup","input":{"q":"png"}}}]},` +
+		`{"role":"user","content":[{"toolResult":{"toolUseId":"call_1","content":[{"text":"an image format"}]}}]}],` +
+		`"system":[{"text":"Be brief."}],"inferenceConfig":{"maxTokens":100,"temperature":0.5,"stopSequences":["\n\n"]},` +
+		`"toolConfig":{"tools":[{"toolSpec":{"name":"lookup","inputSchema":{"json":{"type":"object"}}}}],"toolChoice":{"any":{}}}}`
+	if string(data) != want {
+		t.Errorf("got %s", data)
+	}
+
+	back, err := req.Openai("meta.llama3-70b-instruct-v1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(back.Messages) != 4 || back.Messages[1].MultiContent[1].ImageURL.URL != "data:image/png;base64,iVBORw0KGgo=" ||
+		back.Messages[3].Role != sysopenai.ChatMessageRoleTool || back.ToolChoice != "required" {
+		t.Errorf("unexpected request: %+v", back)
+	}
+}
+
+func TestOpenaiConvertConverseToolResultImage(t *testing.T) {
+	call := sysopenai.ToolCall{ID: "call_1", Type: sysopenai.ToolTypeFunction, Function: sysopenai.FunctionCall{Name: "screenshot", Arguments: `{}`}}
+	req, err := OpenaiConvertConverse(context.Background(), sysopenai.ChatCompletionRequest{
+		Messages: []sysopenai.ChatCompletionMessage{
+			{Role: sysopenai.ChatMessageRoleUser, Content: "Take a screenshot."},
+			{Role: sysopenai.ChatMessageRoleAssistant, ToolCalls: []sysopenai.ToolCall{call}},
+			{Role: sysopenai.ChatMessageRoleTool, ToolCallID: "call_1", MultiContent: []sysopenai.ChatMessagePart{
+				{Type: sysopenai.ChatMessagePartTypeText, Text: "The screen:"},
+				{Type: sysopenai.ChatMessagePartTypeImageURL, ImageURL: &sysopenai.ChatMessageImageURL{URL: "data:image/png;base64,iVBORw0KGgo="}},
+			}},
+		},
+		Tools: []sysopenai.Tool{
+			{Type: sysopenai.ToolTypeFunction, Function: &sysopenai.FunctionDefinition{Name: "screenshot", Parameters: map[string]interface{}{"type": "object"}}},
+		},
+		ToolChoice: "none",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, _ := json.Marshal(req.Messages[2])
+	want := `{"role":"user","content":[{"toolResult":{"toolUseId":"call_1","content":[{"text":"The screen:"},` +
+		`{"image":{"format":"png","source":{"bytes":"iVBORw0KGgo="}}}]}}]}`
+	if string(data) != want {
+		t.Errorf("got %s", data)
+	}
+	// Converse has no tool choice of none, so the tools are left out.
+	if req.ToolConfig != nil {
+		t.Errorf("expected no tool config, got %+v", req.ToolConfig)
+	}
+}
+
+func TestOpenaiConvertConverseEmptyContent(t *testing.T) {
+	call := sysopenai.ToolCall{ID: "call_1", Type: sysopenai.ToolTypeFunction, Function: sysopenai.FunctionCall{Name: "ping", Arguments: `{}`}}
+	req, err := OpenaiConvertConverse(context.Background(), sysopenai.ChatCompletionRequest{
+		Messages: []sysopenai.ChatCompletionMessage{
+			{Role: sysopenai.ChatMessageRoleSystem},
+			{Role: sysopenai.ChatMessageRoleUser, Content: "Ping."},
+			{Role: sysopenai.ChatMessageRoleAssistant, ToolCalls: []sysopenai.ToolCall{call}},
+			{Role: sysopenai.ChatMessageRoleTool, ToolCallID: "call_1"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// bedrock rejects the empty blocks, an empty tool result is an empty text.
+	data, _ := json.Marshal(req)
+	want := `{"messages":[{"role":"user","content":[{"text":"Ping."}]},` +
+		`{"role":"assistant","content":[{"toolUse":{"toolUseId":"call_1","name":"ping","input":{}}}]},` +
+		`{"role":"user","content":[{"toolResult":{"toolUseId":"call_1","content":[{"text":""}]}}]}]}`
+	if string(data) != want {
+		t.Errorf("got %s", data)
+	}
+
+	_, err = OpenaiConvertConverse(context.Background(), sysopenai.ChatCompletionRequest{
+		Messages: []sysopenai.ChatCompletionMessage{{Role: sysopenai.ChatMessageRoleUser}},
+	})
+	if err == nil {
+		t.Error("expected an error for the empty user message")
+	}
+}
+
+func TestConverseRequestToolResultError(t *testing.T) {
+	var req ConverseRequest
+	err := json.Unmarshal([]byte(`{"messages":[{"role":"user","content":[{"text":"Ping."}]},
+		{"role":"assistant","content":[{"toolUse":{"toolUseId":"call_1","name":"ping","input":{}}}]},
+		{"role":"user","content":[{"toolResult":{"toolUseId":"call_1","content":[{"text":"timeout"}],"status":"error"}}]}]}`), &req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := req.Openai("meta.llama3-70b-instruct-v1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg := out.Messages[2]; msg.Role != sysopenai.ChatMessageRoleTool || msg.ToolCallID != "call_1" || msg.Content != "Error: timeout" {
+		t.Errorf("unexpected tool message: %+v", msg)
+	}
+}
+
+func TestConverseResponse(t *testing.T) {
+	var resp ConverseResponse
+	if err := json.Unmarshal([]byte(`{"output":{"message":{"role":"assistant","content":[{"text":"Let me check."},`+
+		`{"toolUse":{"toolUseId":"tooluse_1","name":"lookup","input":{"q":"png"}}}]}},`+
+		`"stopReason":"tool_use","usage":{"inputTokens":12,"outputTokens":8,"totalTokens":20},"metrics":{"latencyMs":300}}`), &resp); err != nil {
+		t.Fatal(err)
+	}
+
+	out := resp.Openai("mistral.mistral-large-2402-v1:0")
+	choice := out.Choices[0]
+	if choice.FinishReason != sysopenai.FinishReasonToolCalls || choice.Message.Content != "Let me check." ||
+		choice.Message.ToolCalls[0].Function.Arguments != `{"q":"png"}` || out.Usage.TotalTokens != 20 {
+		t.Errorf("unexpected response: %+v", out)
+	}
+
+	back := ConvertOpenaiConverseResponse(out)
+	if back.StopReason != ConverseStopToolUse || back.Output.Message.Content[1].ToolUse.ToolUseID != "tooluse_1" || back.Usage != resp.Usage {
+		t.Errorf("unexpected response: %+v", back)
+	}
+}
+
+// converseFrame encodes a ConverseStream event.
+func converseFrame(eventType, payload string) []byte {
+	return encodeEventStreamMessage([][2]string{
+		{":event-type", eventType}, {":content-type", "application/json"}, {":message-type", "event"},
+	}, []byte(payload))
+}
+
+func TestBedrockClientConverseStream(t *testing.T) {
+	var stream bytes.Buffer
+	stream.Write(converseFrame("messageStart", `{"role":"assistant"}`))
+	stream.Write(converseFrame("contentBlockDelta", `{"contentBlockIndex":0,"delta":{"text":"Hi"}}`))
+	stream.Write(converseFrame("contentBlockStart", `{"contentBlockIndex":1,"start":{"toolUse":{"toolUseId":"tooluse_1","name":"lookup"}}}`))
+	stream.Write(converseFrame("contentBlockDelta", `{"contentBlockIndex":1,"delta":{"toolUse":{"input":"{\"q\":1}"}}}`))
+	stream.Write(converseFrame("messageStop", `{"stopReason":"tool_use"}`))
+	stream.Write(converseFrame("metadata", `{"usage":{"inputTokens":5,"outputTokens":7,"totalTokens":12},"metrics":{"latencyMs":10}}`))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.EscapedPath() != "/model/meta.llama3-8b-instruct-v1%3A0/converse-stream" {
+			t.Errorf("unexpected path: %s", r.URL.EscapedPath())
+		}
+		w.Header().Set("Content-Type", "application/vnd.amazon.eventstream")
+		w.Write(stream.Bytes())
+	}))
+	defer server.Close()
+
+	client, err := NewBedrockClient(BedrockConfig{Region: "us-east-1", AccessKeyID: "AKID", SecretAccessKey: "SECRET", Endpoint: server.URL}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.ConverseStream(context.Background(), "meta.llama3-8b-instruct-v1:0", &ConverseRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Close()
+
+	converter := NewConverseStreamConverter("llama3-8b")
+	var chunks []sysopenai.ChatCompletionStreamResponse
+	for {
+		event, err := resp.Recv()
+		if err != nil {
+			break
+		}
+		chunks = append(chunks, converter.Convert(event)...)
+	}
+	if len(chunks) != 6 {
+		t.Fatalf("got %d chunks", len(chunks))
+	}
+	if chunks[1].Choices[0].Delta.Content != "Hi" || chunks[2].Choices[0].Delta.ToolCalls[0].ID != "tooluse_1" ||
+		chunks[3].Choices[0].Delta.ToolCalls[0].Function.Arguments != `{"q":1}` || *chunks[3].Choices[0].Delta.ToolCalls[0].Index != 0 ||
+		chunks[4].Choices[0].FinishReason != sysopenai.FinishReasonToolCalls || chunks[5].Usage.TotalTokens != 12 {
+		t.Errorf("unexpected chunks: %+v", chunks)
+	}
+}
@@ -0,0 +1,178 @@
+/*
+Copyright 2024 The corego Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package claude
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"time"
+)
+
+const (
+	// eventStreamPreludeLen is the total length, the headers length and the prelude CRC.
+	eventStreamPreludeLen = 12
+	// eventStreamMaxMessageLen is the max length of a message of the format.
+	eventStreamMaxMessageLen = 16 << 20
+)
+
+// the header value types of the event stream format.
+const (
+	eventStreamBoolTrue = iota
+	eventStreamBoolFalse
+	eventStreamByte
+	eventStreamShort
+	eventStreamInt
+	eventStreamLong
+	eventStreamBytes
+	eventStreamString
+	eventStreamTimestamp
+	eventStreamUUID
+)
+
+// EventStreamMessage is a message of the application/vnd.amazon.eventstream format.
+// The header values are bool, int8, int16, int32, int64, []byte, string, time.Time or [16]byte.
+type EventStreamMessage struct {
+	Headers map[string]interface{}
+	Payload []byte
+}
+
+// Header returns the string header, empty if it is missing or not a string.
+func (m *EventStreamMessage) Header(name string) string {
+	value, _ := m.Headers[name].(string)
+	return value
+}
+
+// EventStreamDecoder decodes the messages of the application/vnd.amazon.eventstream format.[事件流解码]
+// https://docs.aws.amazon.com/transcribe/latest/dg/streaming-setting-up.html
+type EventStreamDecoder struct {
+	r io.Reader
+}
+
+// NewEventStreamDecoder creates an EventStreamDecoder of the reader.
+func NewEventStreamDecoder(r io.Reader) *EventStreamDecoder {
+	return &EventStreamDecoder{r: r}
+}
+
+// Decode returns the next message, io.EOF at the end of the stream. Both CRCs are verified.
+func (d *EventStreamDecoder) Decode() (*EventStreamMessage, error) {
+	prelude := make([]byte, eventStreamPreludeLen)
+	if _, err := io.ReadFull(d.r, prelude); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, fmt.Errorf("truncated event stream prelude")
+		}
+		return nil, err
+	}
+
+	totalLen := binary.BigEndian.Uint32(prelude[0:4])
+	headersLen := binary.BigEndian.Uint32(prelude[4:8])
+	if crc := crc32.ChecksumIEEE(prelude[0:8]); crc != binary.BigEndian.Uint32(prelude[8:12]) {
+		return nil, fmt.Errorf("event stream prelude crc mismatch")
+	}
+	if totalLen > eventStreamMaxMessageLen || uint64(totalLen) < uint64(eventStreamPreludeLen)+uint64(headersLen)+4 {
+		return nil, fmt.Errorf("invalid event stream message length %d with headers of %d", totalLen, headersLen)
+	}
+
+	message := make([]byte, totalLen)
+	copy(message, prelude)
+	if _, err := io.ReadFull(d.r, message[eventStreamPreludeLen:]); err != nil {
+		return nil, fmt.Errorf("truncated event stream message: %w", err)
+	}
+	if crc := crc32.ChecksumIEEE(message[:totalLen-4]); crc != binary.BigEndian.Uint32(message[totalLen-4:]) {
+		return nil, fmt.Errorf("event stream message crc mismatch")
+	}
+
+	headers, err := decodeEventStreamHeaders(message[eventStreamPreludeLen : eventStreamPreludeLen+headersLen])
+	if err != nil {
+		return nil, err
+	}
+	return &EventStreamMessage{Headers: headers, Payload: message[eventStreamPreludeLen+headersLen : totalLen-4]}, nil
+}
+
+// decodeEventStreamHeaders decodes the typed headers of a message.
+func decodeEventStreamHeaders(data []byte) (map[string]interface{}, error) {
+	headers := make(map[string]interface{})
+	errTruncated := fmt.Errorf("truncated event stream headers")
+	for len(data) != 0 {
+		nameLen := int(data[0])
+		if len(data) < 1+nameLen+1 {
+			return nil, errTruncated
+		}
+		name := string(data[1 : 1+nameLen])
+		valueType := data[1+nameLen]
+		data = data[2+nameLen:]
+
+		var (
+			value interface{}
+			size  int
+		)
+		switch valueType {
+		case eventStreamBoolTrue:
+			value = true
+		case eventStreamBoolFalse:
+			value = false
+		case eventStreamByte:
+			size = 1
+		case eventStreamShort:
+			size = 2
+		case eventStreamInt:
+			size = 4
+		case eventStreamLong, eventStreamTimestamp:
+			size = 8
+		case eventStreamUUID:
+			size = 16
+		case eventStreamBytes, eventStreamString:
+			if len(data) < 2 {
+				return nil, errTruncated
+			}
+			size = int(binary.BigEndian.Uint16(data))
+			data = data[2:]
+		default:
+			return nil, fmt.Errorf("unknown event stream header type %d of %s", valueType, name)
+		}
+		if len(data) < size {
+			return nil, errTruncated
+		}
+
+		raw := data[:size]
+		switch valueType {
+		case eventStreamByte:
+			value = int8(raw[0])
+		case eventStreamShort:
+			value = int16(binary.BigEndian.Uint16(raw))
+		case eventStreamInt:
+			value = int32(binary.BigEndian.Uint32(raw))
+		case eventStreamLong:
+			value = int64(binary.BigEndian.Uint64(raw))
+		case eventStreamTimestamp:
+			value = time.UnixMilli(int64(binary.BigEndian.Uint64(raw))).UTC()
+		case eventStreamUUID:
+			var uuid [16]byte
+			copy(uuid[:], raw)
+			value = uuid
+		case eventStreamBytes:
+			value = append([]byte(nil), raw...)
+		case eventStreamString:
+			value = string(raw)
+		}
+		headers[name] = value
+		data = data[size:]
+	}
+	return headers, nil
+}
@@ -0,0 +1,90 @@
+/*
+Copyright 2024 The corego Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package claude
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gabriel-vasile/mimetype"
+)
+
+// MaxImageSize is the max size of an image downloaded for Claude, the API rejects larger images.
+const MaxImageSize = 5 << 20
+
+// ImageClient downloads the images of the http image_url parts.
+var ImageClient = &http.Client{Timeout: 30 * time.Second}
+
+// supportedImageTypes are the media types of images accepted by Claude.
+var supportedImageTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// ImageSource converts the url of an image_url part, a data URL or an http URL, to a base64 source.
+// An http image is downloaded with ImageClient.[图片转换]
+func ImageSource(ctx context.Context, url string) (*Source, error) {
+	if rest, ok := strings.CutPrefix(url, "data:"); ok {
+		mediaType, data, ok := strings.Cut(rest, ",")
+		if !ok || !strings.HasSuffix(mediaType, ";base64") {
+			return nil, fmt.Errorf("invalid image data url")
+		}
+		mediaType = strings.TrimSuffix(mediaType, ";base64")
+		if !supportedImageTypes[mediaType] {
+			return nil, fmt.Errorf("unsupported image type: %s", mediaType)
+		}
+		return &Source{Type: "base64", MediaType: mediaType, Data: data}, nil
+	}
+
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return nil, fmt.Errorf("unsupported image url: %s", url)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := ImageClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("download image: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("download image: status %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, MaxImageSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("download image: %w", err)
+	}
+	if len(data) > MaxImageSize {
+		return nil, fmt.Errorf("image is larger than %d bytes", MaxImageSize)
+	}
+
+	// the content type of the servers is often missing or generic.
+	mediaType := mimetype.Detect(data).String()
+	if !supportedImageTypes[mediaType] {
+		return nil, fmt.Errorf("unsupported image type: %s", mediaType)
+	}
+	return &Source{Type: "base64", MediaType: mediaType, Data: base64.StdEncoding.EncodeToString(data)}, nil
+}
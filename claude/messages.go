@@ -0,0 +1,284 @@
+/*
+Copyright 2024 The corego Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package claude
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	sysopenai "github.com/sashabaranov/go-openai"
+)
+
+// UnmarshalJSON implements the json.Unmarshaler interface, the system prompt may be a string
+// or text blocks as sent by the Anthropic SDKs, the blocks are joined by newlines.
+func (r *ClaudeRequest) UnmarshalJSON(data []byte) error {
+	type alias ClaudeRequest
+	aux := struct {
+		*alias
+		System json.RawMessage `json:"system,omitempty"`
+	}{alias: (*alias)(r)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	r.System = ""
+	if len(aux.System) == 0 || string(aux.System) == "null" {
+		return nil
+	}
+	if err := json.Unmarshal(aux.System, &r.System); err == nil {
+		return nil
+	}
+	var blocks Contents
+	if err := json.Unmarshal(aux.System, &blocks); err != nil {
+		return fmt.Errorf("system must be a string or text blocks: %w", err)
+	}
+	texts := make([]string, 0, len(blocks))
+	for _, block := range blocks {
+		texts = append(texts, block.Text)
+	}
+	r.System = strings.Join(texts, "\n")
+	return nil
+}
+
+// Contents returns the content of the message as blocks, a string content is a text block.
+func (m Message) Contents() (Contents, error) {
+	switch v := m.Content.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return Contents{{Type: "text", Text: v}}, nil
+	case Contents:
+		return v, nil
+	case []Content:
+		return v, nil
+	}
+
+	// the blocks decoded from JSON.
+	data, err := json.Marshal(m.Content)
+	if err != nil {
+		return nil, err
+	}
+	var contents Contents
+	if err := json.Unmarshal(data, &contents); err != nil {
+		return nil, fmt.Errorf("invalid content of %s message: %w", m.Role, err)
+	}
+	return contents, nil
+}
+
+// openaiSampling returns the sampling parameter of a Claude request for go-openai. go-openai omits
+// a zero, so an explicit zero is sent as unset and the default of the upstream applies.
+func openaiSampling(v *float64) float32 {
+	if v == nil {
+		return 0
+	}
+	return float32(*v)
+}
+
+// Openai converts a request of the Anthropic messages API to openai.[Claude请求转换]
+// The tool_result blocks of a user message become tool messages before its other blocks,
+// top_k has no counterpart and is dropped. A stream asks for the usage chunk.
+func (r *ClaudeRequest) Openai() (*sysopenai.ChatCompletionRequest, error) {
+	if r.Model == "" {
+		return nil, fmt.Errorf("model is required")
+	}
+	if r.MaxTokens <= 0 {
+		return nil, fmt.Errorf("max_tokens is required")
+	}
+
+	req := &sysopenai.ChatCompletionRequest{
+		Model:       r.Model,
+		MaxTokens:   r.MaxTokens,
+		Temperature: openaiSampling(r.Temperature),
+		TopP:        openaiSampling(r.TopP),
+		Stop:        r.StopSequences,
+		Stream:      r.Stream,
+	}
+	if r.Stream {
+		req.StreamOptions = &sysopenai.StreamOptions{IncludeUsage: true}
+	}
+	switch metadata := r.Metadata.(type) {
+	case Metadata:
+		req.User = metadata.UserID
+	case map[string]interface{}:
+		req.User, _ = metadata["user_id"].(string)
+	}
+
+	if r.System != "" {
+		req.Messages = append(req.Messages, sysopenai.ChatCompletionMessage{Role: sysopenai.ChatMessageRoleSystem, Content: r.System})
+	}
+	for _, message := range r.Messages {
+		contents, err := message.Contents()
+		if err != nil {
+			return nil, err
+		}
+
+		switch message.Role {
+		case sysopenai.ChatMessageRoleUser:
+			messages, err := userMessages(contents)
+			if err != nil {
+				return nil, err
+			}
+			req.Messages = append(req.Messages, messages...)
+		case sysopenai.ChatMessageRoleAssistant:
+			out := sysopenai.ChatCompletionMessage{Role: sysopenai.ChatMessageRoleAssistant}
+			for _, content := range contents {
+				switch content.Type {
+				case "text":
+					out.Content += content.Text
+				case ContentTypeToolUse:
+					out.ToolCalls = append(out.ToolCalls, content.ToolCall())
+				}
+			}
+			req.Messages = append(req.Messages, out)
+		default:
+			return nil, fmt.Errorf("unsupported role: %s", message.Role)
+		}
+	}
+
+	for _, tool := range r.Tools {
+		req.Tools = append(req.Tools, tool.Openai())
+	}
+	if r.ToolChoice != nil && len(req.Tools) != 0 {
+		req.ToolChoice = r.ToolChoice.Openai()
+	}
+	return req, nil
+}
+
+// userMessages converts the blocks of a user message to the tool messages and a user message.
+func userMessages(contents Contents) ([]sysopenai.ChatCompletionMessage, error) {
+	var (
+		messages []sysopenai.ChatCompletionMessage
+		parts    []sysopenai.ChatMessagePart
+	)
+	for _, content := range contents {
+		switch content.Type {
+		case "text":
+			parts = append(parts, sysopenai.ChatMessagePart{Type: sysopenai.ChatMessagePartTypeText, Text: content.Text})
+		case "image":
+			url, err := content.Source.URLString()
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, sysopenai.ChatMessagePart{
+				Type:     sysopenai.ChatMessagePartTypeImageURL,
+				ImageURL: &sysopenai.ChatMessageImageURL{URL: url},
+			})
+		case ContentTypeToolResult:
+			message, err := content.ToolMessage()
+			if err != nil {
+				return nil, err
+			}
+			messages = append(messages, message)
+		default:
+			return nil, fmt.Errorf("unsupported content type: %s", content.Type)
+		}
+	}
+
+	switch {
+	case len(parts) == 1 && parts[0].Type == sysopenai.ChatMessagePartTypeText:
+		messages = append(messages, sysopenai.ChatCompletionMessage{Role: sysopenai.ChatMessageRoleUser, Content: parts[0].Text})
+	case len(parts) != 0:
+		messages = append(messages, sysopenai.ChatCompletionMessage{Role: sysopenai.ChatMessageRoleUser, MultiContent: parts})
+	}
+	return messages, nil
+}
+
+// URLString returns the source as the url of an image_url part, a base64 source is a data URL.
+func (s *Source) URLString() (string, error) {
+	if s == nil {
+		return "", fmt.Errorf("image source is required")
+	}
+	switch s.Type {
+	case "base64":
+		return "data:" + s.MediaType + ";base64," + s.Data, nil
+	case "url":
+		return s.URL, nil
+	}
+	return "", fmt.Errorf("unsupported image source: %s", s.Type)
+}
+
+// StopReason maps the finish reason of openai to the stop reason of Claude.
+func StopReason(finishReason sysopenai.FinishReason) string {
+	switch finishReason {
+	case sysopenai.FinishReasonLength:
+		return StopReasonMaxTokens
+	case sysopenai.FinishReasonToolCalls, sysopenai.FinishReasonFunctionCall:
+		return StopReasonToolUse
+	}
+	return StopReasonEndTurn
+}
+
+// MessagesResponse is the response of the Anthropic messages API.
+type MessagesResponse struct {
+	ID           string    `json:"id"`
+	Type         string    `json:"type"`
+	Role         string    `json:"role"`
+	Model        string    `json:"model"`
+	Content      []Content `json:"content"`
+	StopReason   string    `json:"stop_reason,omitempty"`
+	StopSequence *string   `json:"stop_sequence"`
+	Usage        Usage     `json:"usage"`
+}
+
+// toolInput returns the arguments of a tool call as the input of a tool_use block,
+// arguments that are not JSON are kept as a string field.
+func toolInput(arguments string) json.RawMessage {
+	if strings.TrimSpace(arguments) == "" {
+		return json.RawMessage("{}")
+	}
+	if json.Valid([]byte(arguments)) {
+		return json.RawMessage(arguments)
+	}
+	input, _ := json.Marshal(map[string]string{"arguments": arguments})
+	return input
+}
+
+// ConvertOpenaiResponse converts an openai response to the response of the Anthropic messages API,
+// the first choice is used.[响应转换]
+func ConvertOpenaiResponse(resp sysopenai.ChatCompletionResponse) *MessagesResponse {
+	out := &MessagesResponse{
+		ID:      resp.ID,
+		Type:    "message",
+		Role:    sysopenai.ChatMessageRoleAssistant,
+		Model:   resp.Model,
+		Content: []Content{},
+		Usage: Usage{
+			InputTokens:  resp.Usage.PromptTokens,
+			OutputTokens: resp.Usage.CompletionTokens,
+		},
+		StopReason: StopReasonEndTurn,
+	}
+	if len(resp.Choices) == 0 {
+		return out
+	}
+
+	choice := resp.Choices[0]
+	if choice.Message.Content != "" {
+		out.Content = append(out.Content, Content{Type: "text", Text: choice.Message.Content})
+	}
+	for _, call := range choice.Message.ToolCalls {
+		out.Content = append(out.Content, Content{
+			Type:  ContentTypeToolUse,
+			ID:    call.ID,
+			Name:  call.Function.Name,
+			Input: toolInput(call.Function.Arguments),
+		})
+	}
+	out.StopReason = StopReason(choice.FinishReason)
+	return out
+}
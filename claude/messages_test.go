@@ -0,0 +1,107 @@
+package claude
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"testing"
+
+	sysopenai "github.com/sashabaranov/go-openai"
+)
+
+func TestClaudeRequestOpenai(t *testing.T) {
+	var req ClaudeRequest
+	err := json.Unmarshal([]byte(`{
+		"model": "claude-3-5-sonnet-20240620",
+		"max_tokens": 1024,
+		"system": [{"type": "text", "text": "Be brief."}],
+		"metadata": {"user_id": "user-1"},
+		"tools": [{"name": "get_weather", "input_schema": {"type": "object"}}],
+		"messages": [
+			{"role": "user", "content": [
+				{"type": "image", "source": {"type": "base64", "media_type": "image/png", "data": "iVBORw=="}},
+				{"type": "text", "text": "Weather here?"}
+			]},
+			{"role": "assistant", "content": [{"type": "tool_use", "id": "toolu_1", "name": "get_weather", "input": {"city": "Paris"}}]},
+			{"role": "user", "content": [{"type": "tool_result", "tool_use_id": "toolu_1", "content": "sunny"}]}
+		]
+	}`), &req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := req.Openai()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.User != "user-1" || len(out.Tools) != 1 || len(out.Messages) != 4 {
+		t.Fatalf("unexpected request: %+v", out)
+	}
+	if out.Messages[0].Role != sysopenai.ChatMessageRoleSystem || out.Messages[0].Content != "Be brief." {
+		t.Errorf("unexpected system message: %+v", out.Messages[0])
+	}
+	if url := out.Messages[1].MultiContent[0].ImageURL.URL; url != "data:image/png;base64,iVBORw==" {
+		t.Errorf("got image url %q", url)
+	}
+	if calls := out.Messages[2].ToolCalls; len(calls) != 1 || calls[0].Function.Arguments != `{"city":"Paris"}` {
+		t.Errorf("unexpected tool calls: %+v", calls)
+	}
+	if tool := out.Messages[3]; tool.Role != sysopenai.ChatMessageRoleTool || tool.ToolCallID != "toolu_1" || tool.Content != "sunny" {
+		t.Errorf("unexpected tool message: %+v", tool)
+	}
+}
+
+func TestStreamWriter(t *testing.T) {
+	index := 0
+	chunks := []sysopenai.ChatCompletionStreamResponse{
+		{ID: "chatcmpl-1", Model: "gpt-4o", Choices: []sysopenai.ChatCompletionStreamChoice{{Delta: sysopenai.ChatCompletionStreamChoiceDelta{Role: "assistant", Content: "Hi"}}}},
+		{ID: "chatcmpl-1", Model: "gpt-4o", Choices: []sysopenai.ChatCompletionStreamChoice{{Delta: sysopenai.ChatCompletionStreamChoiceDelta{ToolCalls: []sysopenai.ToolCall{
+			{Index: &index, ID: "call_1", Type: sysopenai.ToolTypeFunction, Function: sysopenai.FunctionCall{Name: "get_weather"}},
+		}}}}},
+		{ID: "chatcmpl-1", Model: "gpt-4o", Choices: []sysopenai.ChatCompletionStreamChoice{{Delta: sysopenai.ChatCompletionStreamChoiceDelta{ToolCalls: []sysopenai.ToolCall{
+			{Index: &index, Function: sysopenai.FunctionCall{Arguments: `{"city":"Paris"}`}},
+		}}, FinishReason: sysopenai.FinishReasonToolCalls}}},
+		{ID: "chatcmpl-1", Model: "gpt-4o", Choices: []sysopenai.ChatCompletionStreamChoice{}, Usage: &sysopenai.Usage{PromptTokens: 12, CompletionTokens: 7}},
+	}
+
+	var buf bytes.Buffer
+	writer := NewStreamWriter(&buf, "gpt-4o", 0)
+	for _, chunk := range chunks {
+		if err := writer.Write(chunk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the written stream is read back as openai chunks.
+	reader := NewStreamReader(&buf)
+	var content, arguments string
+	var finishReason sysopenai.FinishReason
+	for {
+		chunk, err := reader.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, choice := range chunk.Choices {
+			content += choice.Delta.Content
+			for _, call := range choice.Delta.ToolCalls {
+				arguments += call.Function.Arguments
+			}
+			if choice.FinishReason != "" {
+				finishReason = choice.FinishReason
+			}
+		}
+	}
+	if content != "Hi" || arguments != `{"city":"Paris"}` || finishReason != sysopenai.FinishReasonToolCalls {
+		t.Errorf("got %q, %q, %q", content, arguments, finishReason)
+	}
+	if usage := reader.Usage(); usage.InputTokens != 0 || usage.OutputTokens != 7 {
+		t.Errorf("unexpected usage: %+v", usage)
+	}
+}
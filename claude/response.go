@@ -29,16 +29,54 @@ import (
 
 // ClaudeResponse is the response from the Claude service.
 type ClaudeResponse struct {
-	Type         string      `json:"type"`
-	Index        int         `json:"index"`
-	Delta        Content     `json:"delta,omitempty"`
-	Id           string      `json:"id"`
-	Role         string      `json:"role"`
-	Model        string      `json:"model"`
-	Content      []Content   `json:"content,omitempty"`
-	StopReason   string      `json:"stop_reason"`
-	StopSequence interface{} `json:"stop_sequence"`
-	ContentBlock Content     `json:"content_block,omitempty"`
+	Type         string          `json:"type"`
+	Index        int             `json:"index"`
+	Delta        Content         `json:"delta,omitempty"`
+	Id           string          `json:"id"`
+	Role         string          `json:"role"`
+	Model        string          `json:"model"`
+	Content      []Content       `json:"content,omitempty"`
+	StopReason   string          `json:"stop_reason"`
+	StopSequence interface{}     `json:"stop_sequence"`
+	ContentBlock Content         `json:"content_block,omitempty"`
+	Usage        *Usage          `json:"usage,omitempty"`
+	Message      *ClaudeResponse `json:"message,omitempty"` // message_start
+	Error        *Error          `json:"error,omitempty"`   // error
+}
+
+// Error is the error of Claude. https://docs.anthropic.com/en/api/errors
+type Error struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+// Error implements the error interface.
+func (e *Error) Error() string {
+	return e.Type + ": " + e.Message
+}
+
+// Usage is the usage of Claude, the input tokens exclude the cached tokens.
+type Usage struct {
+	InputTokens              int `json:"input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens,omitempty"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens,omitempty"`
+}
+
+// Openai converts the Usage to the openai usage, the prompt tokens include the cached tokens.
+func (u Usage) Openai() openai.Usage {
+	usage := openai.Usage{
+		PromptTokens:     u.InputTokens + u.CacheCreationInputTokens + u.CacheReadInputTokens,
+		CompletionTokens: u.OutputTokens,
+	}
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	if u.CacheCreationInputTokens != 0 || u.CacheReadInputTokens != 0 {
+		usage.PromptTokensDetails = &openai.PromptTokensDetails{
+			CachedTokens:        u.CacheReadInputTokens,
+			CacheCreationTokens: u.CacheCreationInputTokens,
+		}
+	}
+	return usage
 }
 
 // OpenAIWeb opens the ClaudeResponse.
@@ -69,6 +107,8 @@ func (r *ClaudeResponse) OpenAIWeb(in *openai.ChatCompletionRequest) (list []ope
 	return
 }
 
+// Openai converts the ClaudeResponse to an openai response, the usage is counted locally if Claude
+// did not report it. See OpenaiWithUsage for the cached tokens.
 func (r *ClaudeResponse) Openai(in *openai.ChatCompletionRequest) sysopenai.ChatCompletionResponse {
 	req := sysopenai.ChatCompletionResponse{
 		ID:      r.Id,
@@ -77,17 +117,19 @@ func (r *ClaudeResponse) Openai(in *openai.ChatCompletionRequest) sysopenai.Chat
 		Model:   r.Model,
 		Choices: []sysopenai.ChatCompletionChoice{
 			{
-				Index: 0,
-				Message: sysopenai.ChatCompletionMessage{
-					Role:    sysopenai.ChatMessageRoleAssistant,
-					Content: r.Content[0].Text,
-				},
-				FinishReason: sysopenai.FinishReason(r.StopReason),
+				Index:        0,
+				Message:      r.message(),
+				FinishReason: FinishReason(r.StopReason),
 			},
 		},
 		SystemFingerprint: "fp_" + uuid.NewString(),
 	}
 
+	if r.Usage != nil {
+		req.Usage = r.Usage.Openai().OpenAI()
+		return req
+	}
+
 	promptTokens, err := in.CalculateRequestToken()
 	if err != nil {
 		logx.Error("CalculateRequestToken failed:", err.Error())
@@ -101,3 +143,33 @@ func (r *ClaudeResponse) Openai(in *openai.ChatCompletionRequest) sysopenai.Chat
 	req.Usage.TotalTokens = promptTokens + req.Usage.CompletionTokens
 	return req
 }
+
+// OpenaiWithUsage is Openai with the usage details, the cached tokens reported by Claude are carried
+// in prompt_tokens_details.[用量明细]
+func (r *ClaudeResponse) OpenaiWithUsage(in *openai.ChatCompletionRequest) openai.ChatCompletionUsageResponse {
+	resp := openai.ChatCompletionUsageResponse{ChatCompletionResponse: r.Openai(in)}
+	if r.Usage != nil {
+		resp.Usage = r.Usage.Openai()
+		return resp
+	}
+	resp.Usage = openai.Usage{
+		PromptTokens:     resp.ChatCompletionResponse.Usage.PromptTokens,
+		CompletionTokens: resp.ChatCompletionResponse.Usage.CompletionTokens,
+		TotalTokens:      resp.ChatCompletionResponse.Usage.TotalTokens,
+	}
+	return resp
+}
+
+// message joins the text blocks as the content, the tool_use blocks become the tool calls.
+func (r *ClaudeResponse) message() sysopenai.ChatCompletionMessage {
+	message := sysopenai.ChatCompletionMessage{Role: sysopenai.ChatMessageRoleAssistant}
+	for _, content := range r.Content {
+		switch content.Type {
+		case "text":
+			message.Content += content.Text
+		case ContentTypeToolUse:
+			message.ToolCalls = append(message.ToolCalls, content.ToolCall())
+		}
+	}
+	return message
+}
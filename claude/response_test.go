@@ -0,0 +1,40 @@
+package claude
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/bytemind-io/corekit/openai"
+)
+
+func TestClaudeResponseOpenaiCacheUsage(t *testing.T) {
+	var resp ClaudeResponse
+	err := json.Unmarshal([]byte(`{"id":"msg_1","role":"assistant","model":"claude-3-5-sonnet-20240620","stop_reason":"end_turn",
+		"content":[{"type":"text","text":"Hello"}],
+		"usage":{"input_tokens":10,"output_tokens":5,"cache_creation_input_tokens":20,"cache_read_input_tokens":30}}`), &resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := resp.OpenaiWithUsage(nil)
+	if out.Usage.PromptTokens != 60 || out.Usage.TotalTokens != 65 || out.Usage.PromptTokensDetails == nil ||
+		*out.Usage.PromptTokensDetails != (openai.PromptTokensDetails{CachedTokens: 30, CacheCreationTokens: 20}) {
+		t.Fatalf("unexpected usage: %+v", out.Usage)
+	}
+	if out.ChatCompletionResponse.Usage.PromptTokens != 60 {
+		t.Errorf("unexpected go-openai usage: %+v", out.ChatCompletionResponse.Usage)
+	}
+	// Openai keeps the go-openai response.
+	if usage := resp.Openai(nil).Usage; usage.PromptTokens != 60 || usage.TotalTokens != 65 {
+		t.Errorf("unexpected usage: %+v", usage)
+	}
+
+	data, err := json.Marshal(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"prompt_tokens_details":{"cached_tokens":30,"cache_creation_tokens":20}`) {
+		t.Errorf("expected the cache details in %s", data)
+	}
+}
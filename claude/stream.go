@@ -0,0 +1,454 @@
+/*
+Copyright 2024 The corego Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package claude
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/bytemind-io/corekit/openai"
+	"github.com/google/uuid"
+	sysopenai "github.com/sashabaranov/go-openai"
+)
+
+// the event types of a messages stream. https://docs.anthropic.com/en/api/messages-streaming
+const (
+	EventMessageStart      = "message_start"
+	EventContentBlockStart = "content_block_start"
+	EventContentBlockDelta = "content_block_delta"
+	EventContentBlockStop  = "content_block_stop"
+	EventMessageDelta      = "message_delta"
+	EventMessageStop       = "message_stop"
+	EventPing              = "ping"
+	EventError             = "error"
+)
+
+// the stop reasons of Claude.
+const (
+	StopReasonEndTurn      = "end_turn"
+	StopReasonMaxTokens    = "max_tokens"
+	StopReasonStopSequence = "stop_sequence"
+	StopReasonToolUse      = "tool_use"
+)
+
+// FinishReason maps the stop reason of Claude to the finish reason of openai.
+func FinishReason(stopReason string) sysopenai.FinishReason {
+	switch stopReason {
+	case StopReasonEndTurn, StopReasonStopSequence:
+		return sysopenai.FinishReasonStop
+	case StopReasonMaxTokens:
+		return sysopenai.FinishReasonLength
+	case StopReasonToolUse:
+		return sysopenai.FinishReasonToolCalls
+	}
+	return sysopenai.FinishReason(stopReason)
+}
+
+// StreamConverter converts the events of a Claude messages stream to openai chunks.[流式转换]
+type StreamConverter struct {
+	id          string
+	model       string
+	created     int64
+	fingerprint string
+	usage       Usage
+	toolIndexes map[int]int // content block index to tool call index
+}
+
+// NewStreamConverter creates a StreamConverter for a stream.
+func NewStreamConverter() *StreamConverter {
+	return &StreamConverter{
+		created:     time.Now().Unix(),
+		fingerprint: "fp_" + uuid.NewString(),
+		toolIndexes: make(map[int]int),
+	}
+}
+
+// Usage returns the usage of the stream so far.
+func (c *StreamConverter) Usage() Usage {
+	return c.usage
+}
+
+// chunk creates a chunk of the stream.
+func (c *StreamConverter) chunk(delta sysopenai.ChatCompletionStreamChoiceDelta, finishReason sysopenai.FinishReason) openai.ChatCompletionStreamUsageResponse {
+	return openai.ChatCompletionStreamUsageResponse{ChatCompletionStreamResponse: sysopenai.ChatCompletionStreamResponse{
+		ID:      c.id,
+		Object:  "chat.completion.chunk",
+		Created: c.created,
+		Model:   c.model,
+		Choices: []sysopenai.ChatCompletionStreamChoice{
+			{Index: 0, Delta: delta, FinishReason: finishReason},
+		},
+		SystemFingerprint: c.fingerprint,
+	}}
+}
+
+// toolCall creates the chunk of a tool call delta of the content block.
+func (c *StreamConverter) toolCall(block int, call sysopenai.ToolCall) openai.ChatCompletionStreamUsageResponse {
+	index := c.toolIndexes[block]
+	call.Index = &index
+	return c.chunk(sysopenai.ChatCompletionStreamChoiceDelta{ToolCalls: []sysopenai.ToolCall{call}}, "")
+}
+
+// Convert converts an event to the chunks to emit, most events emit one chunk and ping emits none.
+// message_stop emits the usage chunk without choices, an error event is returned as an *Error.
+func (c *StreamConverter) Convert(event *ClaudeResponse) ([]openai.ChatCompletionStreamUsageResponse, error) {
+	switch event.Type {
+	case EventMessageStart:
+		if event.Message == nil {
+			return nil, fmt.Errorf("message_start without message")
+		}
+		c.id = event.Message.Id
+		c.model = event.Message.Model
+		if event.Message.Usage != nil {
+			c.usage = *event.Message.Usage
+		}
+		return []openai.ChatCompletionStreamUsageResponse{
+			c.chunk(sysopenai.ChatCompletionStreamChoiceDelta{Role: sysopenai.ChatMessageRoleAssistant}, ""),
+		}, nil
+
+	case EventContentBlockStart:
+		switch event.ContentBlock.Type {
+		case ContentTypeToolUse:
+			c.toolIndexes[event.Index] = len(c.toolIndexes)
+			return []openai.ChatCompletionStreamUsageResponse{c.toolCall(event.Index, sysopenai.ToolCall{
+				ID:       event.ContentBlock.ID,
+				Type:     sysopenai.ToolTypeFunction,
+				Function: sysopenai.FunctionCall{Name: event.ContentBlock.Name},
+			})}, nil
+		case "text":
+			if event.ContentBlock.Text != "" {
+				return []openai.ChatCompletionStreamUsageResponse{
+					c.chunk(sysopenai.ChatCompletionStreamChoiceDelta{Content: event.ContentBlock.Text}, ""),
+				}, nil
+			}
+		}
+		return nil, nil
+
+	case EventContentBlockDelta:
+		switch event.Delta.Type {
+		case "text_delta":
+			return []openai.ChatCompletionStreamUsageResponse{
+				c.chunk(sysopenai.ChatCompletionStreamChoiceDelta{Content: event.Delta.Text}, ""),
+			}, nil
+		case "input_json_delta":
+			if _, ok := c.toolIndexes[event.Index]; !ok {
+				return nil, fmt.Errorf("input_json_delta of unknown content block %d", event.Index)
+			}
+			return []openai.ChatCompletionStreamUsageResponse{c.toolCall(event.Index, sysopenai.ToolCall{
+				Type:     sysopenai.ToolTypeFunction,
+				Function: sysopenai.FunctionCall{Arguments: event.Delta.PartialJSON},
+			})}, nil
+		}
+		return nil, nil
+
+	case EventMessageDelta:
+		if event.Usage != nil {
+			// the output tokens of message_delta are cumulative.
+			c.usage.OutputTokens = event.Usage.OutputTokens
+		}
+		return []openai.ChatCompletionStreamUsageResponse{
+			c.chunk(sysopenai.ChatCompletionStreamChoiceDelta{}, FinishReason(event.Delta.StopReason)),
+		}, nil
+
+	case EventMessageStop:
+		// the usage chunk carries the cached tokens in prompt_tokens_details.
+		usage := c.usage.Openai()
+		openaiUsage := usage.OpenAI()
+		return []openai.ChatCompletionStreamUsageResponse{{
+			ChatCompletionStreamResponse: sysopenai.ChatCompletionStreamResponse{
+				ID:                c.id,
+				Object:            "chat.completion.chunk",
+				Created:           c.created,
+				Model:             c.model,
+				Choices:           []sysopenai.ChatCompletionStreamChoice{},
+				SystemFingerprint: c.fingerprint,
+				Usage:             &openaiUsage,
+			},
+			Usage: &usage,
+		}}, nil
+
+	case EventError:
+		if event.Error == nil {
+			return nil, fmt.Errorf("error event without error")
+		}
+		return nil, event.Error
+	}
+	// ping and the unknown events.
+	return nil, nil
+}
+
+// StreamReader reads a Claude messages stream in the server-sent events format as openai chunks.
+type StreamReader struct {
+	reader    *bufio.Reader
+	converter *StreamConverter
+	pending   []openai.ChatCompletionStreamUsageResponse
+	done      bool
+}
+
+// NewStreamReader creates a StreamReader of the response body.
+func NewStreamReader(r io.Reader) *StreamReader {
+	return &StreamReader{reader: bufio.NewReader(r), converter: NewStreamConverter()}
+}
+
+// Recv returns the next chunk, io.EOF after the message_stop event.
+func (s *StreamReader) Recv() (openai.ChatCompletionStreamUsageResponse, error) {
+	for len(s.pending) == 0 {
+		if s.done {
+			return openai.ChatCompletionStreamUsageResponse{}, io.EOF
+		}
+
+		data, err := s.readEvent()
+		if err != nil {
+			return openai.ChatCompletionStreamUsageResponse{}, err
+		}
+		var event ClaudeResponse
+		if err := json.Unmarshal(data, &event); err != nil {
+			return openai.ChatCompletionStreamUsageResponse{}, fmt.Errorf("decode stream event: %w", err)
+		}
+		s.pending, err = s.converter.Convert(&event)
+		if err != nil {
+			return openai.ChatCompletionStreamUsageResponse{}, err
+		}
+		s.done = event.Type == EventMessageStop
+	}
+
+	chunk := s.pending[0]
+	s.pending = s.pending[1:]
+	return chunk, nil
+}
+
+// Usage returns the usage of the stream so far.
+func (s *StreamReader) Usage() Usage {
+	return s.converter.Usage()
+}
+
+// readEvent returns the data of the next event, the data lines of an event are joined by newlines.
+func (s *StreamReader) readEvent() ([]byte, error) {
+	var data []byte
+	for {
+		line, err := s.reader.ReadBytes('\n')
+		if err != nil && len(line) == 0 {
+			if !errors.Is(err, io.EOF) {
+				return nil, err
+			}
+			if len(data) == 0 {
+				// the stream ended before message_stop.
+				return nil, io.ErrUnexpectedEOF
+			}
+			return data, nil
+		}
+
+		line = bytes.TrimRight(line, "\r\n")
+		if len(line) == 0 {
+			if len(data) != 0 {
+				return data, nil
+			}
+			continue
+		}
+		if value, ok := bytes.CutPrefix(line, []byte("data:")); ok {
+			if len(data) != 0 {
+				data = append(data, '\n')
+			}
+			data = append(data, bytes.TrimPrefix(value, []byte(" "))...)
+		}
+		// the event field repeats the type of the data, the comments and other fields are ignored.
+	}
+}
+
+// streamEvent is an event of an Anthropic messages stream written by StreamWriter.
+type streamEvent struct {
+	Type         string            `json:"type"`
+	Index        *int              `json:"index,omitempty"`
+	Message      *MessagesResponse `json:"message,omitempty"`
+	ContentBlock interface{}       `json:"content_block,omitempty"`
+	Delta        interface{}       `json:"delta,omitempty"`
+	Usage        *Usage            `json:"usage,omitempty"`
+	Error        *Error            `json:"error,omitempty"`
+}
+
+// StreamWriter writes openai chunks as an Anthropic messages stream in the server-sent events format.[流式响应转换]
+// The stream starts with the first chunk, Close must be called after the last one.
+type StreamWriter struct {
+	w          io.Writer
+	model      string
+	usage      Usage
+	started    bool
+	block      int    // index of the open content block, -1 if none
+	blockType  string // type of the open content block
+	blocks     int
+	toolBlocks map[int]int // tool call index to content block index
+	stopReason string
+}
+
+// NewStreamWriter creates a StreamWriter, the input tokens are reported by message_start
+// until a usage chunk reports them.
+func NewStreamWriter(w io.Writer, model string, inputTokens int) *StreamWriter {
+	return &StreamWriter{
+		w:          w,
+		model:      model,
+		usage:      Usage{InputTokens: inputTokens},
+		block:      -1,
+		toolBlocks: make(map[int]int),
+		stopReason: StopReasonEndTurn,
+	}
+}
+
+// write writes an event and flushes it if w is an http.Flusher.
+func (s *StreamWriter) write(event streamEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", event.Type, data); err != nil {
+		return err
+	}
+	if flusher, ok := s.w.(interface{ Flush() }); ok {
+		flusher.Flush()
+	}
+	return nil
+}
+
+// start writes message_start once.
+func (s *StreamWriter) start(id, model string) error {
+	if s.started {
+		return nil
+	}
+	s.started = true
+	if model == "" {
+		model = s.model
+	}
+	return s.write(streamEvent{Type: EventMessageStart, Message: &MessagesResponse{
+		ID:      id,
+		Type:    "message",
+		Role:    sysopenai.ChatMessageRoleAssistant,
+		Model:   model,
+		Content: []Content{},
+		Usage:   s.usage,
+	}})
+}
+
+// openBlock closes the open content block and starts a new one.
+func (s *StreamWriter) openBlock(blockType string, block interface{}) error {
+	if err := s.closeBlock(); err != nil {
+		return err
+	}
+	s.block, s.blockType = s.blocks, blockType
+	s.blocks++
+	index := s.block
+	return s.write(streamEvent{Type: EventContentBlockStart, Index: &index, ContentBlock: block})
+}
+
+// closeBlock writes content_block_stop of the open content block.
+func (s *StreamWriter) closeBlock() error {
+	if s.block < 0 {
+		return nil
+	}
+	index := s.block
+	s.block, s.blockType = -1, ""
+	return s.write(streamEvent{Type: EventContentBlockStop, Index: &index})
+}
+
+// delta writes a content_block_delta of the block.
+func (s *StreamWriter) delta(block int, delta interface{}) error {
+	return s.write(streamEvent{Type: EventContentBlockDelta, Index: &block, Delta: delta})
+}
+
+// Write converts a chunk to the events, the first choice is used.
+func (s *StreamWriter) Write(chunk sysopenai.ChatCompletionStreamResponse) error {
+	if err := s.start(chunk.ID, chunk.Model); err != nil {
+		return err
+	}
+	if chunk.Usage != nil {
+		s.usage.InputTokens = chunk.Usage.PromptTokens
+		s.usage.OutputTokens = chunk.Usage.CompletionTokens
+	}
+	if len(chunk.Choices) == 0 {
+		return nil
+	}
+
+	choice := chunk.Choices[0]
+	if choice.Delta.Content != "" {
+		if s.blockType != "text" {
+			if err := s.openBlock("text", map[string]string{"type": "text", "text": ""}); err != nil {
+				return err
+			}
+		}
+		if err := s.delta(s.block, map[string]string{"type": "text_delta", "text": choice.Delta.Content}); err != nil {
+			return err
+		}
+	}
+
+	for i, call := range choice.Delta.ToolCalls {
+		index := i
+		if call.Index != nil {
+			index = *call.Index
+		}
+		block, ok := s.toolBlocks[index]
+		if !ok {
+			if err := s.openBlock(ContentTypeToolUse, map[string]interface{}{
+				"type":  ContentTypeToolUse,
+				"id":    call.ID,
+				"name":  call.Function.Name,
+				"input": map[string]interface{}{},
+			}); err != nil {
+				return err
+			}
+			block = s.block
+			s.toolBlocks[index] = block
+		}
+		if call.Function.Arguments != "" {
+			if err := s.delta(block, map[string]string{"type": "input_json_delta", "partial_json": call.Function.Arguments}); err != nil {
+				return err
+			}
+		}
+	}
+
+	if choice.FinishReason != "" && choice.FinishReason != sysopenai.FinishReasonNull {
+		s.stopReason = StopReason(choice.FinishReason)
+	}
+	return nil
+}
+
+// Close closes the open content block and writes message_delta with the stop reason and usage,
+// and message_stop.
+func (s *StreamWriter) Close() error {
+	if err := s.start("", ""); err != nil {
+		return err
+	}
+	if err := s.closeBlock(); err != nil {
+		return err
+	}
+	usage := s.usage
+	if err := s.write(streamEvent{
+		Type:  EventMessageDelta,
+		Delta: map[string]interface{}{"stop_reason": s.stopReason, "stop_sequence": nil},
+		Usage: &usage,
+	}); err != nil {
+		return err
+	}
+	return s.write(streamEvent{Type: EventMessageStop})
+}
+
+// WriteError writes an error event, the stream should not be written afterwards.
+func (s *StreamWriter) WriteError(errType, message string) error {
+	return s.write(streamEvent{Type: EventError, Error: &Error{Type: errType, Message: message}})
+}
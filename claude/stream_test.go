@@ -0,0 +1,116 @@
+package claude
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/bytemind-io/corekit/openai"
+	sysopenai "github.com/sashabaranov/go-openai"
+)
+
+const testStream = `event: message_start
+data: {"type":"message_start","message":{"id":"msg_1","type":"message","role":"assistant","model":"claude-3-5-sonnet-20240620","content":[],"stop_reason":null,"usage":{"input_tokens":25,"output_tokens":1}}}
+
+event: content_block_start
+data: {"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}
+
+event: ping
+data: {"type": "ping"}
+
+event: content_block_delta
+data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hello"}}
+
+event: content_block_stop
+data: {"type":"content_block_stop","index":0}
+
+event: content_block_start
+data: {"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"toolu_1","name":"get_weather","input":{}}}
+
+event: content_block_delta
+data: {"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"city\": \"Paris\"}"}}
+
+event: content_block_stop
+data: {"type":"content_block_stop","index":1}
+
+event: message_delta
+data: {"type":"message_delta","delta":{"stop_reason":"tool_use","stop_sequence":null},"usage":{"output_tokens":15}}
+
+event: message_stop
+data: {"type":"message_stop"}
+
+`
+
+// readChunks reads the chunks of a stream until io.EOF.
+func readChunks(t *testing.T, stream string) []openai.ChatCompletionStreamUsageResponse {
+	reader := NewStreamReader(strings.NewReader(stream))
+	var chunks []openai.ChatCompletionStreamUsageResponse
+	for {
+		chunk, err := reader.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		chunks = append(chunks, chunk)
+	}
+	return chunks
+}
+
+func TestStreamReader(t *testing.T) {
+	chunks := readChunks(t, testStream)
+	if len(chunks) != 6 {
+		t.Fatalf("got %d chunks, want 6", len(chunks))
+	}
+
+	if delta := chunks[0].Choices[0].Delta; delta.Role != sysopenai.ChatMessageRoleAssistant || chunks[0].ID != "msg_1" {
+		t.Errorf("unexpected first chunk: %+v", chunks[0])
+	}
+	if content := chunks[1].Choices[0].Delta.Content; content != "Hello" {
+		t.Errorf("got content %q", content)
+	}
+	call := chunks[2].Choices[0].Delta.ToolCalls[0]
+	if *call.Index != 0 || call.ID != "toolu_1" || call.Function.Name != "get_weather" {
+		t.Errorf("unexpected tool call: %+v", call)
+	}
+	if args := chunks[3].Choices[0].Delta.ToolCalls[0].Function.Arguments; args != `{"city": "Paris"}` {
+		t.Errorf("got arguments %q", args)
+	}
+	if reason := chunks[4].Choices[0].FinishReason; reason != sysopenai.FinishReasonToolCalls {
+		t.Errorf("got finish reason %q", reason)
+	}
+	if usage := chunks[5].Usage; usage == nil || usage.PromptTokens != 25 || usage.CompletionTokens != 15 || usage.TotalTokens != 40 {
+		t.Errorf("unexpected usage: %+v", usage)
+	}
+}
+
+func TestStreamReaderCacheUsage(t *testing.T) {
+	stream := strings.Replace(testStream, `"usage":{"input_tokens":25,"output_tokens":1}`,
+		`"usage":{"input_tokens":25,"output_tokens":1,"cache_creation_input_tokens":50,"cache_read_input_tokens":100}`, 1)
+	chunks := readChunks(t, stream)
+
+	usage := chunks[len(chunks)-1].Usage
+	if usage == nil || usage.PromptTokens != 175 || usage.TotalTokens != 190 || usage.PromptTokensDetails == nil ||
+		*usage.PromptTokensDetails != (openai.PromptTokensDetails{CachedTokens: 100, CacheCreationTokens: 50}) {
+		t.Fatalf("unexpected usage: %+v", usage)
+	}
+	data, err := json.Marshal(chunks[len(chunks)-1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"prompt_tokens_details":{"cached_tokens":100,"cache_creation_tokens":50}`) {
+		t.Errorf("expected the cache details in %s", data)
+	}
+}
+
+func TestStreamReaderError(t *testing.T) {
+	reader := NewStreamReader(strings.NewReader("event: error\ndata: {\"type\":\"error\",\"error\":{\"type\":\"overloaded_error\",\"message\":\"Overloaded\"}}\n\n"))
+	_, err := reader.Recv()
+	var claudeErr *Error
+	if !errors.As(err, &claudeErr) || claudeErr.Type != "overloaded_error" {
+		t.Errorf("expected an overloaded_error, got %v", err)
+	}
+}
@@ -0,0 +1,225 @@
+/*
+Copyright 2024 The corego Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package claude
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	sysopenai "github.com/sashabaranov/go-openai"
+)
+
+// the content block types of tool use. https://docs.anthropic.com/en/docs/build-with-claude/tool-use
+const (
+	ContentTypeToolUse    = "tool_use"
+	ContentTypeToolResult = "tool_result"
+)
+
+// the tool choice types of Claude.
+const (
+	ToolChoiceAuto = "auto"
+	ToolChoiceAny  = "any"
+	ToolChoiceTool = "tool"
+	ToolChoiceNone = "none"
+)
+
+// emptyInputSchema is the schema of a function without parameters, Claude requires one.
+var emptyInputSchema = json.RawMessage(`{"type":"object","properties":{}}`)
+
+// Tool is a tool of Claude.
+type Tool struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description,omitempty"`
+	InputSchema interface{} `json:"input_schema"`
+}
+
+// Openai converts the Tool to an openai function tool.
+func (t Tool) Openai() sysopenai.Tool {
+	return sysopenai.Tool{
+		Type: sysopenai.ToolTypeFunction,
+		Function: &sysopenai.FunctionDefinition{
+			Name:        t.Name,
+			Description: t.Description,
+			Parameters:  t.InputSchema,
+		},
+	}
+}
+
+// ToolChoice is how Claude uses the tools, Name is the tool of ToolChoiceTool.
+type ToolChoice struct {
+	Type string `json:"type"`
+	Name string `json:"name,omitempty"`
+}
+
+// Openai converts the ToolChoice to the tool_choice of openai.
+func (c ToolChoice) Openai() interface{} {
+	switch c.Type {
+	case ToolChoiceAny:
+		return "required"
+	case ToolChoiceNone:
+		return "none"
+	case ToolChoiceTool:
+		return sysopenai.ToolChoice{Type: sysopenai.ToolTypeFunction, Function: sysopenai.ToolFunction{Name: c.Name}}
+	}
+	return "auto"
+}
+
+// ConvertOpenaiTools converts the openai function tools to the tools of Claude.[工具转换]
+func ConvertOpenaiTools(tools []sysopenai.Tool) ([]Tool, error) {
+	if len(tools) == 0 {
+		return nil, nil
+	}
+
+	list := make([]Tool, 0, len(tools))
+	for _, tool := range tools {
+		if tool.Type != sysopenai.ToolTypeFunction || tool.Function == nil {
+			return nil, fmt.Errorf("unsupported tool type: %s", tool.Type)
+		}
+		schema := tool.Function.Parameters
+		if schema == nil {
+			schema = emptyInputSchema
+		}
+		list = append(list, Tool{Name: tool.Function.Name, Description: tool.Function.Description, InputSchema: schema})
+	}
+	return list, nil
+}
+
+// ConvertOpenaiToolChoice converts the tool_choice of openai, a string, a ToolChoice or its decoded JSON.
+func ConvertOpenaiToolChoice(choice interface{}) (*ToolChoice, error) {
+	switch v := choice.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		switch v {
+		case "", "auto":
+			return &ToolChoice{Type: ToolChoiceAuto}, nil
+		case "required":
+			return &ToolChoice{Type: ToolChoiceAny}, nil
+		case "none":
+			return &ToolChoice{Type: ToolChoiceNone}, nil
+		}
+		return nil, fmt.Errorf("unsupported tool choice: %s", v)
+	case sysopenai.ToolChoice:
+		return &ToolChoice{Type: ToolChoiceTool, Name: v.Function.Name}, nil
+	case *sysopenai.ToolChoice:
+		return &ToolChoice{Type: ToolChoiceTool, Name: v.Function.Name}, nil
+	case map[string]interface{}:
+		if function, ok := v["function"].(map[string]interface{}); ok {
+			if name, ok := function["name"].(string); ok && name != "" {
+				return &ToolChoice{Type: ToolChoiceTool, Name: name}, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("unsupported tool choice: %v", choice)
+}
+
+// ToolUseContent converts an openai tool call to a tool_use block, the arguments must be a JSON object.
+func ToolUseContent(call sysopenai.ToolCall) (Content, error) {
+	input := json.RawMessage("{}")
+	if strings.TrimSpace(call.Function.Arguments) != "" {
+		if !json.Valid([]byte(call.Function.Arguments)) {
+			return Content{}, fmt.Errorf("invalid arguments of tool call %s", call.ID)
+		}
+		input = json.RawMessage(call.Function.Arguments)
+	}
+	return Content{Type: ContentTypeToolUse, ID: call.ID, Name: call.Function.Name, Input: input}, nil
+}
+
+// ToolResultContent converts an openai tool message to a tool_result block.
+func ToolResultContent(message sysopenai.ChatCompletionMessage) Content {
+	text := message.Content
+	for _, part := range message.MultiContent {
+		if part.Type == sysopenai.ChatMessagePartTypeText {
+			text += part.Text
+		}
+	}
+	return Content{Type: ContentTypeToolResult, ToolUseID: message.ToolCallID, Content: text}
+}
+
+// toolErrorPrefix marks the content of a tool message whose tool_result is an error.
+const toolErrorPrefix = "Error: "
+
+// ToolCall converts a tool_use block to an openai tool call.
+func (c Content) ToolCall() sysopenai.ToolCall {
+	arguments := string(c.Input)
+	if arguments == "" {
+		arguments = "{}"
+	}
+	return sysopenai.ToolCall{
+		ID:       c.ID,
+		Type:     sysopenai.ToolTypeFunction,
+		Function: sysopenai.FunctionCall{Name: c.Name, Arguments: arguments},
+	}
+}
+
+// ToolMessage converts a tool_result block to an openai tool message, the text blocks of the result are joined
+// and a result with images is sent as the parts of the message. openai has no error flag, the content of a
+// failed result is prefixed by toolErrorPrefix.
+func (c Content) ToolMessage() (sysopenai.ChatCompletionMessage, error) {
+	message := sysopenai.ChatCompletionMessage{Role: sysopenai.ChatMessageRoleTool, ToolCallID: c.ToolUseID}
+	var blocks []Content
+	switch v := c.Content.(type) {
+	case string:
+		blocks = []Content{{Type: "text", Text: v}}
+	case []Content:
+		blocks = v
+	case []interface{}:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return message, err
+		}
+		if err := json.Unmarshal(data, &blocks); err != nil {
+			return message, fmt.Errorf("invalid tool_result content: %w", err)
+		}
+	}
+
+	var (
+		parts  []sysopenai.ChatMessagePart
+		images bool
+	)
+	if c.IsError {
+		parts = append(parts, sysopenai.ChatMessagePart{Type: sysopenai.ChatMessagePartTypeText, Text: toolErrorPrefix})
+	}
+	for _, block := range blocks {
+		switch block.Type {
+		case "text":
+			parts = append(parts, sysopenai.ChatMessagePart{Type: sysopenai.ChatMessagePartTypeText, Text: block.Text})
+		case "image":
+			url, err := block.Source.URLString()
+			if err != nil {
+				return message, err
+			}
+			parts = append(parts, sysopenai.ChatMessagePart{
+				Type:     sysopenai.ChatMessagePartTypeImageURL,
+				ImageURL: &sysopenai.ChatMessageImageURL{URL: url},
+			})
+			images = true
+		default:
+			return message, fmt.Errorf("unsupported tool_result content type: %s", block.Type)
+		}
+	}
+
+	if images {
+		message.MultiContent = parts
+		return message, nil
+	}
+	for _, part := range parts {
+		message.Content += part.Text
+	}
+	return message, nil
+}
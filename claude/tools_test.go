@@ -0,0 +1,87 @@
+package claude
+
+import (
+	"encoding/json"
+	"testing"
+
+	sysopenai "github.com/sashabaranov/go-openai"
+)
+
+func TestOpenaiConvertSonnetTools(t *testing.T) {
+	in := sysopenai.ChatCompletionRequest{
+		Model: "anthropic.claude-3-haiku-20240307-v1:0",
+		Messages: []sysopenai.ChatCompletionMessage{
+			{Role: sysopenai.ChatMessageRoleUser, Content: "Weather in Paris and Rome?"},
+			{Role: sysopenai.ChatMessageRoleAssistant, ToolCalls: []sysopenai.ToolCall{
+				{ID: "call_1", Type: sysopenai.ToolTypeFunction, Function: sysopenai.FunctionCall{Name: "get_weather", Arguments: `{"city":"Paris"}`}},
+				{ID: "call_2", Type: sysopenai.ToolTypeFunction, Function: sysopenai.FunctionCall{Name: "get_weather", Arguments: `{"city":"Rome"}`}},
+			}},
+			{Role: sysopenai.ChatMessageRoleTool, ToolCallID: "call_1", Content: "sunny"},
+			{Role: sysopenai.ChatMessageRoleTool, ToolCallID: "call_2", Content: "rainy"},
+		},
+		Tools:      []sysopenai.Tool{{Type: sysopenai.ToolTypeFunction, Function: &sysopenai.FunctionDefinition{Name: "get_weather"}}},
+		ToolChoice: "required",
+	}
+
+	req, err := OpenaiConvertSonnet(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(req.Tools) != 1 || req.Tools[0].Name != "get_weather" || req.ToolChoice.Type != ToolChoiceAny {
+		t.Errorf("unexpected tools: %+v, %+v", req.Tools, req.ToolChoice)
+	}
+	if len(req.Messages) != 3 {
+		t.Fatalf("got %d messages, want 3", len(req.Messages))
+	}
+	uses := req.Messages[1].Content.([]Content)
+	if len(uses) != 2 || uses[0].Type != ContentTypeToolUse || string(uses[1].Input) != `{"city":"Rome"}` {
+		t.Errorf("unexpected tool uses: %+v", uses)
+	}
+	results := req.Messages[2].Content.([]Content)
+	if req.Messages[2].Role != sysopenai.ChatMessageRoleUser || len(results) != 2 || results[1].ToolUseID != "call_2" || results[1].Content != "rainy" {
+		t.Errorf("unexpected tool results: %+v", results)
+	}
+}
+
+func TestClaudeResponseToolCalls(t *testing.T) {
+	var resp ClaudeResponse
+	err := json.Unmarshal([]byte(`{"id":"msg_1","role":"assistant","model":"claude-3-5-sonnet-20240620","stop_reason":"tool_use",
+		"content":[{"type":"text","text":"Checking."},{"type":"tool_use","id":"toolu_1","name":"get_weather","input":{"city":"Paris"}}],
+		"usage":{"input_tokens":10,"output_tokens":5}}`), &resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	choice := resp.Openai(nil).Choices[0]
+	if choice.FinishReason != sysopenai.FinishReasonToolCalls || choice.Message.Content != "Checking." {
+		t.Errorf("unexpected choice: %+v", choice)
+	}
+	if calls := choice.Message.ToolCalls; len(calls) != 1 || calls[0].ID != "toolu_1" || calls[0].Function.Arguments != `{"city":"Paris"}` {
+		t.Errorf("unexpected tool calls: %+v", calls)
+	}
+}
+
+func TestContentToolMessageImages(t *testing.T) {
+	var c Content
+	err := json.Unmarshal([]byte(`{"type":"tool_result","tool_use_id":"toolu_1","content":[
+		{"type":"text","text":"chart:"},
+		{"type":"image","source":{"type":"base64","media_type":"image/png","data":"iVBORw0KGgo="}}]}`), &c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	message, err := c.ToolMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := message.MultiContent
+	if message.ToolCallID != "toolu_1" || message.Content != "" || len(parts) != 2 || parts[0].Text != "chart:" ||
+		parts[1].ImageURL == nil || parts[1].ImageURL.URL != "data:image/png;base64,iVBORw0KGgo=" {
+		t.Errorf("unexpected tool message: %+v", message)
+	}
+
+	c.Content = []interface{}{map[string]interface{}{"type": "document"}}
+	if _, err := c.ToolMessage(); err == nil {
+		t.Error("expected an error for a document block")
+	}
+}
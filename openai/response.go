@@ -16,7 +16,12 @@ limitations under the License.
 
 package openai
 
-import "encoding/json"
+import (
+	"encoding/json"
+
+	"github.com/bytemind-io/corekit/token"
+	"github.com/sashabaranov/go-openai"
+)
 
 type ChatCodeResponseV4 struct {
 	Created        int           `json:"created"`
@@ -29,10 +34,63 @@ type ChatCodeResponseV4 struct {
 	Usage          Usage         `json:"usage,omitempty"`
 }
 
+// Usage is the usage of a request, the details break the prompt and completion tokens down.
 type Usage struct {
-	PromptTokens     int `json:"prompt_tokens"`
-	CompletionTokens int `json:"completion_tokens"`
-	TotalTokens      int `json:"total_tokens"`
+	PromptTokens            int                      `json:"prompt_tokens"`
+	CompletionTokens        int                      `json:"completion_tokens"`
+	TotalTokens             int                      `json:"total_tokens"`
+	PromptTokensDetails     *PromptTokensDetails     `json:"prompt_tokens_details,omitempty"`
+	CompletionTokensDetails *CompletionTokensDetails `json:"completion_tokens_details,omitempty"`
+}
+
+// PromptTokensDetails are the buckets of the prompt tokens.
+type PromptTokensDetails struct {
+	CachedTokens        int `json:"cached_tokens"`                   // 缓存命中
+	CacheCreationTokens int `json:"cache_creation_tokens,omitempty"` // 写入缓存, Anthropic 独有
+	AudioTokens         int `json:"audio_tokens,omitempty"`
+}
+
+// CompletionTokensDetails are the buckets of the completion tokens.
+type CompletionTokensDetails struct {
+	ReasoningTokens int `json:"reasoning_tokens"`
+	AudioTokens     int `json:"audio_tokens,omitempty"`
+}
+
+// OpenAI converts the Usage to the go-openai usage, the details are dropped.
+func (u Usage) OpenAI() openai.Usage {
+	return openai.Usage{
+		PromptTokens:     u.PromptTokens,
+		CompletionTokens: u.CompletionTokens,
+		TotalTokens:      u.TotalTokens,
+	}
+}
+
+// ChatCompletionUsageResponse is the go-openai chat completion with the usage details, the usage
+// of go-openai has no details.
+type ChatCompletionUsageResponse struct {
+	openai.ChatCompletionResponse
+	Usage Usage `json:"usage"`
+}
+
+// ChatCompletionStreamUsageResponse is the go-openai chunk with the usage details.
+type ChatCompletionStreamUsageResponse struct {
+	openai.ChatCompletionStreamResponse
+	Usage *Usage `json:"usage,omitempty"`
+}
+
+// Billing converts the Usage to the billable usage, every bucket is priced separately.[计费用量]
+func (u Usage) Billing() token.Usage {
+	usage := token.Usage{InputTokens: u.PromptTokens, OutputTokens: u.CompletionTokens}
+	if details := u.PromptTokensDetails; details != nil {
+		usage.CachedInputTokens = details.CachedTokens
+		usage.CacheWriteTokens = details.CacheCreationTokens
+		usage.AudioInputTokens = details.AudioTokens
+	}
+	if details := u.CompletionTokensDetails; details != nil {
+		usage.ReasoningTokens = details.ReasoningTokens
+		usage.AudioOutputTokens = details.AudioTokens
+	}
+	return usage
 }
 
 // ChatCompletionResponse represents the response of the ChatCompletion API.
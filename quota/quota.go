@@ -0,0 +1,256 @@
+/*
+Copyright 2024 The corekit Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package quota
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/bytemind-io/corekit/errors"
+	"github.com/bytemind-io/corekit/redisdb"
+	"github.com/bytemind-io/corekit/token"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/sashabaranov/go-openai"
+)
+
+const (
+	// defaultKeyPrefix is the prefix of the keys if none is given.
+	defaultKeyPrefix = "quota"
+	// defaultHoldTTL is how long a reservation is held if it is never settled or refunded.
+	defaultHoldTTL = 10 * time.Minute
+)
+
+// releaseExpired returns the reservations of KEYS[2] whose deadline in KEYS[3] has passed to the
+// balance KEYS[1], the deadlines are in milliseconds of the redis clock. It prefixes every script.
+const releaseExpired = `
+local now = redis.call('TIME')
+local nowMs = tonumber(now[1]) * 1000 + math.floor(tonumber(now[2]) / 1000)
+for _, id in ipairs(redis.call('ZRANGEBYSCORE', KEYS[3], '-inf', nowMs)) do
+	local held = redis.call('HGET', KEYS[2], id)
+	if held then
+		redis.call('INCRBY', KEYS[1], tonumber(held))
+		redis.call('HDEL', KEYS[2], id)
+	end
+	redis.call('ZREM', KEYS[3], id)
+end
+`
+
+// reserveScript deducts ARGV[2] from the balance KEYS[1] and holds it as the reservation ARGV[1]
+// of KEYS[2] for ARGV[3] milliseconds, nothing is deducted if the balance is short. A hold without
+// a ttl never expires. It returns {ok, balance}.
+var reserveScript = redis.NewScript(releaseExpired + `
+local balance = tonumber(redis.call('GET', KEYS[1]) or '0')
+local amount = tonumber(ARGV[2])
+if balance < amount then
+	return {0, balance}
+end
+balance = redis.call('DECRBY', KEYS[1], amount)
+redis.call('HSET', KEYS[2], ARGV[1], amount)
+local ttl = tonumber(ARGV[3])
+if ttl > 0 then
+	redis.call('ZADD', KEYS[3], nowMs + ttl, ARGV[1])
+end
+return {1, balance}
+`)
+
+// settleScript releases the reservation ARGV[1] of KEYS[2] and deducts ARGV[2] instead,
+// the difference is returned to the balance KEYS[1]. It returns {ok, balance}.
+var settleScript = redis.NewScript(releaseExpired + `
+local held = redis.call('HGET', KEYS[2], ARGV[1])
+if not held then
+	return {0, 0}
+end
+redis.call('HDEL', KEYS[2], ARGV[1])
+redis.call('ZREM', KEYS[3], ARGV[1])
+local balance = redis.call('INCRBY', KEYS[1], tonumber(held) - tonumber(ARGV[2]))
+return {1, balance}
+`)
+
+// heldScript sums the reservations of KEYS[2] and returns {balance, held}.
+var heldScript = redis.NewScript(releaseExpired + `
+local balance = tonumber(redis.call('GET', KEYS[1]) or '0')
+local held = 0
+for _, amount in ipairs(redis.call('HVALS', KEYS[2])) do
+	held = held + tonumber(amount)
+end
+return {balance, held}
+`)
+
+// ErrReservationNotFound is returned when a reservation is already settled, refunded or expired.
+var ErrReservationNotFound = &errors.APIError{
+	Code:           errors.ENotFound,
+	Message:        "quota reservation not found",
+	HTTPStatusCode: http.StatusNotFound,
+}
+
+// Reservation is the quota held for a request until it is settled or refunded.
+type Reservation struct {
+	ID      string `json:"id"`
+	User    string `json:"user"`
+	Model   string `json:"model"`
+	Quota   int    `json:"quota"`   // 预扣额度
+	Balance int64  `json:"balance"` // 预扣后余额
+}
+
+// Balance is the quota of a user.
+type Balance struct {
+	Available int64 `json:"available"` // 可用额度
+	Held      int64 `json:"held"`      // 预扣中的额度
+}
+
+// Ledger reserves and settles the quota of the users on redis, the balance is in token.USD units.[额度账本]
+// The keys of a user share a hash tag, so the scripts also work on a redis cluster.
+type Ledger struct {
+	client  redis.UniversalClient
+	prefix  string
+	holdTTL time.Duration
+}
+
+// NewLedger creates a Ledger on the redis, the keys are prefixed by prefix, "quota" by default.
+func NewLedger(r *redisdb.Redis, prefix string) *Ledger {
+	if prefix == "" {
+		prefix = defaultKeyPrefix
+	}
+	return &Ledger{client: r.Client(), prefix: prefix, holdTTL: defaultHoldTTL}
+}
+
+// SetHoldTTL sets how long a reservation is held, 10 minutes by default. An expired reservation
+// is returned to the balance by the next call on the user, so the ttl must exceed the longest
+// request. A ttl <= 0 holds the reservations until they are settled. It is not safe to call it
+// concurrently with the other methods.
+func (l *Ledger) SetHoldTTL(ttl time.Duration) {
+	l.holdTTL = ttl
+}
+
+// balanceKey is the key of the balance of the user.
+func (l *Ledger) balanceKey(user string) string {
+	return fmt.Sprintf("%s:{%s}:balance", l.prefix, user)
+}
+
+// holdsKey is the hash of the reservations of the user.
+func (l *Ledger) holdsKey(user string) string {
+	return fmt.Sprintf("%s:{%s}:holds", l.prefix, user)
+}
+
+// keys returns the balance, the reservations and the deadlines of the reservations of the user.
+func (l *Ledger) keys(user string) []string {
+	return []string{l.balanceKey(user), l.holdsKey(user), fmt.Sprintf("%s:{%s}:deadlines", l.prefix, user)}
+}
+
+// Balance returns the available and held quota of the user.
+func (l *Ledger) Balance(ctx context.Context, user string) (*Balance, error) {
+	vals, err := heldScript.Run(ctx, l.client, l.keys(user)).Int64Slice()
+	if err != nil {
+		return nil, fmt.Errorf("get balance of %s: %w", user, err)
+	}
+	return &Balance{Available: vals[0], Held: vals[1]}, nil
+}
+
+// TopUp adds quota to the balance of the user, a negative quota deducts it. It returns the new balance.
+func (l *Ledger) TopUp(ctx context.Context, user string, quota int64) (int64, error) {
+	balance, err := l.client.IncrBy(ctx, l.balanceKey(user), quota).Result()
+	if err != nil {
+		return 0, fmt.Errorf("top up %s: %w", user, err)
+	}
+	return balance, nil
+}
+
+// Reserve deducts the quota from the balance of the user atomically, an errors.EPaymentRequired
+// APIError is returned if the balance is short. The reservations expired so far are released first.[预扣额度]
+func (l *Ledger) Reserve(ctx context.Context, user, model string, quota int) (*Reservation, error) {
+	if quota < 0 {
+		return nil, fmt.Errorf("invalid quota to reserve: %d", quota)
+	}
+
+	id := uuid.NewString()
+	vals, err := reserveScript.Run(ctx, l.client, l.keys(user), id, quota, l.holdTTL.Milliseconds()).Int64Slice()
+	if err != nil {
+		return nil, fmt.Errorf("reserve quota of %s: %w", user, err)
+	}
+	if vals[0] == 0 {
+		return nil, &errors.APIError{
+			Code:           errors.EPaymentRequired,
+			Message:        fmt.Sprintf("insufficient quota: %d required, %d available", quota, vals[1]),
+			HTTPStatusCode: http.StatusPaymentRequired,
+		}
+	}
+	return &Reservation{ID: id, User: user, Model: model, Quota: quota, Balance: vals[1]}, nil
+}
+
+// ReserveChat reserves the estimated quota of a chat request, see EstimateChat.
+func (l *Ledger) ReserveChat(ctx context.Context, user string, req *openai.ChatCompletionRequest) (*Reservation, error) {
+	quota, err := EstimateChat(req)
+	if err != nil {
+		return nil, err
+	}
+	return l.Reserve(ctx, user, req.Model, quota)
+}
+
+// Settle replaces the reservation with the quota actually used and returns the new balance,
+// the balance may go below zero if the used quota exceeds the reservation.[结算额度]
+func (l *Ledger) Settle(ctx context.Context, r *Reservation, quota int) (int64, error) {
+	if quota < 0 {
+		return 0, fmt.Errorf("invalid quota to settle: %d", quota)
+	}
+
+	vals, err := settleScript.Run(ctx, l.client, l.keys(r.User), r.ID, quota).Int64Slice()
+	if err != nil {
+		return 0, fmt.Errorf("settle quota of %s: %w", r.User, err)
+	}
+	if vals[0] == 0 {
+		return 0, ErrReservationNotFound
+	}
+	return vals[1], nil
+}
+
+// SettleUsage prices the usage of the reserved model and settles the reservation with it.
+func (l *Ledger) SettleUsage(ctx context.Context, r *Reservation, usage token.Usage) (*token.Billing, error) {
+	cost, err := token.CalculateCost(r.Model, usage)
+	if err != nil {
+		return nil, err
+	}
+	billing := token.NewBilling(cost)
+	if _, err := l.Settle(ctx, r, billing.Quota); err != nil {
+		return nil, err
+	}
+	return &billing, nil
+}
+
+// Refund returns the whole reservation to the balance, e.g. when the upstream request fails.[退还额度]
+func (l *Ledger) Refund(ctx context.Context, r *Reservation) (int64, error) {
+	return l.Settle(ctx, r, 0)
+}
+
+// EstimateChat estimates the quota of a chat request as the prompt tokens plus max_tokens at the
+// completion price, max_tokens defaults to the max output of the model.
+func EstimateChat(req *openai.ChatCompletionRequest) (int, error) {
+	promptTokens, err := token.CalculateRequestToken(req, req.Model)
+	if err != nil {
+		return 0, err
+	}
+	cost, err := token.CalculateCost(req.Model, token.Usage{
+		InputTokens:  promptTokens,
+		OutputTokens: token.ClampMaxTokens(req.Model, req.MaxTokens),
+	})
+	if err != nil {
+		return 0, err
+	}
+	return token.CostToQuota(cost.Total), nil
+}
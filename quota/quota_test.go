@@ -0,0 +1,145 @@
+package quota
+
+import (
+	"context"
+	stderrors "errors"
+	"testing"
+	"time"
+
+	"github.com/bytemind-io/corekit/errors"
+	"github.com/bytemind-io/corekit/redisdb"
+	"github.com/google/uuid"
+)
+
+// newTestLedger creates a Ledger on the redis of redisdb/docker-compose.yaml under a prefix of
+// its own, the test is skipped if the redis is not running.
+func newTestLedger(t *testing.T) *Ledger {
+	r, err := redisdb.NewRedis(redisdb.NewConfig())
+	if err != nil {
+		t.Skipf("redis is not available: %v", err)
+	}
+	prefix := "quota-test-" + uuid.NewString()
+	t.Cleanup(func() {
+		ctx := context.Background()
+		for _, key := range r.Keys(ctx, prefix+":*") {
+			r.Del(ctx, key)
+		}
+	})
+	return NewLedger(r, prefix)
+}
+
+// expectBalance checks the available and held quota of the user.
+func expectBalance(t *testing.T, l *Ledger, user string, available, held int64) {
+	t.Helper()
+	balance, err := l.Balance(context.Background(), user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance.Available != available || balance.Held != held {
+		t.Errorf("got balance %+v, want %d available and %d held", balance, available, held)
+	}
+}
+
+func TestLedgerReserve(t *testing.T) {
+	l := newTestLedger(t)
+	ctx := context.Background()
+	if _, err := l.TopUp(ctx, "alice", 100); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := l.Reserve(ctx, "alice", "gpt-4o", 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Balance != 40 {
+		t.Errorf("got balance %d after reserve, want 40", r.Balance)
+	}
+	expectBalance(t, l, "alice", 40, 60)
+
+	// nothing is deducted if the balance is short.
+	_, err = l.Reserve(ctx, "alice", "gpt-4o", 41)
+	var apiErr *errors.APIError
+	if !stderrors.As(err, &apiErr) || apiErr.Code != errors.EPaymentRequired {
+		t.Fatalf("expected EPaymentRequired, got %v", err)
+	}
+	expectBalance(t, l, "alice", 40, 60)
+}
+
+func TestLedgerSettle(t *testing.T) {
+	l := newTestLedger(t)
+	ctx := context.Background()
+	if _, err := l.TopUp(ctx, "alice", 100); err != nil {
+		t.Fatal(err)
+	}
+	r, err := l.Reserve(ctx, "alice", "gpt-4o", 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the unused quota of the reservation is returned.
+	balance, err := l.Settle(ctx, r, 25)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance != 75 {
+		t.Errorf("got balance %d after settle, want 75", balance)
+	}
+	if _, err := l.Settle(ctx, r, 25); err != ErrReservationNotFound {
+		t.Errorf("expected ErrReservationNotFound for the second settle, got %v", err)
+	}
+	if _, err := l.Refund(ctx, r); err != ErrReservationNotFound {
+		t.Errorf("expected ErrReservationNotFound for the refund of a settled reservation, got %v", err)
+	}
+	expectBalance(t, l, "alice", 75, 0)
+}
+
+func TestLedgerRefund(t *testing.T) {
+	l := newTestLedger(t)
+	ctx := context.Background()
+	if _, err := l.TopUp(ctx, "alice", 100); err != nil {
+		t.Fatal(err)
+	}
+	r, err := l.Reserve(ctx, "alice", "gpt-4o", 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := l.Refund(ctx, &Reservation{ID: uuid.NewString(), User: "alice"}); err != ErrReservationNotFound {
+		t.Errorf("expected ErrReservationNotFound for an unknown reservation, got %v", err)
+	}
+	expectBalance(t, l, "alice", 40, 60)
+
+	if balance, err := l.Refund(ctx, r); err != nil || balance != 100 {
+		t.Errorf("got balance %d after refund, want 100: %v", balance, err)
+	}
+	expectBalance(t, l, "alice", 100, 0)
+}
+
+func TestLedgerHoldExpiry(t *testing.T) {
+	l := newTestLedger(t)
+	l.SetHoldTTL(100 * time.Millisecond)
+	ctx := context.Background()
+	if _, err := l.TopUp(ctx, "alice", 100); err != nil {
+		t.Fatal(err)
+	}
+	expired, err := l.Reserve(ctx, "alice", "gpt-4o", 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	// the expired reservation is released before the new one is reserved.
+	r, err := l.Reserve(ctx, "alice", "gpt-4o", 80)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Balance != 20 {
+		t.Errorf("got balance %d after reserve, want 20", r.Balance)
+	}
+	if _, err := l.Settle(ctx, expired, 60); err != ErrReservationNotFound {
+		t.Errorf("expected ErrReservationNotFound for the expired reservation, got %v", err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	expectBalance(t, l, "alice", 100, 0)
+}
@@ -0,0 +1,47 @@
+/*
+Copyright 2024 The corego Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package token
+
+import "math"
+
+// AudioBilling is the billing of a transcription or translation request.
+type AudioBilling struct {
+	Info *AudioInfo `json:"info"`
+	// Seconds is the billable duration, rounded up to a whole second.
+	Seconds int `json:"seconds"`
+	Billing
+}
+
+// CalculateAudioBilling probes the duration of the uploaded audio and prices it with the
+// default pricing, e.g. whisper-1 is billed per second.[音频计费]
+func CalculateAudioBilling(audio []byte, model string) (*AudioBilling, error) {
+	info, err := ProbeAudio(audio)
+	if err != nil {
+		return nil, err
+	}
+
+	billable := int(math.Ceil(info.Duration.Seconds()))
+	cost, err := CalculateCost(model, Usage{AudioSeconds: float64(billable)})
+	if err != nil {
+		return nil, err
+	}
+	return &AudioBilling{
+		Info:    info,
+		Seconds: billable,
+		Billing: NewBilling(cost),
+	}, nil
+}
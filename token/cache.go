@@ -0,0 +1,196 @@
+/*
+Copyright 2024 The corego Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package token
+
+import (
+	"container/list"
+	"hash/maphash"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+const (
+	// minCachedTextLen skips the short texts like roles and names, encoding them is cheaper than caching.
+	minCachedTextLen = 64
+	// parallelCountThreshold is the number of messages counted across goroutines.
+	parallelCountThreshold = 64
+)
+
+// CacheStats are the statistics of the token cache.
+type CacheStats struct {
+	Hits     uint64  `json:"hits"`
+	Misses   uint64  `json:"misses"`
+	Size     int     `json:"size"`
+	Capacity int     `json:"capacity"`
+	HitRate  float64 `json:"hit_rate"`
+}
+
+// cacheKey identifies a text of an encoding, the length makes a hash collision even less likely.
+type cacheKey struct {
+	encoding string
+	hash     uint64
+	length   int
+}
+
+// cacheEntry is an element of the LRU list.
+type cacheEntry struct {
+	key    cacheKey
+	tokens int
+}
+
+// tokenCache is a bounded LRU cache of token counts.
+type tokenCache struct {
+	mu       sync.Mutex
+	capacity int
+	entries  map[cacheKey]*list.Element
+	lru      *list.List
+	seed     maphash.Seed
+	hits     atomic.Uint64
+	misses   atomic.Uint64
+}
+
+// cache is the token cache, nil when disabled.
+var cache atomic.Pointer[tokenCache]
+
+// EnableTokenCache caches the token counts of up to capacity texts, a capacity <= 0 disables the cache.
+// The statistics are reset.[Token计数缓存]
+func EnableTokenCache(capacity int) {
+	if capacity <= 0 {
+		cache.Store(nil)
+		return
+	}
+	cache.Store(&tokenCache{
+		capacity: capacity,
+		entries:  make(map[cacheKey]*list.Element, capacity),
+		lru:      list.New(),
+		seed:     maphash.MakeSeed(),
+	})
+}
+
+// TokenCacheStats returns the statistics of the token cache, zero when disabled.
+func TokenCacheStats() CacheStats {
+	c := cache.Load()
+	if c == nil {
+		return CacheStats{}
+	}
+
+	c.mu.Lock()
+	size := c.lru.Len()
+	c.mu.Unlock()
+
+	stats := CacheStats{Hits: c.hits.Load(), Misses: c.misses.Load(), Size: size, Capacity: c.capacity}
+	if total := stats.Hits + stats.Misses; total != 0 {
+		stats.HitRate = float64(stats.Hits) / float64(total)
+	}
+	return stats
+}
+
+// count returns the cached tokens of the text, the text is encoded on a miss.
+func (c *tokenCache) count(tokenEncoder Tokenizer, text string) int {
+	key := cacheKey{encoding: tokenEncoder.Name(), hash: maphash.String(c.seed, text), length: len(text)}
+
+	c.mu.Lock()
+	if elem, ok := c.entries[key]; ok {
+		c.lru.MoveToFront(elem)
+		tokens := elem.Value.(*cacheEntry).tokens
+		c.mu.Unlock()
+		c.hits.Add(1)
+		return tokens
+	}
+	c.mu.Unlock()
+	c.misses.Add(1)
+
+	// encode without the lock, a concurrent miss of the same text only encodes twice.
+	tokens := len(tokenEncoder.Encode(text))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		c.lru.MoveToFront(elem)
+		return tokens
+	}
+	c.entries[key] = c.lru.PushFront(&cacheEntry{key: key, tokens: tokens})
+	if c.lru.Len() > c.capacity {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+	return tokens
+}
+
+// clearEncoding removes the cached tokens of the encoding.
+func (c *tokenCache) clearEncoding(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for elem := c.lru.Front(); elem != nil; {
+		next := elem.Next()
+		if key := elem.Value.(*cacheEntry).key; key.encoding == name {
+			c.lru.Remove(elem)
+			delete(c.entries, key)
+		}
+		elem = next
+	}
+}
+
+// clearCachedEncoding removes the cached tokens of the encoding when its tokenizer is replaced.
+func clearCachedEncoding(name string) {
+	if c := cache.Load(); c != nil {
+		c.clearEncoding(name)
+	}
+}
+
+// countContents returns the tokens of the text content of every message, a large array is
+// counted across goroutines.
+func countContents(tokenEncoder Tokenizer, messages []openai.ChatCompletionMessage) []int {
+	tokens := make([]int, len(messages))
+	count := func(idx int) {
+		message := messages[idx]
+		if message.Content != "" {
+			tokens[idx] = getTokenNum(tokenEncoder, message.Content)
+			return
+		}
+		for _, part := range message.MultiContent {
+			if part.Type != openai.ChatMessagePartTypeImageURL {
+				tokens[idx] += getTokenNum(tokenEncoder, part.Text)
+			}
+		}
+	}
+
+	workers := min(runtime.GOMAXPROCS(0), len(messages)/parallelCountThreshold+1)
+	if workers <= 1 {
+		for idx := range messages {
+			count(idx)
+		}
+		return tokens
+	}
+
+	var wg sync.WaitGroup
+	for worker := 0; worker < workers; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for idx := worker; idx < len(messages); idx += workers {
+				count(idx)
+			}
+		}(worker)
+	}
+	wg.Wait()
+	return tokens
+}
@@ -0,0 +1,79 @@
+package token
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestTokenCache(t *testing.T) {
+	defer EnableTokenCache(0)
+
+	messages := make([]openai.ChatCompletionMessage, 200)
+	want := 3
+	for idx := range messages {
+		messages[idx] = openai.ChatCompletionMessage{
+			Role:    openai.ChatMessageRoleUser,
+			Content: strings.Repeat("hello ", 12+idx%3),
+		}
+		num, err := CalculateMessage(messages[idx:idx+1], "gpt-4")
+		if err != nil {
+			t.Fatal(err)
+		}
+		want += num - 3
+	}
+
+	EnableTokenCache(8)
+	for i := 0; i < 2; i++ {
+		got, err := CalculateMessage(messages, "gpt-4")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("got %d tokens, want %d", got, want)
+		}
+	}
+
+	stats := TokenCacheStats()
+	if stats.Size != 3 || stats.Hits+stats.Misses != 400 || stats.HitRate < 0.9 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+// chunkTokenizer encodes every size bytes as a token.
+type chunkTokenizer struct {
+	name string
+	size int
+}
+
+func (c chunkTokenizer) Name() string { return c.name }
+
+func (c chunkTokenizer) Encode(text string) []int { return make([]int, len(text)/c.size) }
+
+func (c chunkTokenizer) Decode(ids []int) string { return "" }
+
+func TestTokenCacheReregister(t *testing.T) {
+	defer EnableTokenCache(0)
+	EnableTokenCache(8)
+
+	messages := []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: strings.Repeat("a", 128)}}
+	var counts []int
+	for _, size := range []int{1, 2} {
+		if err := RegisterTokenizer(chunkTokenizer{name: "cache_chunks", size: size}); err != nil {
+			t.Fatal(err)
+		}
+		if err := RegisterModelEncoding("cache-model", "cache_chunks"); err != nil {
+			t.Fatal(err)
+		}
+		num, err := CalculateMessage(messages, "cache-model")
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts = append(counts, num)
+	}
+	// the content and the role are 128+4 tokens of the first tokenizer and 64+2 of the second.
+	if counts[0]-counts[1] != 66 {
+		t.Errorf("got %v tokens, the cached count of the replaced tokenizer was used", counts)
+	}
+}
@@ -0,0 +1,186 @@
+/*
+Copyright 2024 The corego Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package token
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// chunkSeparators are the boundaries tried in order, a span larger than the window is split at
+// paragraphs, then lines, sentences and words, and at last between runes.
+var chunkSeparators = []string{"\n\n", "\n", ". ", "! ", "? ", "。", "！", "？", "; ", "；", ", ", "，", " "}
+
+// ChunkOptions are the options of ChunkText.
+type ChunkOptions struct {
+	MaxTokens int // 每块最大 token 数
+	Overlap   int // 相邻块重叠的 token 数
+}
+
+// Validate validates the ChunkOptions.
+func (o ChunkOptions) Validate() error {
+	if o.MaxTokens <= 0 {
+		return fmt.Errorf("max tokens must be positive")
+	}
+	if o.Overlap < 0 || o.Overlap >= o.MaxTokens {
+		return fmt.Errorf("overlap must be in [0, %d)", o.MaxTokens)
+	}
+	return nil
+}
+
+// Chunk is a window of the text.
+type Chunk struct {
+	Text   string `json:"text"`
+	Start  int    `json:"start"` // 原文中的起始字节偏移
+	End    int    `json:"end"`   // 原文中的结束字节偏移, 不包含
+	Tokens int    `json:"tokens"`
+}
+
+// chunkSpan is a piece of the text and its tokens.
+type chunkSpan struct {
+	start  int
+	end    int
+	tokens int
+}
+
+// chunker splits the text with the tokenizer.
+type chunker struct {
+	tokenEncoder Tokenizer
+	text         string
+	maxTokens    int
+}
+
+// ChunkText splits the text into windows of at most MaxTokens tokens of the model, adjacent windows
+// share about Overlap tokens. The windows end at paragraph, sentence or word boundaries when possible,
+// their Start and End are byte offsets into text.[文本分块]
+func ChunkText(text, model string, opts ChunkOptions) ([]Chunk, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	tokenEncoder, err := getTokenEncoder(model)
+	if err != nil {
+		return nil, err
+	}
+	if text == "" {
+		return nil, nil
+	}
+
+	c := &chunker{tokenEncoder: tokenEncoder, text: text, maxTokens: opts.MaxTokens}
+	spans := c.split(0, len(text), 0)
+
+	var chunks []Chunk
+	for first := 0; first < len(spans); {
+		last, tokens := first, 0
+		for last < len(spans) && (last == first || tokens+spans[last].tokens <= opts.MaxTokens) {
+			tokens += spans[last].tokens
+			last++
+		}
+
+		// the spans are counted alone, the joined text may count differently.
+		start := spans[first].start
+		tokens = c.count(start, spans[last-1].end)
+		for last-first > 1 && tokens > opts.MaxTokens {
+			last--
+			tokens = c.count(start, spans[last-1].end)
+		}
+
+		end := spans[last-1].end
+		chunks = append(chunks, Chunk{Text: text[start:end], Start: start, End: end, Tokens: tokens})
+		if last == len(spans) {
+			break
+		}
+
+		next, overlap := last, 0
+		for next-1 > first && overlap+spans[next-1].tokens <= opts.Overlap {
+			overlap += spans[next-1].tokens
+			next--
+		}
+		first = next
+	}
+	return chunks, nil
+}
+
+// count returns the tokens of text[start:end].
+func (c *chunker) count(start, end int) int {
+	return getTokenNum(c.tokenEncoder, c.text[start:end])
+}
+
+// split splits text[start:end] into spans that fit the window, starting with chunkSeparators[level].
+func (c *chunker) split(start, end, level int) []chunkSpan {
+	tokens := c.count(start, end)
+	if tokens <= c.maxTokens {
+		return []chunkSpan{{start: start, end: end, tokens: tokens}}
+	}
+
+	for ; level < len(chunkSeparators); level++ {
+		pieces := splitAfter(c.text, start, end, chunkSeparators[level])
+		if len(pieces) < 2 {
+			continue
+		}
+
+		var spans []chunkSpan
+		for _, piece := range pieces {
+			spans = append(spans, c.split(piece.start, piece.end, level+1)...)
+		}
+		return spans
+	}
+	return c.splitRunes(start, end)
+}
+
+// splitRunes splits text[start:end] between runes, every span is the longest prefix that fits the window.
+func (c *chunker) splitRunes(start, end int) []chunkSpan {
+	var spans []chunkSpan
+	for start < end {
+		var offsets []int
+		for offset := start; offset < end; {
+			_, size := utf8.DecodeRuneInString(c.text[offset:end])
+			offset += size
+			offsets = append(offsets, offset)
+		}
+
+		n := sort.Search(len(offsets), func(i int) bool {
+			return c.count(start, offsets[i]) > c.maxTokens
+		})
+		if n == 0 {
+			n = 1
+		}
+		stop := offsets[n-1]
+		spans = append(spans, chunkSpan{start: start, end: stop, tokens: c.count(start, stop)})
+		start = stop
+	}
+	return spans
+}
+
+// splitAfter splits text[start:end] after every sep, the separators are kept in the pieces.
+func splitAfter(text string, start, end int, sep string) []chunkSpan {
+	var pieces []chunkSpan
+	for start < end {
+		idx := strings.Index(text[start:end], sep)
+		if idx < 0 {
+			break
+		}
+		stop := start + idx + len(sep)
+		pieces = append(pieces, chunkSpan{start: start, end: stop})
+		start = stop
+	}
+	if start < end {
+		pieces = append(pieces, chunkSpan{start: start, end: end})
+	}
+	return pieces
+}
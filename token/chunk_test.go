@@ -0,0 +1,65 @@
+package token
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestChunkText(t *testing.T) {
+	text := "hello hello hello.\n\nhello hello. hello hello hello hello hello hello hello hello."
+	chunks, err := ChunkText(text, "gpt-4", ChunkOptions{MaxTokens: 8, Overlap: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chunks) < 2 {
+		t.Fatalf("unexpected chunks: %+v", chunks)
+	}
+	if chunks[0].Start != 0 || chunks[len(chunks)-1].End != len(text) {
+		t.Errorf("chunks do not cover the text: %+v", chunks)
+	}
+	if !strings.HasSuffix(chunks[0].Text, "\n\n") {
+		t.Errorf("expected the first chunk to end at the paragraph: %q", chunks[0].Text)
+	}
+	for idx, chunk := range chunks {
+		if chunk.Text != text[chunk.Start:chunk.End] || chunk.Tokens > 8 {
+			t.Errorf("unexpected chunk: %+v", chunk)
+		}
+		if idx > 0 && chunk.Start > chunks[idx-1].End {
+			t.Errorf("gap before chunk %d", idx)
+		}
+	}
+
+	if _, err := ChunkText(text, "gpt-4", ChunkOptions{MaxTokens: 8, Overlap: 8}); err == nil {
+		t.Error("expected an error for the overlap")
+	}
+}
+
+func TestCalculateEmbeddingToken(t *testing.T) {
+	var decoded openai.EmbeddingRequest
+	if err := json.Unmarshal([]byte(`{"input":[[1,2,3],[4]]}`), &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		input any
+		want  int
+	}{
+		{"hello hello", 3},
+		{[]string{"hello", "hello"}, 2},
+		{[]int{1, 2, 3}, 3},
+		{openai.EmbeddingRequestTokens{Input: [][]int{{1, 2}, {3}}}, 3},
+		{decoded, 4},
+	}
+	for _, tt := range tests {
+		got, err := CalculateEmbeddingToken(tt.input, "text-embedding-3-small")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("%v: got %d, want %d", tt.input, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,321 @@
+/*
+Copyright 2024 The corego Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package token
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// DefaultMaxOutputTokens is the max output of an unknown model.
+const DefaultMaxOutputTokens = 4096
+
+// ErrContextLengthExceeded is returned when the kept messages do not fit the context window.
+var ErrContextLengthExceeded = errors.New("context length exceeded")
+
+// ModelLimit is the token limit of a model.
+type ModelLimit struct {
+	ContextWindow int `json:"context_window"` // 上下文窗口, 包含输入和输出
+	MaxOutput     int `json:"max_output"`     // 最大输出
+}
+
+// Validate validates the ModelLimit.
+func (l ModelLimit) Validate() error {
+	if l.ContextWindow <= 0 {
+		return fmt.Errorf("context window must be positive")
+	}
+	if l.MaxOutput <= 0 || l.MaxOutput > l.ContextWindow {
+		return fmt.Errorf("max output must be in (0, %d]", l.ContextWindow)
+	}
+	return nil
+}
+
+// limitRule is a prefix rule.
+type limitRule struct {
+	prefix string
+	limit  ModelLimit
+}
+
+// modelLimits maps the models to their ModelLimit.
+var modelLimits = struct {
+	mu       sync.RWMutex
+	models   map[string]ModelLimit
+	prefixes []limitRule
+}{models: make(map[string]ModelLimit)}
+
+// https://platform.openai.com/docs/models | https://docs.anthropic.com/en/docs/about-claude/models
+func init() {
+	prefixes := map[string]ModelLimit{
+		"gpt-4o":                       {ContextWindow: 128000, MaxOutput: 4096},
+		"gpt-4o-2024-08-06":            {ContextWindow: 128000, MaxOutput: 16384},
+		"gpt-4o-mini":                  {ContextWindow: 128000, MaxOutput: 16384},
+		"gpt-4":                        {ContextWindow: 8192, MaxOutput: 4096},
+		"gpt-4-32k":                    {ContextWindow: 32768, MaxOutput: 4096},
+		"gpt-4-turbo":                  {ContextWindow: 128000, MaxOutput: 4096},
+		"gpt-4-1106":                   {ContextWindow: 128000, MaxOutput: 4096},
+		"gpt-4-0125":                   {ContextWindow: 128000, MaxOutput: 4096},
+		"gpt-4-vision":                 {ContextWindow: 128000, MaxOutput: 4096},
+		"gpt-3.5-turbo":                {ContextWindow: 16385, MaxOutput: 4096},
+		"gpt-3.5-turbo-0613":           {ContextWindow: 4096, MaxOutput: 4096},
+		"gpt-3.5-turbo-instruct":       {ContextWindow: 4096, MaxOutput: 4096},
+		"claude-instant-1":             {ContextWindow: 100000, MaxOutput: 4096},
+		"claude-2":                     {ContextWindow: 100000, MaxOutput: 4096},
+		"claude-2.1":                   {ContextWindow: 200000, MaxOutput: 4096},
+		"claude-3":                     {ContextWindow: 200000, MaxOutput: 4096},
+		"claude-3-5-sonnet":            {ContextWindow: 200000, MaxOutput: 8192},
+		"anthropic.claude-3":           {ContextWindow: 200000, MaxOutput: 4096},
+		"anthropic.claude-3-5-sonnet":  {ContextWindow: 200000, MaxOutput: 8192},
+		"gemini-pro":                   {ContextWindow: 32760, MaxOutput: 8192},
+		"gemini-1.0-pro":               {ContextWindow: 32760, MaxOutput: 8192},
+		"gemini-1.5-pro":               {ContextWindow: 2097152, MaxOutput: 8192},
+		"gemini-1.5-flash":             {ContextWindow: 1048576, MaxOutput: 8192},
+		"glm-4":                        {ContextWindow: 128000, MaxOutput: 4096},
+		"deepseek-chat":                {ContextWindow: 32768, MaxOutput: 4096},
+		"deepseek-coder":               {ContextWindow: 32768, MaxOutput: 4096},
+		"command-r":                    {ContextWindow: 128000, MaxOutput: 4000},
+		"llama-3-sonar-small-32k-chat": {ContextWindow: 32768, MaxOutput: 4096},
+		"llama-3-sonar-large-32k-chat": {ContextWindow: 32768, MaxOutput: 4096},
+	}
+	for prefix, limit := range prefixes {
+		_ = RegisterModelLimitPrefix(prefix, limit)
+	}
+}
+
+// RegisterModelLimit sets the ModelLimit of a model.
+func RegisterModelLimit(model string, limit ModelLimit) error {
+	if model == "" {
+		return fmt.Errorf("model is required")
+	}
+	if err := limit.Validate(); err != nil {
+		return fmt.Errorf("%s: %w", model, err)
+	}
+
+	modelLimits.mu.Lock()
+	defer modelLimits.mu.Unlock()
+	modelLimits.models[model] = limit
+	return nil
+}
+
+// RegisterModelLimitPrefix sets the ModelLimit of the models starting with prefix,
+// the longest matching prefix wins.
+func RegisterModelLimitPrefix(prefix string, limit ModelLimit) error {
+	if prefix == "" {
+		return fmt.Errorf("prefix is required")
+	}
+	if err := limit.Validate(); err != nil {
+		return fmt.Errorf("%s: %w", prefix, err)
+	}
+
+	modelLimits.mu.Lock()
+	defer modelLimits.mu.Unlock()
+	for idx, rule := range modelLimits.prefixes {
+		if rule.prefix == prefix {
+			modelLimits.prefixes[idx].limit = limit
+			return nil
+		}
+	}
+	modelLimits.prefixes = append(modelLimits.prefixes, limitRule{prefix: prefix, limit: limit})
+	sort.SliceStable(modelLimits.prefixes, func(i, j int) bool {
+		return len(modelLimits.prefixes[i].prefix) > len(modelLimits.prefixes[j].prefix)
+	})
+	return nil
+}
+
+// GetModelLimit returns the ModelLimit of a model, the model aliases of the encoder registry are followed.[模型上下文限制]
+func GetModelLimit(model string) (ModelLimit, bool) {
+	target := defaultRegistry.Resolve(model).Target
+
+	modelLimits.mu.RLock()
+	defer modelLimits.mu.RUnlock()
+	for _, name := range []string{model, target} {
+		if limit, ok := modelLimits.models[name]; ok {
+			return limit, true
+		}
+	}
+	for _, name := range []string{model, target} {
+		for _, rule := range modelLimits.prefixes {
+			if strings.HasPrefix(name, rule.prefix) {
+				return rule.limit, true
+			}
+		}
+	}
+	return ModelLimit{}, false
+}
+
+// defaultOutput returns the output reserved when none is given, at most half of the context window
+// so a model whose max output fills its window still leaves room for the prompt.
+func (l ModelLimit) defaultOutput() int {
+	return min(l.MaxOutput, l.ContextWindow/2)
+}
+
+// ClampMaxTokens returns maxTokens capped by the max output of the model. If maxTokens is not set,
+// the max output is returned, at most half of the context window.
+func ClampMaxTokens(model string, maxTokens int) int {
+	limit, ok := GetModelLimit(model)
+	if !ok {
+		limit = ModelLimit{ContextWindow: 2 * DefaultMaxOutputTokens, MaxOutput: DefaultMaxOutputTokens}
+	}
+	if maxTokens <= 0 {
+		return limit.defaultOutput()
+	}
+	return min(maxTokens, limit.MaxOutput)
+}
+
+// FitResult is the result of FitMessages.
+type FitResult struct {
+	// Messages are the kept messages, including the summary mark if any.
+	Messages []openai.ChatCompletionMessage
+	// Dropped is the number of dropped messages.
+	Dropped int
+	// PromptTokens are the tokens of Messages.
+	PromptTokens int
+	// MaxTokens is the safe max_tokens of the request.
+	MaxTokens int
+}
+
+// FitOptions are the options of FitMessagesWith.
+type FitOptions struct {
+	// ReserveOutput are the tokens reserved for the output, <= 0 reserves the max output of the model,
+	// at most half of the context window.
+	ReserveOutput int
+	// SummaryMark replaces the dropped turns by a system message before the oldest kept turn,
+	// %d is the number of dropped messages, e.g. "[%d earlier messages were omitted]".
+	// The turns are dropped without a mark if it is empty.
+	SummaryMark string
+}
+
+// FitMessages drops the oldest turns until the messages and reserveOutput fit the context window
+// of the model. The system messages and the latest user turn are always kept, ErrContextLengthExceeded
+// is returned if they do not fit. A reserveOutput <= 0 reserves the max output of the model, at most
+// half of the context window.[裁剪上下文]
+func FitMessages(messages []openai.ChatCompletionMessage, model string, reserveOutput int) (*FitResult, error) {
+	return FitMessagesWith(messages, model, FitOptions{ReserveOutput: reserveOutput})
+}
+
+// FitMessagesWith is FitMessages with options, e.g. to mark where the turns were dropped.
+// Every message is counted once, the tokens of the dropped turns are subtracted.
+func FitMessagesWith(messages []openai.ChatCompletionMessage, model string, opts FitOptions) (*FitResult, error) {
+	limit, ok := GetModelLimit(model)
+	if !ok {
+		return nil, fmt.Errorf("unknown context window of model %s", model)
+	}
+	reserveOutput := min(opts.ReserveOutput, limit.MaxOutput)
+	if reserveOutput <= 0 {
+		reserveOutput = limit.defaultOutput()
+	}
+	budget := limit.ContextWindow - reserveOutput
+
+	tokens, err := calculateMessageTokens(messages, model)
+	if err != nil {
+		return nil, err
+	}
+	promptTokens := tokensPerReply
+	for _, num := range tokens {
+		promptTokens += num
+	}
+
+	var (
+		kept       = messages
+		dropped    = 0
+		mark       openai.ChatCompletionMessage
+		markTokens = 0
+	)
+	for promptTokens+markTokens > budget {
+		start, end, ok := oldestTurn(kept)
+		if !ok {
+			return nil, fmt.Errorf("%w: %d prompt tokens and %d output tokens of model %s exceed %d",
+				ErrContextLengthExceeded, promptTokens+markTokens, reserveOutput, model, limit.ContextWindow)
+		}
+
+		nextMessages := make([]openai.ChatCompletionMessage, 0, len(kept))
+		nextTokens := make([]int, 0, len(kept))
+		for idx, message := range kept {
+			if idx >= start && idx < end && message.Role != openai.ChatMessageRoleSystem {
+				promptTokens -= tokens[idx]
+				dropped++
+				continue
+			}
+			nextMessages = append(nextMessages, message)
+			nextTokens = append(nextTokens, tokens[idx])
+		}
+		kept, tokens = nextMessages, nextTokens
+
+		if opts.SummaryMark != "" {
+			mark = openai.ChatCompletionMessage{Role: openai.ChatMessageRoleSystem, Content: fmt.Sprintf(opts.SummaryMark, dropped)}
+			markTokens, err = CalculateMessage([]openai.ChatCompletionMessage{mark}, model)
+			if err != nil {
+				return nil, err
+			}
+			markTokens -= tokensPerReply
+		}
+	}
+
+	if markTokens != 0 {
+		// the oldest kept turn starts at the first message that is not a system message.
+		at := 0
+		for at < len(kept) && kept[at].Role == openai.ChatMessageRoleSystem {
+			at++
+		}
+		marked := make([]openai.ChatCompletionMessage, 0, len(kept)+1)
+		marked = append(marked, kept[:at]...)
+		marked = append(marked, mark)
+		kept = append(marked, kept[at:]...)
+	}
+
+	promptTokens += markTokens
+	return &FitResult{
+		Messages:     kept,
+		Dropped:      dropped,
+		PromptTokens: promptTokens,
+		MaxTokens:    min(limit.MaxOutput, limit.ContextWindow-promptTokens),
+	}, nil
+}
+
+// oldestTurn returns the range of the oldest non-system turn before the latest user turn, a turn starts
+// with a user message and ends before the next one, so the tool results are dropped with their calls.
+// The system messages in the range are kept.
+func oldestTurn(messages []openai.ChatCompletionMessage) (int, int, bool) {
+	latest := len(messages) - 1
+	for idx := len(messages) - 1; idx >= 0; idx-- {
+		if messages[idx].Role == openai.ChatMessageRoleUser {
+			latest = idx
+			break
+		}
+	}
+
+	start := -1
+	for idx := 0; idx < latest; idx++ {
+		if messages[idx].Role != openai.ChatMessageRoleSystem {
+			start = idx
+			break
+		}
+	}
+	if start < 0 {
+		return 0, 0, false
+	}
+
+	end := start + 1
+	for end < latest && messages[end].Role != openai.ChatMessageRoleUser {
+		end++
+	}
+	return start, end, true
+}
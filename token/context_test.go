@@ -0,0 +1,119 @@
+package token
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestFitMessages(t *testing.T) {
+	if err := RegisterModelLimit("test-small", ModelLimit{ContextWindow: 60, MaxOutput: 20}); err != nil {
+		t.Fatal(err)
+	}
+
+	long := strings.Repeat("hello ", 10)
+	messages := []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleSystem, Content: "hello"},
+		{Role: openai.ChatMessageRoleUser, Content: long},
+		{Role: openai.ChatMessageRoleAssistant, Content: long},
+		{Role: openai.ChatMessageRoleUser, Content: "hello"},
+		{Role: openai.ChatMessageRoleAssistant, Content: "hello"},
+		{Role: openai.ChatMessageRoleUser, Content: "hello hello"},
+	}
+
+	res, err := FitMessages(messages, "test-small", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Dropped != 2 || len(res.Messages) != 4 {
+		t.Fatalf("unexpected messages: %+v", res.Messages)
+	}
+	if res.Messages[0].Role != openai.ChatMessageRoleSystem || res.Messages[3].Content != "hello hello" {
+		t.Errorf("unexpected messages: %+v", res.Messages)
+	}
+	if res.PromptTokens > 50 || res.MaxTokens != min(20, 60-res.PromptTokens) {
+		t.Errorf("unexpected result: %d prompt tokens, %d max tokens", res.PromptTokens, res.MaxTokens)
+	}
+
+	_, err = FitMessages([]openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: strings.Repeat(long, 3)}}, "test-small", 10)
+	if !errors.Is(err, ErrContextLengthExceeded) {
+		t.Errorf("expected ErrContextLengthExceeded, got %v", err)
+	}
+}
+
+func TestClampMaxTokens(t *testing.T) {
+	tests := []struct {
+		model     string
+		maxTokens int
+		want      int
+	}{
+		{"anthropic.claude-3-5-sonnet-20240620-v1:0", 0, 8192},
+		{"anthropic.claude-3-haiku-20240307-v1:0", 100000, 4096},
+		{"claude-3-opus-20240229", 1000, 1000},
+		{"unknown", 0, DefaultMaxOutputTokens},
+		{"gpt-4-0613", 0, 4096},
+		{"gpt-4-32k", 8192, 4096},
+		// the max output fills the window, half of it is left for the prompt.
+		{"gpt-3.5-turbo-instruct", 0, 2048},
+		{"gpt-3.5-turbo-instruct", 3000, 3000},
+	}
+	for _, tt := range tests {
+		if got := ClampMaxTokens(tt.model, tt.maxTokens); got != tt.want {
+			t.Errorf("%s: got %d, want %d", tt.model, got, tt.want)
+		}
+	}
+}
+
+func TestFitMessagesDefaultReserve(t *testing.T) {
+	messages := []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "hello"}}
+	for _, model := range []string{"gpt-4", "gpt-4-32k", "gpt-3.5-turbo-0613", "gpt-3.5-turbo-instruct"} {
+		res, err := FitMessages(messages, model, 0)
+		if err != nil {
+			t.Errorf("%s: %v", model, err)
+			continue
+		}
+		limit, _ := GetModelLimit(model)
+		if res.Dropped != 0 || res.MaxTokens != min(limit.MaxOutput, limit.ContextWindow-res.PromptTokens) {
+			t.Errorf("%s: unexpected result: %+v", model, res)
+		}
+	}
+}
+
+func TestFitMessagesSummaryMark(t *testing.T) {
+	if err := RegisterModelLimit("test-small", ModelLimit{ContextWindow: 60, MaxOutput: 20}); err != nil {
+		t.Fatal(err)
+	}
+
+	long := strings.Repeat("hello ", 10)
+	messages := []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleSystem, Content: "hello"},
+		{Role: openai.ChatMessageRoleUser, Content: long},
+		{Role: openai.ChatMessageRoleAssistant, Content: long},
+		{Role: openai.ChatMessageRoleUser, Content: "hello"},
+		{Role: openai.ChatMessageRoleAssistant, Content: "hello"},
+		{Role: openai.ChatMessageRoleUser, Content: "hello hello"},
+	}
+
+	res, err := FitMessagesWith(messages, "test-small", FitOptions{ReserveOutput: 10, SummaryMark: "%d"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the mark takes the room of one more turn than TestFitMessages drops.
+	if res.Dropped != 4 || len(res.Messages) != 3 {
+		t.Fatalf("unexpected messages: %+v", res.Messages)
+	}
+	if mark := res.Messages[1]; mark.Role != openai.ChatMessageRoleSystem || mark.Content != "4" {
+		t.Errorf("unexpected mark: %+v", mark)
+	}
+
+	// the tokens subtracted while dropping match a recount of the kept messages.
+	want, err := CalculateMessage(res.Messages, "test-small")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.PromptTokens != want || res.PromptTokens > 50 {
+		t.Errorf("got %d prompt tokens, want %d", res.PromptTokens, want)
+	}
+}
@@ -19,121 +19,33 @@ package token
 import (
 	"encoding/json"
 	"fmt"
-	"image"
-	"math"
 	"strings"
 	"unicode/utf8"
 
-	"github.com/bytemind-io/corekit"
-
-	"github.com/pkoukk/tiktoken-go"
 	"github.com/sashabaranov/go-openai"
 )
 
-var (
-	tokenMap      = map[string]*tiktoken.Tiktoken{}
-	defaultToken  *tiktoken.Tiktoken
-	cl100kToken   *tiktoken.Tiktoken
-	o200kToken    *tiktoken.Tiktoken
-	p50kToken     *tiktoken.Tiktoken
-	p50kEditToken *tiktoken.Tiktoken
-	r50kToken     *tiktoken.Tiktoken
-)
-
-func init() {
-	token, err := tiktoken.GetEncoding(tiktoken.MODEL_CL100K_BASE)
-	if err != nil {
-		panic(err)
-	}
-	cl100kToken = token
-	defaultToken = cl100kToken
-
-	token, err = tiktoken.GetEncoding(tiktoken.MODEL_O200K_BASE)
-	if err != nil {
-		panic(err)
-	}
-	o200kToken = token
-
-	token, err = tiktoken.GetEncoding(tiktoken.MODEL_P50K_BASE)
-	if err != nil {
-		panic(err)
-	}
-	p50kToken = token
-
-	token, err = tiktoken.GetEncoding(tiktoken.MODEL_P50K_EDIT)
-	if err != nil {
-		panic(err)
-	}
-	p50kEditToken = token
-
-	token, err = tiktoken.GetEncoding(tiktoken.MODEL_R50K_BASE)
-	if err != nil {
-		panic(err)
-	}
-	r50kToken = token
-
-	//预设的模型编码
-	for model, encoding := range tiktoken.MODEL_TO_ENCODING {
-		switch encoding {
-		case tiktoken.MODEL_CL100K_BASE:
-			tokenMap[model] = cl100kToken
-		case tiktoken.MODEL_O200K_BASE:
-			tokenMap[model] = o200kToken
-		case tiktoken.MODEL_P50K_BASE:
-			tokenMap[model] = p50kToken
-		case tiktoken.MODEL_P50K_EDIT:
-			tokenMap[model] = p50kEditToken
-		case tiktoken.MODEL_R50K_BASE:
-			tokenMap[model] = r50kToken
-		default:
-			tokenMap[model] = defaultToken
-		}
-	}
-
-	//自定义的模型编码
-	for model, encoding := range ModelToEncoding {
-		switch encoding {
-		case tiktoken.MODEL_CL100K_BASE:
-			tokenMap[model] = cl100kToken
-		case tiktoken.MODEL_O200K_BASE:
-			tokenMap[model] = o200kToken
-		case tiktoken.MODEL_P50K_BASE:
-			tokenMap[model] = p50kToken
-		case tiktoken.MODEL_P50K_EDIT:
-			tokenMap[model] = p50kEditToken
-		case tiktoken.MODEL_R50K_BASE:
-			tokenMap[model] = r50kToken
-		default:
-			tokenMap[model] = cl100kToken
+// getTokenEncoder returns the tokenizer for the given model, it is loaded on the first use.
+// An unknown model with estimator weights gets an estimating tokenizer, see RegisterEstimatorWeights.
+func getTokenEncoder(model string) (Tokenizer, error) {
+	res := defaultRegistry.Resolve(model)
+	if res.Rule == RuleDefault {
+		if weights, ok := estimatorWeights(res.Target); ok {
+			return &estimateTokenizer{weights: weights}, nil
 		}
 	}
-}
-
-// getModelDefaultTokenEncoder returns the default token encoder for the given model.
-func getModelDefaultTokenEncoder(model string) *tiktoken.Tiktoken {
-	if strings.HasPrefix(model, "gpt-4o") {
-		return o200kToken
+	tokenEncoder, err := defaultRegistry.tokenizer(res.Encoding)
+	if err != nil {
+		return nil, fmt.Errorf("load tokenizer of %s: %w", model, err)
 	}
-	return defaultToken
+	return tokenEncoder, nil
 }
 
-// getTokenEncoder returns the token encoder for the given model.
-func getTokenEncoder(model string) *tiktoken.Tiktoken {
-	tokenEncoder, ok := tokenMap[model]
-	if !ok {
-		tokenEncoder, err := tiktoken.EncodingForModel(model)
-		if err != nil {
-			tokenEncoder = getModelDefaultTokenEncoder(model)
-			return tokenEncoder
-		}
-		tokenMap[model] = tokenEncoder
-		return tokenEncoder
-	}
-
-	return tokenEncoder
-}
+// tokensPerReply are the tokens priming every reply with <|im_start|>assistant<|im_sep|>.
+const tokensPerReply = 3
 
 // CalculateRequestToken calculates the chat token for the given model.[请求相关]
+// The json_schema of structured outputs is not part of go-openai, see CalculateRawRequestToken.
 func CalculateRequestToken(in *openai.ChatCompletionRequest, model string) (int, error) {
 	if model == "" {
 		model = in.Model
@@ -146,33 +58,77 @@ func CalculateRequestToken(in *openai.ChatCompletionRequest, model string) (int,
 	}
 	tkm += msgTokens
 
-	if in.Tools != nil {
-		toolsData, _ := json.Marshal(in.Tools)
-		var openaiTools []openai.Tool
-		err := json.Unmarshal(toolsData, &openaiTools)
+	tools := in.Tools
+	if len(tools) == 0 && len(in.Functions) != 0 {
+		tools = functionsToTools(in.Functions)
+	}
+	if len(tools) != 0 {
+		toolTokens, err := CalculateToolsToken(tools, model)
 		if err != nil {
 			return 0, fmt.Errorf("count_tools_token_fail: %s", err)
 		}
-		countStr := ""
-		for _, tool := range openaiTools {
-			countStr = tool.Function.Name
-			if tool.Function.Description != "" {
-				countStr += tool.Function.Description
-			}
-			if tool.Function.Parameters != nil {
-				countStr += fmt.Sprintf("%v", tool.Function.Parameters)
+		tkm += toolTokens
+
+		// the tools are merged into the system message if there is one, which is ended by a newline.
+		for _, message := range in.Messages {
+			if message.Role == openai.ChatMessageRoleSystem {
+				tkm -= tokensToolsWithSystem
+				if message.Content != "" && !strings.HasSuffix(message.Content, "\n") {
+					padded, err := CounterText(message.Content+"\n", model)
+					if err != nil {
+						return 0, err
+					}
+					unpadded, err := CounterText(message.Content, model)
+					if err != nil {
+						return 0, err
+					}
+					tkm += padded - unpadded
+				}
+				break
 			}
 		}
-		toolTokens, err := CalculateTextToken(countStr, model)
-		if err != nil {
-			return 0, err
-		}
-		tkm += 8
-		tkm += toolTokens
 	}
+
+	choice := in.ToolChoice
+	if choice == nil {
+		choice = in.FunctionCall
+	}
+	choiceTokens, err := CalculateToolChoiceToken(choice, model)
+	if err != nil {
+		return 0, err
+	}
+	tkm += choiceTokens
 	return tkm, nil
 }
 
+// CalculateRawRequestToken calculates the chat token of a raw request body for the given model,
+// unlike CalculateRequestToken it counts the json_schema of response_format.
+func CalculateRawRequestToken(data []byte, model string) (int, error) {
+	var in openai.ChatCompletionRequest
+	if err := json.Unmarshal(data, &in); err != nil {
+		return 0, fmt.Errorf("invalid chat request: %w", err)
+	}
+	var raw struct {
+		ResponseFormat *ResponseFormat `json:"response_format"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return 0, fmt.Errorf("invalid response_format: %w", err)
+	}
+	if model == "" {
+		model = in.Model
+	}
+
+	tkm, err := CalculateRequestToken(&in, model)
+	if err != nil {
+		return 0, err
+	}
+	formatTokens, err := CalculateResponseFormatToken(raw.ResponseFormat, model)
+	if err != nil {
+		return 0, fmt.Errorf("count_response_format_token_fail: %s", err)
+	}
+	return tkm + formatTokens, nil
+}
+
 // CalculateStreamResponseToken calculates the chat token for the given model.
 func CalculateStreamResponseToken(out *openai.ChatCompletionStreamResponse, model string) (int, error) {
 	if model == "" {
@@ -186,8 +142,12 @@ func CalculateStreamResponseToken(out *openai.ChatCompletionStreamResponse, mode
 	return CalculateStreamMessage(messages, model)
 }
 
+// CalculateStreamMessage calculates the token of the given stream deltas.
 func CalculateStreamMessage(messages []openai.ChatCompletionStreamChoiceDelta, model string) (int, error) {
-	tokenEncoder := getTokenEncoder(model)
+	tokenEncoder, err := getTokenEncoder(model)
+	if err != nil {
+		return 0, err
+	}
 
 	tokenNum := 0
 	for _, message := range messages {
@@ -197,11 +157,35 @@ func CalculateStreamMessage(messages []openai.ChatCompletionStreamChoiceDelta, m
 				tokenNum += getTokenNum(tokenEncoder, stringContent)
 			}
 		}
+		if message.FunctionCall != nil {
+			tokenNum += getTokenNum(tokenEncoder, message.FunctionCall.Name)
+			tokenNum += getTokenNum(tokenEncoder, message.FunctionCall.Arguments)
+		}
+		for _, call := range message.ToolCalls {
+			tokenNum += getTokenNum(tokenEncoder, call.Function.Name)
+			tokenNum += getTokenNum(tokenEncoder, call.Function.Arguments)
+		}
 	}
 
 	return tokenNum, nil
 }
 
+// countCompletionMessage counts the tokens generated for a completion message.
+func countCompletionMessage(tokenEncoder Tokenizer, message openai.ChatCompletionMessage) int {
+	tokenNum := getTokenNum(tokenEncoder, message.Content)
+	if message.FunctionCall != nil {
+		tokenNum += tokensPerToolCall
+		tokenNum += getTokenNum(tokenEncoder, message.FunctionCall.Name)
+		tokenNum += getTokenNum(tokenEncoder, message.FunctionCall.Arguments)
+	}
+	for _, call := range message.ToolCalls {
+		tokenNum += tokensPerToolCall
+		tokenNum += getTokenNum(tokenEncoder, call.Function.Name)
+		tokenNum += getTokenNum(tokenEncoder, call.Function.Arguments)
+	}
+	return tokenNum
+}
+
 // CalculateResponseToken calculates the chat token for the given model.
 func CalculateResponseToken(out *openai.ChatCompletionResponse, model string) (int, error) {
 	if model == "" {
@@ -215,11 +199,31 @@ func CalculateResponseToken(out *openai.ChatCompletionResponse, model string) (i
 	return CalculateMessage(messages, model)
 }
 
-// CalculateteMessage calculates the chat token for the given model.[消息Token计算]
+// CalculateMessage calculates the chat token for the given model.[消息Token计算]
+// Assistant tool calls and tool results are counted the way they are rendered into the prompt,
+// see countPromptToolCalls. The name of a message is counted even if its content is empty.
 // https://github.com/openai/openai-cookbook/blob/main/examples/How_to_count_tokens_with_tiktoken.ipynb
 // https://github.com/pkoukk/tiktoken-go/issues/6
 func CalculateMessage(messages []openai.ChatCompletionMessage, model string) (int, error) {
-	tokenEncoder := getTokenEncoder(model)
+	tokens, err := calculateMessageTokens(messages, model)
+	if err != nil {
+		return 0, err
+	}
+
+	tokenNum := tokensPerReply
+	for _, num := range tokens {
+		tokenNum += num
+	}
+	return tokenNum, nil
+}
+
+// calculateMessageTokens calculates the token of every message, without the tokens priming the reply.
+// A tool result is attributed to its call, so the counts only hold while both are kept.
+func calculateMessageTokens(messages []openai.ChatCompletionMessage, model string) ([]int, error) {
+	tokenEncoder, err := getTokenEncoder(model)
+	if err != nil {
+		return nil, err
+	}
 	var (
 		tokensPerMessage int
 		tokensPerName    int
@@ -233,109 +237,53 @@ func CalculateMessage(messages []openai.ChatCompletionMessage, model string) (in
 		tokensPerName = 1
 	}
 
-	tokenNum := 0
-	for _, message := range messages {
-		tokenNum += tokensPerMessage
-		tokenNum += getTokenNum(tokenEncoder, message.Role)
-		if len(message.Content) > 0 {
-			if message.Content != "" {
-				stringContent := message.Content
-				tokenNum += getTokenNum(tokenEncoder, stringContent)
-				if message.Name != "" {
-					tokenNum += tokensPerName
-					tokenNum += getTokenNum(tokenEncoder, message.Name)
-				}
-			} else {
-				arrayContent := message.MultiContent
-				for _, m := range arrayContent {
-					if m.Type == openai.ChatMessagePartTypeImageURL {
-						imageTokenNum, err := CalculateImageToken(m.ImageURL, model)
-						if err != nil {
-							return 0, err
-						}
-						tokenNum += imageTokenNum
-					} else {
-						tokenNum += getTokenNum(tokenEncoder, m.Text)
+	tokens := make([]int, len(messages))
+	toolNames := make(map[string]string)
+	contentTokens := countContents(tokenEncoder, messages)
+	for idx, message := range messages {
+		tokenNum := tokensPerMessage
+		name := message.Name
+		if name == "" {
+			name = toolNames[message.ToolCallID]
+		}
+		if (message.Role == openai.ChatMessageRoleTool || message.Role == openai.ChatMessageRoleFunction) && name != "" {
+			// tool results are rendered with the called function as the author, a result
+			// whose call is unknown keeps its role.
+			tokenNum += getTokenNum(tokenEncoder, toolRecipient(name))
+		} else {
+			tokenNum += getTokenNum(tokenEncoder, message.Role)
+			if message.Name != "" {
+				tokenNum += tokensPerName
+				tokenNum += getTokenNum(tokenEncoder, message.Name)
+			}
+		}
+
+		tokenNum += contentTokens[idx]
+		if message.Content == "" {
+			for _, m := range message.MultiContent {
+				if m.Type == openai.ChatMessagePartTypeImageURL {
+					imageTokenNum, err := CalculateImageToken(m.ImageURL, model)
+					if err != nil {
+						return nil, err
 					}
+					tokenNum += imageTokenNum
 				}
 			}
 		}
-	}
-
-	tokenNum += 3
-	return tokenNum, nil
-}
-
-// CalculateImageBytesToken calculates the chat token for the given image bytes.[计算图片Token]
-func CalculateImageBytesToken(imageBytes []byte, model string) (int, error) {
-	if model == "glm-4v" {
-		return 1047, nil
-	}
-
-	config, _, err := corekit.GetImageConfig(imageBytes)
-	if err != nil {
-		return 0, err
-	}
-
-	shortSide := config.Width
-	otherSide := config.Height
-	scale := 1.0
-	if config.Height < shortSide {
-		shortSide = config.Height
-		otherSide = config.Width
-	}
-	if shortSide > 768 {
-		scale = float64(shortSide) / 768
-		shortSide = 768
-	}
-	otherSide = int(math.Ceil(float64(otherSide) / scale))
-	tiles := (shortSide + 511) / 512 * ((otherSide + 511) / 512)
-	return tiles*170 + 85, nil
-}
-
-// CalculateImageToken gets the token number for the given image URL.[计算图片Token]
-func CalculateImageToken(imageUrl *openai.ChatMessageImageURL, model string) (int, error) {
-	if model == "glm-4v" {
-		return 1047, nil
-	}
-	if imageUrl.Detail == "low" {
-		return 85, nil
-	}
-	if imageUrl.Detail == "auto" || imageUrl.Detail == "" {
-		imageUrl.Detail = "high"
-	}
 
-	var (
-		config image.Config
-		err    error
-	)
-	if strings.HasPrefix(imageUrl.URL, "http") {
-		config, _, err = corekit.DecodeUrlImage(imageUrl.URL)
-	} else {
-		config, _, _, err = corekit.DecodeBase64Image(imageUrl.URL)
-	}
-	if err != nil {
-		return 0, err
-	}
-
-	if config.Width == 0 || config.Height == 0 {
-		return 0, fmt.Errorf("fail to decode image config: %s", imageUrl.URL)
-	}
-
-	shortSide := config.Width
-	otherSide := config.Height
-	scale := 1.0
-	if config.Height < shortSide {
-		shortSide = config.Height
-		otherSide = config.Width
-	}
-	if shortSide > 768 {
-		scale = float64(shortSide) / 768
-		shortSide = 768
+		hasContent := message.Content != "" || len(message.MultiContent) != 0
+		if message.FunctionCall != nil {
+			tokenNum += countPromptToolCall(tokenEncoder, toolRecipient(message.FunctionCall.Name), message.FunctionCall.Arguments, hasContent, tokensPerMessage)
+		}
+		if len(message.ToolCalls) != 0 {
+			for _, call := range message.ToolCalls {
+				toolNames[call.ID] = call.Function.Name
+			}
+			tokenNum += countPromptToolCalls(tokenEncoder, message.ToolCalls, hasContent, tokensPerMessage)
+		}
+		tokens[idx] = tokenNum
 	}
-	otherSide = int(math.Ceil(float64(otherSide) / scale))
-	tiles := (shortSide + 511) / 512 * ((otherSide + 511) / 512)
-	return tiles*170 + 85, nil
+	return tokens, nil
 }
 
 // CalculateTextToken calculates the chat token for the given model.[文本Token计算]
@@ -353,6 +301,58 @@ func CalculateTextToken(in interface{}, model string) (int, error) {
 	return CalculateTextToken(fmt.Sprintf("%v", in), model)
 }
 
+// CalculateEmbeddingToken calculates the token of an embedding input, a string, []string, a pre-tokenized
+// []int or [][]int, or the Input of an openai.EmbeddingRequest.[向量Token计算]
+func CalculateEmbeddingToken(input any, model string) (int, error) {
+	switch v := input.(type) {
+	case openai.EmbeddingRequest:
+		return CalculateEmbeddingToken(v.Input, model)
+	case *openai.EmbeddingRequest:
+		return CalculateEmbeddingToken(v.Input, model)
+	case openai.EmbeddingRequestStrings:
+		return CalculateEmbeddingToken(v.Input, model)
+	case openai.EmbeddingRequestTokens:
+		return CalculateEmbeddingToken(v.Input, model)
+	case string:
+		return CounterText(v, model)
+	case []string:
+		tokenNum := 0
+		for _, text := range v {
+			num, err := CounterText(text, model)
+			if err != nil {
+				return 0, err
+			}
+			tokenNum += num
+		}
+		return tokenNum, nil
+	case []int:
+		return len(v), nil
+	case [][]int:
+		tokenNum := 0
+		for _, tokens := range v {
+			tokenNum += len(tokens)
+		}
+		return tokenNum, nil
+	case []any:
+		// the input decoded from JSON.
+		if len(v) != 0 {
+			if _, ok := v[0].(float64); ok {
+				return len(v), nil
+			}
+		}
+		tokenNum := 0
+		for _, item := range v {
+			num, err := CalculateEmbeddingToken(item, model)
+			if err != nil {
+				return 0, err
+			}
+			tokenNum += num
+		}
+		return tokenNum, nil
+	}
+	return 0, fmt.Errorf("unsupported embedding input: %T", input)
+}
+
 // CalculateAudioToken calculates the chat token for the given model.[音频Token计算]
 func CalculateAudioToken(text, model string) (int, error) {
 	if strings.HasPrefix(model, "tts") {
@@ -364,11 +364,20 @@ func CalculateAudioToken(text, model string) (int, error) {
 
 // CounterText counts the number of tokens in the given text.[计数相关]
 func CounterText(text string, model string) (int, error) {
-	tokenEncoder := getTokenEncoder(model)
+	tokenEncoder, err := getTokenEncoder(model)
+	if err != nil {
+		return 0, err
+	}
 	return getTokenNum(tokenEncoder, text), nil
 }
 
-// getTokenNum returns the number of tokens in the given text.
-func getTokenNum(tokenEncoder *tiktoken.Tiktoken, text string) int {
-	return len(tokenEncoder.Encode(text, nil, nil))
+// getTokenNum returns the number of tokens in the given text, the long texts are cached if enabled.
+func getTokenNum(tokenEncoder Tokenizer, text string) int {
+	if estimator, ok := tokenEncoder.(*estimateTokenizer); ok {
+		return estimator.count(text)
+	}
+	if c := cache.Load(); c != nil && len(text) >= minCachedTextLen {
+		return c.count(tokenEncoder, text)
+	}
+	return len(tokenEncoder.Encode(text))
 }
@@ -0,0 +1,100 @@
+package token
+
+import (
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestCalculateMessageTools(t *testing.T) {
+	tokenEncoder, err := getTokenEncoder("gpt-4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := func(text string) int {
+		return getTokenNum(tokenEncoder, text)
+	}
+
+	const args = `{"q":"hello"}`
+	call := openai.ToolCall{ID: "call_1", Type: openai.ToolTypeFunction, Function: openai.FunctionCall{Name: "lookup", Arguments: args}}
+	assistant := openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, ToolCalls: []openai.ToolCall{call}}
+	// <|im_start|>assistant to=functions.lookup<|im_sep|>{"q":"hello"}<|im_end|>
+	callTokens := 3 + n("assistant") + n(" to=functions.lookup") + n(args)
+	// <|im_start|>functions.lookup<|im_sep|>hello<|im_end|>
+	resultTokens := 3 + n("functions.lookup") + n("hello")
+
+	tests := []struct {
+		name     string
+		messages []openai.ChatCompletionMessage
+		want     int
+	}{
+		{
+			name:     "name without content",
+			messages: []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Name: "bob"}},
+			want:     3 + n("user") + 1 + n("bob"),
+		},
+		{
+			name:     "tool call",
+			messages: []openai.ChatCompletionMessage{assistant},
+			want:     callTokens,
+		},
+		{
+			name: "tool call with content",
+			messages: []openai.ChatCompletionMessage{
+				{Role: openai.ChatMessageRoleAssistant, Content: "hello", ToolCalls: []openai.ToolCall{call}},
+			},
+			// the content is a message of its own before the call.
+			want: 3 + n("assistant") + n("hello") + callTokens,
+		},
+		{
+			name: "parallel tool calls",
+			messages: []openai.ChatCompletionMessage{
+				{Role: openai.ChatMessageRoleAssistant, ToolCalls: []openai.ToolCall{call, {ID: "call_2", Function: openai.FunctionCall{Name: "search", Arguments: "{}"}}}},
+			},
+			want: 3 + n("assistant") + n(" to=multi_tool_use.parallel") +
+				n(`{"tool_uses":[{"recipient_name":"functions.lookup","parameters":{"q":"hello"}},{"recipient_name":"functions.search","parameters":{}}]}`),
+		},
+		{
+			name: "tool result with name",
+			messages: []openai.ChatCompletionMessage{
+				{Role: openai.ChatMessageRoleTool, Name: "lookup", ToolCallID: "call_1", Content: "hello"},
+			},
+			want: resultTokens,
+		},
+		{
+			name: "tool result named by its call",
+			messages: []openai.ChatCompletionMessage{
+				assistant,
+				{Role: openai.ChatMessageRoleTool, ToolCallID: "call_1", Content: "hello"},
+			},
+			want: callTokens + resultTokens,
+		},
+		{
+			name: "tool result of an unknown call",
+			messages: []openai.ChatCompletionMessage{
+				{Role: openai.ChatMessageRoleTool, ToolCallID: "call_9", Content: "hello"},
+			},
+			want: 3 + n("tool") + n("hello"),
+		},
+		{
+			name: "function call",
+			messages: []openai.ChatCompletionMessage{
+				{Role: openai.ChatMessageRoleAssistant, FunctionCall: &openai.FunctionCall{Name: "lookup", Arguments: args}},
+				{Role: openai.ChatMessageRoleFunction, Name: "lookup", Content: "hello"},
+			},
+			want: callTokens + resultTokens,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := CalculateMessage(tt.messages, "gpt-4")
+			if err != nil {
+				t.Fatal(err)
+			}
+			// every reply is primed with <|im_start|>assistant<|im_sep|>.
+			if want := tt.want + 3; got != want {
+				t.Errorf("got %d tokens, want %d", got, want)
+			}
+		})
+	}
+}
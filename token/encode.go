@@ -0,0 +1,106 @@
+/*
+Copyright 2024 The corego Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package token
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// MinLogitBias is the min bias of a token, it bans the token.
+	MinLogitBias = -100
+	// MaxLogitBias is the max bias of a token, it makes the token exclusive.
+	MaxLogitBias = 100
+)
+
+// Encode encodes the text with the tokenizer of the model.[编码]
+func Encode(text, model string) ([]int, error) {
+	tokenEncoder, err := exactTokenEncoder(model)
+	if err != nil {
+		return nil, err
+	}
+	return tokenEncoder.Encode(text), nil
+}
+
+// Decode decodes the token ids with the tokenizer of the model.[解码]
+func Decode(ids []int, model string) (string, error) {
+	tokenEncoder, err := exactTokenEncoder(model)
+	if err != nil {
+		return "", err
+	}
+	return tokenEncoder.Decode(ids), nil
+}
+
+// TruncateToTokens returns the longest prefix of the text within n tokens of the model,
+// a rune split by the token boundary is dropped.[按Token截断]
+func TruncateToTokens(text, model string, n int) (string, error) {
+	if n < 0 {
+		return "", fmt.Errorf("invalid token limit: %d", n)
+	}
+	tokenEncoder, err := exactTokenEncoder(model)
+	if err != nil {
+		return "", err
+	}
+
+	ids := tokenEncoder.Encode(text)
+	if len(ids) <= n {
+		return text, nil
+	}
+
+	prefix := tokenEncoder.Decode(ids[:n])
+	for len(prefix) > 0 {
+		r, size := utf8.DecodeLastRuneInString(prefix)
+		if r != utf8.RuneError || size != 1 {
+			break
+		}
+		prefix = prefix[:len(prefix)-size]
+	}
+	return prefix, nil
+}
+
+// LogitBiasFor builds the logit_bias of openai.ChatCompletionRequest that applies bias to the tokens
+// of the words, the words are encoded both alone and after a space.[构建LogitBias]
+func LogitBiasFor(words []string, model string, bias int) (map[string]int, error) {
+	if bias < MinLogitBias || bias > MaxLogitBias {
+		return nil, fmt.Errorf("logit bias must be in [%d, %d]: %d", MinLogitBias, MaxLogitBias, bias)
+	}
+	tokenEncoder, err := exactTokenEncoder(model)
+	if err != nil {
+		return nil, err
+	}
+
+	logitBias := make(map[string]int)
+	for _, word := range words {
+		word = strings.TrimSpace(word)
+		if word == "" {
+			continue
+		}
+		for _, text := range []string{word, " " + word} {
+			for _, id := range tokenEncoder.Encode(text) {
+				// a bare space would bias every word.
+				if strings.TrimSpace(tokenEncoder.Decode([]int{id})) == "" {
+					continue
+				}
+				logitBias[strconv.Itoa(id)] = bias
+			}
+		}
+	}
+	return logitBias, nil
+}
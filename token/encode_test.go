@@ -0,0 +1,61 @@
+package token
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	ids, err := Encode("hello hello", "gpt-4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ids, []int{259, 32, 259}) {
+		t.Errorf("unexpected ids: %v", ids)
+	}
+
+	text, err := Decode(ids, "gpt-4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text != "hello hello" {
+		t.Errorf("unexpected text: %q", text)
+	}
+}
+
+func TestTruncateToTokens(t *testing.T) {
+	tests := []struct {
+		text string
+		n    int
+		want string
+	}{
+		{"hello hello", 2, "hello "},
+		{"hello hello", 5, "hello hello"},
+		// 你 is three byte tokens.
+		{"hello你", 3, "hello"},
+		{"hello你", 0, ""},
+	}
+	for _, tt := range tests {
+		got, err := TruncateToTokens(tt.text, "gpt-4", tt.n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("%q to %d tokens: got %q, want %q", tt.text, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestLogitBiasFor(t *testing.T) {
+	bias, err := LogitBiasFor([]string{"hello", " "}, "gpt-4", MinLogitBias)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(bias, map[string]int{"259": -100}) {
+		t.Errorf("unexpected bias: %v", bias)
+	}
+
+	if _, err := LogitBiasFor([]string{"hello"}, "gpt-4", 101); err == nil {
+		t.Error("expected an error for the bias")
+	}
+}
@@ -2,7 +2,10 @@ package token
 
 import "github.com/pkoukk/tiktoken-go"
 
-// ModelToEncoding extend model encoding setting
+// ModelToEncoding extend model encoding setting, it is read once when the package is initialized
+// and later changes are ignored.
+//
+// Deprecated: use RegisterModelEncoding, which also works at runtime.
 var ModelToEncoding = map[string]string{
 	"ep-20240603062111-s4snw": tiktoken.MODEL_O200K_BASE,
 	"doubao-pro-32k-240515":   tiktoken.MODEL_O200K_BASE,
@@ -0,0 +1,232 @@
+/*
+Copyright 2024 The corego Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package token
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// ErrEstimatedModel is returned by Encode, Decode, TruncateToTokens and LogitBiasFor for a model
+// whose tokens are only estimated.
+var ErrEstimatedModel = errors.New("the tokens of the model are estimated, it has no tokenizer")
+
+// ScriptWeights are the tokens per unit of every script of a text, used to estimate the tokens
+// of a model without a known tokenizer.
+type ScriptWeights struct {
+	CJK         float64 `json:"cjk"          yaml:"cjk"`         // 每个中日韩字符
+	LatinWord   float64 `json:"latin_word"   yaml:"latin_word"`  // 每个拉丁字母单词
+	Digit       float64 `json:"digit"        yaml:"digit"`       // 每个数字
+	Punctuation float64 `json:"punctuation"  yaml:"punctuation"` // 每个标点或符号
+	Other       float64 `json:"other"        yaml:"other"`       // 其他文字的每个字符
+}
+
+// Validate validates the ScriptWeights.
+func (w ScriptWeights) Validate() error {
+	for _, weight := range []float64{w.CJK, w.LatinWord, w.Digit, w.Punctuation, w.Other} {
+		if weight < 0 || math.IsNaN(weight) || math.IsInf(weight, 0) {
+			return fmt.Errorf("invalid script weights: %+v", w)
+		}
+	}
+	return nil
+}
+
+// DefaultScriptWeights are the weights of the unknown models outside the calibrated families.
+var DefaultScriptWeights = ScriptWeights{CJK: 1, LatinWord: 1.3, Digit: 0.5, Punctuation: 1, Other: 0.5}
+
+// familyScriptWeights are rough calibrations of the model families by their published
+// characters per token.
+var familyScriptWeights = map[string]ScriptWeights{
+	"qwen":     {CJK: 0.7, LatinWord: 1.3, Digit: 1, Punctuation: 1, Other: 0.5},
+	"glm":      {CJK: 0.7, LatinWord: 1.3, Digit: 0.5, Punctuation: 1, Other: 0.5},
+	"chatglm":  {CJK: 0.7, LatinWord: 1.3, Digit: 0.5, Punctuation: 1, Other: 0.5},
+	"deepseek": {CJK: 0.6, LatinWord: 1.3, Digit: 1, Punctuation: 1, Other: 0.5},
+	"moonshot": {CJK: 0.6, LatinWord: 1.3, Digit: 0.5, Punctuation: 1, Other: 0.5},
+	"ernie":    {CJK: 0.75, LatinWord: 1.3, Digit: 0.5, Punctuation: 1, Other: 0.5},
+	"baichuan": {CJK: 0.65, LatinWord: 1.3, Digit: 1, Punctuation: 1, Other: 0.5},
+	"yi-":      {CJK: 0.7, LatinWord: 1.3, Digit: 1, Punctuation: 1, Other: 0.5},
+	"doubao":   {CJK: 0.7, LatinWord: 1.3, Digit: 0.5, Punctuation: 1, Other: 0.5},
+	"hunyuan":  {CJK: 0.7, LatinWord: 1.3, Digit: 0.5, Punctuation: 1, Other: 0.5},
+}
+
+// estimatorRule is the weights of the models starting with the prefix.
+type estimatorRule struct {
+	prefix  string
+	weights ScriptWeights
+}
+
+var (
+	estimatorMu       sync.RWMutex
+	estimatorRules    []estimatorRule
+	estimatorFallback bool
+)
+
+func init() {
+	for prefix, weights := range familyScriptWeights {
+		_ = RegisterEstimatorWeights(prefix, weights)
+	}
+}
+
+// RegisterEstimatorWeights registers the weights of the models starting with the prefix, they
+// are only used for the models without an encoding, the longest matching prefix wins.[Token估算]
+func RegisterEstimatorWeights(prefix string, weights ScriptWeights) error {
+	if prefix == "" {
+		return fmt.Errorf("prefix is required")
+	}
+	if err := weights.Validate(); err != nil {
+		return err
+	}
+
+	estimatorMu.Lock()
+	defer estimatorMu.Unlock()
+	for idx, rule := range estimatorRules {
+		if rule.prefix == prefix {
+			estimatorRules[idx].weights = weights
+			return nil
+		}
+	}
+	estimatorRules = append(estimatorRules, estimatorRule{prefix: prefix, weights: weights})
+	sort.SliceStable(estimatorRules, func(i, j int) bool {
+		return len(estimatorRules[i].prefix) > len(estimatorRules[j].prefix)
+	})
+	return nil
+}
+
+// EnableEstimatorFallback estimates the unknown models outside the calibrated families with
+// DefaultScriptWeights instead of counting them with the fallback encoding.
+func EnableEstimatorFallback(enabled bool) {
+	estimatorMu.Lock()
+	defer estimatorMu.Unlock()
+	estimatorFallback = enabled
+}
+
+// estimatorWeights returns the weights of the model.
+func estimatorWeights(model string) (ScriptWeights, bool) {
+	estimatorMu.RLock()
+	defer estimatorMu.RUnlock()
+	for _, rule := range estimatorRules {
+		if strings.HasPrefix(model, rule.prefix) {
+			return rule.weights, true
+		}
+	}
+	return DefaultScriptWeights, estimatorFallback
+}
+
+// EstimateTokens estimates the tokens of the text with the weights, a non-empty text is at least 1 token.
+func EstimateTokens(text string, weights ScriptWeights) int {
+	var tokens float64
+	inWord := false
+	for _, r := range text {
+		isLatin := unicode.Is(unicode.Latin, r)
+		switch {
+		case isLatin:
+			if !inWord {
+				tokens += weights.LatinWord
+			}
+		case unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul):
+			tokens += weights.CJK
+		case unicode.IsDigit(r):
+			tokens += weights.Digit
+		case unicode.IsPunct(r) || unicode.IsSymbol(r):
+			tokens += weights.Punctuation
+		case unicode.IsSpace(r):
+		default:
+			tokens += weights.Other
+		}
+		inWord = isLatin
+	}
+	if tokens == 0 && text != "" {
+		return 1
+	}
+	return int(math.Ceil(tokens))
+}
+
+// estimateTokenizer is the Tokenizer of an estimated model, it only counts.
+type estimateTokenizer struct {
+	weights ScriptWeights
+}
+
+// Name implements the Tokenizer interface.
+func (t *estimateTokenizer) Name() string {
+	return "estimate"
+}
+
+// Encode implements the Tokenizer interface, the estimated models have no token ids.
+func (t *estimateTokenizer) Encode(string) []int {
+	return nil
+}
+
+// Decode implements the Tokenizer interface, the estimated models have no token ids.
+func (t *estimateTokenizer) Decode([]int) string {
+	return ""
+}
+
+// count estimates the tokens of the text.
+func (t *estimateTokenizer) count(text string) int {
+	return EstimateTokens(text, t.weights)
+}
+
+// TokenCount is the tokens of a text and how they were counted.
+type TokenCount struct {
+	Tokens int `json:"tokens"`
+	// Encoding is the encoding of the model, empty if Estimated.
+	Encoding string `json:"encoding,omitempty"`
+	// Estimated is true if the model has no known tokenizer and the tokens are estimated by script.
+	Estimated bool `json:"estimated"`
+}
+
+// CountTokens counts the tokens of the text like CounterText, and reports whether they are estimated.
+func CountTokens(text, model string) (TokenCount, error) {
+	tokenEncoder, err := getTokenEncoder(model)
+	if err != nil {
+		return TokenCount{}, err
+	}
+	count := TokenCount{Tokens: getTokenNum(tokenEncoder, text)}
+	if _, ok := tokenEncoder.(*estimateTokenizer); ok {
+		count.Estimated = true
+	} else {
+		count.Encoding = tokenEncoder.Name()
+	}
+	return count, nil
+}
+
+// IsEstimated reports whether the tokens of the model are estimated by script.
+func IsEstimated(model string) bool {
+	tokenEncoder, err := getTokenEncoder(model)
+	if err != nil {
+		return false
+	}
+	_, ok := tokenEncoder.(*estimateTokenizer)
+	return ok
+}
+
+// exactTokenEncoder returns the tokenizer of the model, ErrEstimatedModel if the model is estimated.
+func exactTokenEncoder(model string) (Tokenizer, error) {
+	tokenEncoder, err := getTokenEncoder(model)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := tokenEncoder.(*estimateTokenizer); ok {
+		return nil, fmt.Errorf("%w: %s", ErrEstimatedModel, model)
+	}
+	return tokenEncoder, nil
+}
@@ -0,0 +1,31 @@
+package token
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestEstimateTokens(t *testing.T) {
+	weights := ScriptWeights{CJK: 0.5, LatinWord: 1, Digit: 1, Punctuation: 1, Other: 1}
+	// 4 CJK, 2 words, 3 digits and 2 punctuations.
+	if got := EstimateTokens("你好世界, hello world 123!", weights); got != 9 {
+		t.Errorf("got %d, want 9", got)
+	}
+	if got := EstimateTokens(" ", weights); got != 1 {
+		t.Errorf("got %d for a space, want 1", got)
+	}
+
+	count, err := CountTokens("你好世界", "qwen-max-0919")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !count.Estimated || count.Tokens != 3 {
+		t.Errorf("unexpected count: %+v", count)
+	}
+	if _, err := Encode("你好世界", "qwen-max-0919"); !errors.Is(err, ErrEstimatedModel) {
+		t.Errorf("expected ErrEstimatedModel, got %v", err)
+	}
+	if IsEstimated("gpt-4") {
+		t.Error("expected gpt-4 to have a tokenizer")
+	}
+}
@@ -0,0 +1,224 @@
+/*
+Copyright 2024 The corego Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package token
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"gopkg.in/yaml.v3"
+)
+
+// Currency is an ISO 4217 currency code.
+type Currency string
+
+const (
+	// CurrencyUSD is the US dollar, the currency of the prices and the quota.
+	CurrencyUSD Currency = "USD"
+	// CurrencyCNY is the Chinese yuan.
+	CurrencyCNY Currency = "CNY"
+)
+
+// ExchangeRateProvider provides the exchange rates of the currencies.[汇率]
+type ExchangeRateProvider interface {
+	// Rate returns the amount of the currency that 1 USD is worth.
+	Rate(ctx context.Context, currency Currency) (float64, error)
+}
+
+// validateRate validates an exchange rate.
+func validateRate(currency Currency, rate float64) error {
+	if rate <= 0 || math.IsNaN(rate) || math.IsInf(rate, 0) {
+		return fmt.Errorf("invalid exchange rate of %s: %v", currency, rate)
+	}
+	return nil
+}
+
+// StaticRates are fixed exchange rates, USD is always 1.
+type StaticRates map[Currency]float64
+
+// Rate implements the ExchangeRateProvider interface.
+func (r StaticRates) Rate(_ context.Context, currency Currency) (float64, error) {
+	if currency == CurrencyUSD {
+		return 1, nil
+	}
+	rate, ok := r[currency]
+	if !ok {
+		return 0, fmt.Errorf("no exchange rate of %s", currency)
+	}
+	return rate, validateRate(currency, rate)
+}
+
+// FileRates reads the exchange rates from a .json, .yaml or .yml file of currency to rate,
+// the file is read again once it is modified.
+type FileRates struct {
+	filename string
+	mu       sync.Mutex
+	modTime  time.Time
+	rates    StaticRates
+}
+
+// NewFileRates creates a FileRates of the file, the file must be valid.
+func NewFileRates(filename string) (*FileRates, error) {
+	r := &FileRates{filename: filename}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Rate implements the ExchangeRateProvider interface.
+func (r *FileRates) Rate(ctx context.Context, currency Currency) (float64, error) {
+	if err := r.reload(); err != nil {
+		return 0, err
+	}
+
+	r.mu.Lock()
+	rates := r.rates
+	r.mu.Unlock()
+	return rates.Rate(ctx, currency)
+}
+
+// reload reads the file if it is modified.
+func (r *FileRates) reload() error {
+	info, err := os.Stat(r.filename)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.rates != nil && info.ModTime().Equal(r.modTime) {
+		return nil
+	}
+
+	data, err := os.ReadFile(r.filename)
+	if err != nil {
+		return err
+	}
+	var rates StaticRates
+	switch ext := strings.ToLower(filepath.Ext(r.filename)); ext {
+	case ".json":
+		err = json.Unmarshal(data, &rates)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &rates)
+	default:
+		err = fmt.Errorf("unsupported exchange rate file: %s", r.filename)
+	}
+	if err != nil {
+		return err
+	}
+	for currency, rate := range rates {
+		if err := validateRate(currency, rate); err != nil {
+			return err
+		}
+	}
+
+	r.rates = rates
+	r.modTime = info.ModTime()
+	return nil
+}
+
+// redisRate is a cached rate of RedisRates.
+type redisRate struct {
+	rate     float64
+	expireAt time.Time
+}
+
+// RedisRates reads the exchange rates from the fields of a redis hash, e.g. HSET exchange:rates CNY 7.1,
+// the rates are cached for ttl.
+type RedisRates struct {
+	client redis.UniversalClient
+	key    string
+	ttl    time.Duration
+	cache  sync.Map
+}
+
+// NewRedisRates creates a RedisRates of the hash key.
+func NewRedisRates(client redis.UniversalClient, key string, ttl time.Duration) *RedisRates {
+	return &RedisRates{client: client, key: key, ttl: ttl}
+}
+
+// Rate implements the ExchangeRateProvider interface.
+func (r *RedisRates) Rate(ctx context.Context, currency Currency) (float64, error) {
+	if currency == CurrencyUSD {
+		return 1, nil
+	}
+	if v, ok := r.cache.Load(currency); ok {
+		if cached := v.(redisRate); time.Now().Before(cached.expireAt) {
+			return cached.rate, nil
+		}
+	}
+
+	val, err := r.client.HGet(ctx, r.key, string(currency)).Result()
+	if err != nil {
+		return 0, fmt.Errorf("get exchange rate of %s: %w", currency, err)
+	}
+	rate, err := strconv.ParseFloat(val, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parse exchange rate of %s: %w", currency, err)
+	}
+	if err := validateRate(currency, rate); err != nil {
+		return 0, err
+	}
+	r.cache.Store(currency, redisRate{rate: rate, expireAt: time.Now().Add(r.ttl)})
+	return rate, nil
+}
+
+// exchangeRates is the provider used by the package functions.
+var exchangeRates atomic.Value
+
+func init() {
+	SetExchangeRateProvider(StaticRates{CurrencyCNY: USD2RMB})
+}
+
+// SetExchangeRateProvider sets the provider used by the package functions, USD2RMB by default.
+func SetExchangeRateProvider(provider ExchangeRateProvider) {
+	exchangeRates.Store(&provider)
+}
+
+// ExchangeRate returns the amount of the currency that 1 USD is worth.
+func ExchangeRate(ctx context.Context, currency Currency) (float64, error) {
+	return (*exchangeRates.Load().(*ExchangeRateProvider)).Rate(ctx, currency)
+}
+
+// AmountToQuota converts an amount of the currency to quota units, it returns the rate used.[金额转额度]
+func AmountToQuota(ctx context.Context, amount float64, currency Currency) (int, float64, error) {
+	rate, err := ExchangeRate(ctx, currency)
+	if err != nil {
+		return 0, 0, err
+	}
+	return CostToQuota(amount / rate), rate, nil
+}
+
+// QuotaToAmount converts quota units to an amount of the currency, it returns the rate used.[额度转金额]
+func QuotaToAmount(ctx context.Context, quota int, currency Currency) (float64, float64, error) {
+	rate, err := ExchangeRate(ctx, currency)
+	if err != nil {
+		return 0, 0, err
+	}
+	return float64(quota) / USD * rate, rate, nil
+}
@@ -0,0 +1,60 @@
+package token
+
+import (
+	"context"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileRates(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "rates.yaml")
+	if err := os.WriteFile(filename, []byte("CNY: 7.1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	rates, err := NewFileRates(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rate, err := rates.Rate(context.Background(), CurrencyCNY); err != nil || rate != 7.1 {
+		t.Errorf("got %v, %v", rate, err)
+	}
+
+	if err := os.WriteFile(filename, []byte("CNY: 7.2\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Now().Add(time.Second)
+	if err := os.Chtimes(filename, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	if rate, err := rates.Rate(context.Background(), CurrencyCNY); err != nil || rate != 7.2 {
+		t.Errorf("got %v, %v after the change", rate, err)
+	}
+	if _, err := rates.Rate(context.Background(), "EUR"); err == nil {
+		t.Error("expected an error for EUR")
+	}
+}
+
+func TestCharge(t *testing.T) {
+	defer SetExchangeRateProvider(StaticRates{CurrencyCNY: USD2RMB})
+	SetExchangeRateProvider(StaticRates{CurrencyCNY: 7})
+
+	billing, err := Charge(context.Background(), "gpt-4o", Usage{InputTokens: 1000000}, CurrencyCNY)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if billing.Currency != CurrencyCNY || billing.Rate != 7 || billing.Amount != 35 || billing.Quota != 2500 {
+		t.Errorf("unexpected billing: %+v", billing)
+	}
+
+	quota, rate, err := AmountToQuota(context.Background(), 7, CurrencyCNY)
+	if err != nil || quota != 500 || rate != 7 {
+		t.Errorf("got %d, %v, %v", quota, rate, err)
+	}
+	amount, _, err := QuotaToAmount(context.Background(), 500, CurrencyCNY)
+	if err != nil || math.Abs(amount-7) > 1e-9 {
+		t.Errorf("got %v, %v", amount, err)
+	}
+}
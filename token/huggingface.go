@@ -0,0 +1,811 @@
+/*
+Copyright 2024 The corego Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package token
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/dlclark/regexp2"
+	"golang.org/x/text/unicode/norm"
+)
+
+/*
+	HuggingFaceTokenizer reads the tokenizer.json of a HuggingFace model, e.g. Qwen/Qwen2-7B-Instruct,
+	deepseek-ai/DeepSeek-V2-Chat, THUDM/glm-4-9b-chat or meta-llama/Meta-Llama-3-8B-Instruct.
+
+	tokenizer, err := token.LoadHuggingFaceTokenizer("qwen2", "/data/tokenizers/qwen2/tokenizer.json")
+	if err != nil {
+		return err
+	}
+	token.RegisterTokenizer(tokenizer)
+	token.RegisterPrefixEncoding("qwen", "qwen2")
+
+	Supported components:
+	- normalizer: NFC, NFD, NFKC, NFKD, Lowercase, Strip, Prepend, Replace, BertNormalizer, Precompiled(NFKC), Sequence
+	- pre_tokenizer: ByteLevel, Metaspace, Split, Whitespace, WhitespaceSplit, Punctuation, Digits, BertPreTokenizer, Sequence
+	- model: BPE, WordPiece, Unigram
+	- decoder: ByteLevel, Metaspace, ByteFallback, Fuse, Strip, Replace, WordPiece, Sequence
+	The post_processor is ignored, the counted tokens do not include the BOS/EOS templates.
+*/
+
+// gpt2Pattern is the split pattern of the ByteLevel pre-tokenizer.
+const gpt2Pattern = `'s|'t|'re|'ve|'m|'ll|'d| ?\p{L}+| ?\p{N}+| ?[^\s\p{L}\p{N}]+|\s+(?!\S)|\s+`
+
+// hfConfig is the tokenizer.json file.
+type hfConfig struct {
+	AddedTokens  []hfAddedToken `json:"added_tokens"`
+	Normalizer   *hfComponent   `json:"normalizer"`
+	PreTokenizer *hfComponent   `json:"pre_tokenizer"`
+	Model        hfModel        `json:"model"`
+	Decoder      *hfComponent   `json:"decoder"`
+}
+
+// hfAddedToken is a token added to the vocabulary, it is never split.
+type hfAddedToken struct {
+	ID      int    `json:"id"`
+	Content string `json:"content"`
+	Special bool   `json:"special"`
+}
+
+// hfPattern is the pattern of Split and Replace.
+type hfPattern struct {
+	String *string `json:"String"`
+	Regex  *string `json:"Regex"`
+}
+
+// hfComponent is a normalizer, pre-tokenizer or decoder.
+type hfComponent struct {
+	Type string `json:"type"`
+
+	Normalizers   []*hfComponent `json:"normalizers"`
+	PreTokenizers []*hfComponent `json:"pretokenizers"`
+	Decoders      []*hfComponent `json:"decoders"`
+
+	Prepend string     `json:"prepend"`
+	Pattern *hfPattern `json:"pattern"`
+	Content string     `json:"content"`
+
+	AddPrefixSpace *bool  `json:"add_prefix_space"`
+	UseRegex       *bool  `json:"use_regex"`
+	Replacement    string `json:"replacement"`
+	PrependScheme  string `json:"prepend_scheme"`
+	Split          *bool  `json:"split"`
+
+	Behavior         string `json:"behavior"`
+	Invert           bool   `json:"invert"`
+	IndividualDigits bool   `json:"individual_digits"`
+
+	StripLeft          bool  `json:"left"`
+	StripRight         bool  `json:"right"`
+	Lowercase          *bool `json:"lowercase"`
+	HandleChineseChars *bool `json:"handle_chinese_chars"`
+	StripAccents       *bool `json:"strip_accents"`
+	CleanText          *bool `json:"clean_text"`
+
+	Start  int    `json:"start"`
+	Stop   int    `json:"stop"`
+	Prefix string `json:"prefix"`
+}
+
+// boolOr returns the value of b or def when it is not set.
+func boolOr(b *bool, def bool) bool {
+	if b == nil {
+		return def
+	}
+	return *b
+}
+
+// HuggingFaceTokenizer is the Tokenizer of a HuggingFace tokenizer.json.[HuggingFace分词器]
+type HuggingFaceTokenizer struct {
+	name         string
+	normalizer   func(string) string
+	preTokenizer func([]string) ([]string, error)
+	model        hfTokenModel
+	decoder      func([]string) []string
+	added        map[string]int
+	addedPattern *regexp.Regexp
+	idToToken    map[int]string
+}
+
+// LoadHuggingFaceTokenizer loads the tokenizer.json at path.
+func LoadHuggingFaceTokenizer(name, path string) (*HuggingFaceTokenizer, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return NewHuggingFaceTokenizer(name, file)
+}
+
+// NewHuggingFaceTokenizer reads a tokenizer.json.
+func NewHuggingFaceTokenizer(name string, r io.Reader) (*HuggingFaceTokenizer, error) {
+	if name == "" {
+		return nil, fmt.Errorf("tokenizer name is required")
+	}
+
+	var cfg hfConfig
+	if err := json.NewDecoder(r).Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("invalid tokenizer.json: %w", err)
+	}
+
+	t := &HuggingFaceTokenizer{
+		name:  name,
+		added: make(map[string]int),
+	}
+
+	var err error
+	if t.normalizer, err = newHFNormalizer(cfg.Normalizer); err != nil {
+		return nil, err
+	}
+	if t.preTokenizer, err = newHFPreTokenizer(cfg.PreTokenizer); err != nil {
+		return nil, err
+	}
+	if t.model, err = newHFModel(cfg.Model); err != nil {
+		return nil, err
+	}
+	if t.decoder, err = newHFDecoder(cfg.Decoder); err != nil {
+		return nil, err
+	}
+
+	t.idToToken = t.model.idToToken()
+	contents := make([]string, 0, len(cfg.AddedTokens))
+	for _, v := range cfg.AddedTokens {
+		if v.Content == "" {
+			continue
+		}
+		t.added[v.Content] = v.ID
+		t.idToToken[v.ID] = v.Content
+		contents = append(contents, v.Content)
+	}
+	if len(contents) != 0 {
+		// the longest token wins when several start at the same position.
+		sort.Slice(contents, func(i, j int) bool { return len(contents[i]) > len(contents[j]) })
+		quoted := make([]string, 0, len(contents))
+		for _, v := range contents {
+			quoted = append(quoted, regexp.QuoteMeta(v))
+		}
+		t.addedPattern = regexp.MustCompile(strings.Join(quoted, "|"))
+	}
+	return t, nil
+}
+
+// Name implements the Tokenizer interface.
+func (t *HuggingFaceTokenizer) Name() string {
+	return t.name
+}
+
+// Encode implements the Tokenizer interface. Text that the pre-tokenizer can not split,
+// e.g. because of a regex timeout, is encoded as a single word.
+func (t *HuggingFaceTokenizer) Encode(text string) []int {
+	ids := make([]int, 0, len(text)/3)
+	if t.addedPattern == nil {
+		return t.encodeSegment(text, ids)
+	}
+
+	last := 0
+	for _, loc := range t.addedPattern.FindAllStringIndex(text, -1) {
+		ids = t.encodeSegment(text[last:loc[0]], ids)
+		ids = append(ids, t.added[text[loc[0]:loc[1]]])
+		last = loc[1]
+	}
+	return t.encodeSegment(text[last:], ids)
+}
+
+// encodeSegment encodes text without added tokens.
+func (t *HuggingFaceTokenizer) encodeSegment(text string, ids []int) []int {
+	if text == "" {
+		return ids
+	}
+
+	normalized := t.normalizer(text)
+	words, err := t.preTokenizer([]string{normalized})
+	if err != nil {
+		words = []string{normalized}
+	}
+	for _, word := range words {
+		if word == "" {
+			continue
+		}
+		ids = append(ids, t.model.tokenize(word)...)
+	}
+	return ids
+}
+
+// Decode implements the Tokenizer interface.
+func (t *HuggingFaceTokenizer) Decode(ids []int) string {
+	tokens := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if token, ok := t.idToToken[id]; ok {
+			tokens = append(tokens, token)
+		}
+	}
+	return strings.Join(t.decoder(tokens), "")
+}
+
+// newHFNormalizer creates the normalizer, nil is the identity.
+func newHFNormalizer(c *hfComponent) (func(string) string, error) {
+	if c == nil {
+		return func(s string) string { return s }, nil
+	}
+
+	switch c.Type {
+	case "Sequence":
+		list := make([]func(string) string, 0, len(c.Normalizers))
+		for _, v := range c.Normalizers {
+			fn, err := newHFNormalizer(v)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, fn)
+		}
+		return func(s string) string {
+			for _, fn := range list {
+				s = fn(s)
+			}
+			return s
+		}, nil
+	case "NFC":
+		return norm.NFC.String, nil
+	case "NFD":
+		return norm.NFD.String, nil
+	case "NFKC", "Precompiled":
+		// the precompiled charsmap of sentencepiece is mostly NFKC.
+		return norm.NFKC.String, nil
+	case "NFKD":
+		return norm.NFKD.String, nil
+	case "Lowercase":
+		return strings.ToLower, nil
+	case "Strip":
+		return func(s string) string {
+			if c.StripLeft {
+				s = strings.TrimLeftFunc(s, unicode.IsSpace)
+			}
+			if c.StripRight {
+				s = strings.TrimRightFunc(s, unicode.IsSpace)
+			}
+			return s
+		}, nil
+	case "Prepend":
+		return func(s string) string {
+			if s == "" {
+				return s
+			}
+			return c.Prepend + s
+		}, nil
+	case "Replace":
+		return newHFReplace(c)
+	case "BertNormalizer":
+		return newBertNormalizer(c), nil
+	}
+	return nil, fmt.Errorf("unsupported normalizer: %s", c.Type)
+}
+
+// newHFReplace creates the Replace normalizer.
+func newHFReplace(c *hfComponent) (func(string) string, error) {
+	if c.Pattern == nil {
+		return nil, fmt.Errorf("replace pattern is required")
+	}
+	if c.Pattern.String != nil {
+		old := *c.Pattern.String
+		return func(s string) string { return strings.ReplaceAll(s, old, c.Content) }, nil
+	}
+	if c.Pattern.Regex == nil {
+		return nil, fmt.Errorf("replace pattern is required")
+	}
+
+	re, err := regexp2.Compile(*c.Pattern.Regex, regexp2.None)
+	if err != nil {
+		return nil, fmt.Errorf("invalid replace pattern: %w", err)
+	}
+	return func(s string) string {
+		replaced, err := re.Replace(s, c.Content, -1, -1)
+		if err != nil {
+			return s
+		}
+		return replaced
+	}, nil
+}
+
+// newBertNormalizer creates the BertNormalizer.
+func newBertNormalizer(c *hfComponent) func(string) string {
+	lowercase := boolOr(c.Lowercase, true)
+	chinese := boolOr(c.HandleChineseChars, true)
+	stripAccents := boolOr(c.StripAccents, lowercase)
+	cleanText := boolOr(c.CleanText, true)
+
+	return func(s string) string {
+		var b strings.Builder
+		for _, r := range s {
+			switch {
+			case cleanText && (r == 0 || r == utf8.RuneError || (unicode.IsControl(r) && !unicode.IsSpace(r))):
+				continue
+			case cleanText && unicode.IsSpace(r):
+				b.WriteRune(' ')
+			case chinese && isChineseChar(r):
+				b.WriteRune(' ')
+				b.WriteRune(r)
+				b.WriteRune(' ')
+			default:
+				b.WriteRune(r)
+			}
+		}
+
+		s = b.String()
+		if stripAccents {
+			s = stripMarks(s)
+		}
+		if lowercase {
+			s = strings.ToLower(s)
+		}
+		return s
+	}
+}
+
+// stripMarks removes the accents of s.
+func stripMarks(s string) string {
+	var b strings.Builder
+	for _, r := range norm.NFD.String(s) {
+		if !unicode.Is(unicode.Mn, r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// isChineseChar reports whether r is a CJK ideograph.
+func isChineseChar(r rune) bool {
+	return (r >= 0x4E00 && r <= 0x9FFF) ||
+		(r >= 0x3400 && r <= 0x4DBF) ||
+		(r >= 0x20000 && r <= 0x2A6DF) ||
+		(r >= 0x2A700 && r <= 0x2B73F) ||
+		(r >= 0x2B740 && r <= 0x2B81F) ||
+		(r >= 0x2B820 && r <= 0x2CEAF) ||
+		(r >= 0xF900 && r <= 0xFAFF) ||
+		(r >= 0x2F800 && r <= 0x2FA1F)
+}
+
+// newHFPreTokenizer creates the pre-tokenizer, it splits every piece into words.
+func newHFPreTokenizer(c *hfComponent) (func([]string) ([]string, error), error) {
+	if c == nil {
+		return func(pieces []string) ([]string, error) { return pieces, nil }, nil
+	}
+
+	switch c.Type {
+	case "Sequence":
+		list := make([]func([]string) ([]string, error), 0, len(c.PreTokenizers))
+		for _, v := range c.PreTokenizers {
+			fn, err := newHFPreTokenizer(v)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, fn)
+		}
+		return func(pieces []string) ([]string, error) {
+			var err error
+			for _, fn := range list {
+				if pieces, err = fn(pieces); err != nil {
+					return nil, err
+				}
+			}
+			return pieces, nil
+		}, nil
+	case "ByteLevel":
+		return newByteLevelPreTokenizer(c)
+	case "Metaspace":
+		return newMetaspacePreTokenizer(c), nil
+	case "Split":
+		return newSplitPreTokenizer(c)
+	case "Whitespace":
+		return newRegexPreTokenizer(`\w+|[^\w\s]+`, "Removed", true)
+	case "WhitespaceSplit":
+		return func(pieces []string) ([]string, error) {
+			words := make([]string, 0, len(pieces))
+			for _, piece := range pieces {
+				words = append(words, strings.Fields(piece)...)
+			}
+			return words, nil
+		}, nil
+	case "Punctuation":
+		behavior := c.Behavior
+		if behavior == "" {
+			behavior = "Isolated"
+		}
+		return newRegexPreTokenizer(`\p{P}|[$+<=>^`+"`"+`|~]`, behavior, false)
+	case "Digits":
+		if c.IndividualDigits {
+			return newRegexPreTokenizer(`\p{N}`, "Isolated", false)
+		}
+		return newRegexPreTokenizer(`\p{N}+`, "Isolated", false)
+	case "BertPreTokenizer":
+		whitespace, _ := newHFPreTokenizer(&hfComponent{Type: "WhitespaceSplit"})
+		punctuation, err := newRegexPreTokenizer(`\p{P}|[$+<=>^`+"`"+`|~]`, "Isolated", false)
+		if err != nil {
+			return nil, err
+		}
+		return func(pieces []string) ([]string, error) {
+			pieces, _ = whitespace(pieces)
+			return punctuation(pieces)
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported pre_tokenizer: %s", c.Type)
+}
+
+// newByteLevelPreTokenizer creates the ByteLevel pre-tokenizer, the words are mapped to the byte alphabet.
+func newByteLevelPreTokenizer(c *hfComponent) (func([]string) ([]string, error), error) {
+	addPrefixSpace := boolOr(c.AddPrefixSpace, true)
+	var split func([]string) ([]string, error)
+	if boolOr(c.UseRegex, true) {
+		var err error
+		if split, err = newRegexPreTokenizer(gpt2Pattern, "Isolated", false); err != nil {
+			return nil, err
+		}
+	}
+
+	return func(pieces []string) ([]string, error) {
+		if addPrefixSpace {
+			for idx, piece := range pieces {
+				if piece != "" && !strings.HasPrefix(piece, " ") {
+					pieces[idx] = " " + piece
+				}
+			}
+		}
+		if split != nil {
+			var err error
+			if pieces, err = split(pieces); err != nil {
+				return nil, err
+			}
+		}
+		for idx, piece := range pieces {
+			pieces[idx] = byteLevelEncode(piece)
+		}
+		return pieces, nil
+	}, nil
+}
+
+// newMetaspacePreTokenizer creates the Metaspace pre-tokenizer of sentencepiece models.
+func newMetaspacePreTokenizer(c *hfComponent) func([]string) ([]string, error) {
+	replacement := c.Replacement
+	if replacement == "" {
+		replacement = "▁"
+	}
+	scheme := c.PrependScheme
+	if scheme == "" {
+		scheme = "never"
+		if boolOr(c.AddPrefixSpace, true) {
+			scheme = "always"
+		}
+	}
+	split := boolOr(c.Split, true)
+
+	return func(pieces []string) ([]string, error) {
+		words := make([]string, 0, len(pieces))
+		for idx, piece := range pieces {
+			piece = strings.ReplaceAll(piece, " ", replacement)
+			if piece != "" && !strings.HasPrefix(piece, replacement) && (scheme == "always" || (scheme == "first" && idx == 0)) {
+				piece = replacement + piece
+			}
+			if !split {
+				words = append(words, piece)
+				continue
+			}
+
+			words = append(words, splitMetaspace(piece, replacement)...)
+		}
+		return words, nil
+	}
+}
+
+// splitMetaspace splits piece before every replacement.
+func splitMetaspace(piece, replacement string) []string {
+	words := make([]string, 0)
+	for piece != "" {
+		_, size := utf8.DecodeRuneInString(piece)
+		idx := strings.Index(piece[size:], replacement)
+		if idx < 0 {
+			words = append(words, piece)
+			break
+		}
+		words = append(words, piece[:size+idx])
+		piece = piece[size+idx:]
+	}
+	return words
+}
+
+// newSplitPreTokenizer creates the Split pre-tokenizer.
+func newSplitPreTokenizer(c *hfComponent) (func([]string) ([]string, error), error) {
+	if c.Pattern == nil {
+		return nil, fmt.Errorf("split pattern is required")
+	}
+
+	pattern := ""
+	if c.Pattern.String != nil {
+		pattern = regexp2.Escape(*c.Pattern.String)
+	} else if c.Pattern.Regex != nil {
+		pattern = *c.Pattern.Regex
+	} else {
+		return nil, fmt.Errorf("split pattern is required")
+	}
+	return newRegexPreTokenizer(pattern, c.Behavior, c.Invert)
+}
+
+// newRegexPreTokenizer splits the pieces by the matches of pattern.
+func newRegexPreTokenizer(pattern, behavior string, invert bool) (func([]string) ([]string, error), error) {
+	re, err := regexp2.Compile(pattern, regexp2.None)
+	if err != nil {
+		return nil, fmt.Errorf("invalid split pattern %s: %w", pattern, err)
+	}
+
+	return func(pieces []string) ([]string, error) {
+		words := make([]string, 0, len(pieces))
+		for _, piece := range pieces {
+			runes := []rune(piece)
+			spans, err := regexSpans(re, runes, invert)
+			if err != nil {
+				return nil, err
+			}
+			words = append(words, splitSpans(runes, spans, behavior)...)
+		}
+		return words, nil
+	}, nil
+}
+
+// span is a range of runes, match tells whether it matched the split pattern.
+type span struct {
+	start, end int
+	match      bool
+}
+
+// regexSpans returns the matched and unmatched spans of runes.
+func regexSpans(re *regexp2.Regexp, runes []rune, invert bool) ([]span, error) {
+	spans := make([]span, 0)
+	last := 0
+	m, err := re.FindRunesMatch(runes)
+	for ; m != nil && err == nil; m, err = re.FindNextMatch(m) {
+		if m.Length == 0 {
+			continue
+		}
+		if m.Index > last {
+			spans = append(spans, span{start: last, end: m.Index, match: invert})
+		}
+		spans = append(spans, span{start: m.Index, end: m.Index + m.Length, match: !invert})
+		last = m.Index + m.Length
+	}
+	if err != nil {
+		return nil, err
+	}
+	if last < len(runes) {
+		spans = append(spans, span{start: last, end: len(runes), match: invert})
+	}
+	return spans, nil
+}
+
+// splitSpans splits the runes by the spans with the behavior of HuggingFace Split.
+func splitSpans(runes []rune, spans []span, behavior string) []string {
+	words := make([]string, 0, len(spans))
+	pending := ""
+	for idx, v := range spans {
+		text := string(runes[v.start:v.end])
+		switch {
+		case !v.match:
+			words = append(words, pending+text)
+			pending = ""
+		case behavior == "Removed":
+		case behavior == "MergedWithPrevious":
+			if len(words) != 0 && !(idx > 0 && spans[idx-1].match) {
+				words[len(words)-1] += text
+			} else {
+				words = append(words, text)
+			}
+		case behavior == "MergedWithNext":
+			pending += text
+		case behavior == "Contiguous":
+			if idx > 0 && spans[idx-1].match && len(words) != 0 {
+				words[len(words)-1] += text
+			} else {
+				words = append(words, text)
+			}
+		default:
+			words = append(words, text)
+		}
+	}
+	if pending != "" {
+		words = append(words, pending)
+	}
+	return words
+}
+
+// newHFDecoder creates the decoder, nil joins the tokens with spaces.
+func newHFDecoder(c *hfComponent) (func([]string) []string, error) {
+	if c == nil {
+		return func(tokens []string) []string { return []string{strings.Join(tokens, " ")} }, nil
+	}
+
+	switch c.Type {
+	case "Sequence":
+		list := make([]func([]string) []string, 0, len(c.Decoders))
+		for _, v := range c.Decoders {
+			fn, err := newHFDecoder(v)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, fn)
+		}
+		return func(tokens []string) []string {
+			for _, fn := range list {
+				tokens = fn(tokens)
+			}
+			return tokens
+		}, nil
+	case "ByteLevel":
+		return func(tokens []string) []string {
+			return []string{byteLevelDecode(strings.Join(tokens, ""))}
+		}, nil
+	case "Metaspace":
+		replacement := c.Replacement
+		if replacement == "" {
+			replacement = "▁"
+		}
+		stripFirst := c.PrependScheme != "never" && boolOr(c.AddPrefixSpace, true)
+		return func(tokens []string) []string {
+			out := make([]string, 0, len(tokens))
+			for idx, token := range tokens {
+				token = strings.ReplaceAll(token, replacement, " ")
+				if idx == 0 && stripFirst {
+					token = strings.TrimPrefix(token, " ")
+				}
+				out = append(out, token)
+			}
+			return out
+		}, nil
+	case "ByteFallback":
+		return byteFallbackDecode, nil
+	case "Fuse":
+		return func(tokens []string) []string { return []string{strings.Join(tokens, "")} }, nil
+	case "Strip":
+		return func(tokens []string) []string {
+			out := make([]string, 0, len(tokens))
+			for _, token := range tokens {
+				for i := 0; i < c.Start && strings.HasPrefix(token, c.Content); i++ {
+					token = strings.TrimPrefix(token, c.Content)
+				}
+				for i := 0; i < c.Stop && strings.HasSuffix(token, c.Content); i++ {
+					token = strings.TrimSuffix(token, c.Content)
+				}
+				out = append(out, token)
+			}
+			return out
+		}, nil
+	case "Replace":
+		replace, err := newHFReplace(c)
+		if err != nil {
+			return nil, err
+		}
+		return func(tokens []string) []string {
+			out := make([]string, 0, len(tokens))
+			for _, token := range tokens {
+				out = append(out, replace(token))
+			}
+			return out
+		}, nil
+	case "WordPiece":
+		prefix := c.Prefix
+		if prefix == "" {
+			prefix = "##"
+		}
+		return func(tokens []string) []string {
+			var b strings.Builder
+			for idx, token := range tokens {
+				if strings.HasPrefix(token, prefix) {
+					b.WriteString(strings.TrimPrefix(token, prefix))
+					continue
+				}
+				if idx != 0 {
+					b.WriteByte(' ')
+				}
+				b.WriteString(token)
+			}
+			return []string{b.String()}
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported decoder: %s", c.Type)
+}
+
+// byteFallbackDecode turns the <0xXX> tokens back into their bytes.
+func byteFallbackDecode(tokens []string) []string {
+	out := make([]string, 0, len(tokens))
+	var pending []byte
+	flush := func() {
+		if len(pending) == 0 {
+			return
+		}
+		out = append(out, strings.ToValidUTF8(string(pending), "�"))
+		pending = nil
+	}
+	for _, token := range tokens {
+		if b, ok := parseByteToken(token); ok {
+			pending = append(pending, b)
+			continue
+		}
+		flush()
+		out = append(out, token)
+	}
+	flush()
+	return out
+}
+
+// parseByteToken parses a <0xXX> token.
+func parseByteToken(token string) (byte, bool) {
+	if len(token) != 6 || !strings.HasPrefix(token, "<0x") || !strings.HasSuffix(token, ">") {
+		return 0, false
+	}
+	v, err := strconv.ParseUint(token[3:5], 16, 8)
+	if err != nil {
+		return 0, false
+	}
+	return byte(v), true
+}
+
+// byteEncoder and byteDecoder map the bytes to the printable alphabet of GPT-2.
+var byteEncoder, byteDecoder = newByteAlphabet()
+
+// newByteAlphabet creates the bytes_to_unicode table of GPT-2.
+func newByteAlphabet() ([256]rune, map[rune]byte) {
+	var encoder [256]rune
+	decoder := make(map[rune]byte, 256)
+	n := 0
+	for b := 0; b < 256; b++ {
+		if (b >= '!' && b <= '~') || (b >= 0xA1 && b <= 0xAC) || (b >= 0xAE && b <= 0xFF) {
+			encoder[b] = rune(b)
+		} else {
+			encoder[b] = rune(256 + n)
+			n++
+		}
+		decoder[encoder[b]] = byte(b)
+	}
+	return encoder, decoder
+}
+
+// byteLevelEncode maps the bytes of s to the byte alphabet.
+func byteLevelEncode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		b.WriteRune(byteEncoder[s[i]])
+	}
+	return b.String()
+}
+
+// byteLevelDecode maps the byte alphabet back to bytes.
+func byteLevelDecode(s string) string {
+	out := make([]byte, 0, len(s))
+	for _, r := range s {
+		if b, ok := byteDecoder[r]; ok {
+			out = append(out, b)
+			continue
+		}
+		out = append(out, string(r)...)
+	}
+	return strings.ToValidUTF8(string(out), "�")
+}
@@ -0,0 +1,559 @@
+/*
+Copyright 2024 The corego Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package token
+
+import (
+	"container/heap"
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// hfModel is the model of tokenizer.json.
+type hfModel struct {
+	Type                    string          `json:"type"`
+	Vocab                   json.RawMessage `json:"vocab"`
+	Merges                  json.RawMessage `json:"merges"`
+	UnkToken                *string         `json:"unk_token"`
+	UnkID                   *int            `json:"unk_id"`
+	ContinuingSubwordPrefix *string         `json:"continuing_subword_prefix"`
+	EndOfWordSuffix         *string         `json:"end_of_word_suffix"`
+	MaxInputCharsPerWord    int             `json:"max_input_chars_per_word"`
+	FuseUnk                 bool            `json:"fuse_unk"`
+	ByteFallback            bool            `json:"byte_fallback"`
+	IgnoreMerges            bool            `json:"ignore_merges"`
+}
+
+// hfTokenModel turns a pre-tokenized word into ids.
+type hfTokenModel interface {
+	tokenize(word string) []int
+	idToToken() map[int]string
+}
+
+// newHFModel creates the model, the type is guessed for files written without it.
+func newHFModel(m hfModel) (hfTokenModel, error) {
+	typ := m.Type
+	if typ == "" {
+		switch {
+		case len(m.Merges) != 0:
+			typ = "BPE"
+		case strings.HasPrefix(strings.TrimSpace(string(m.Vocab)), "["):
+			typ = "Unigram"
+		default:
+			typ = "WordPiece"
+		}
+	}
+
+	switch typ {
+	case "BPE":
+		return newHFBPE(m)
+	case "WordPiece":
+		return newHFWordPiece(m)
+	case "Unigram":
+		return newHFUnigram(m)
+	}
+	return nil, fmt.Errorf("unsupported model: %s", typ)
+}
+
+// stringOr returns the value of s or def when it is not set.
+func stringOr(s *string, def string) string {
+	if s == nil {
+		return def
+	}
+	return *s
+}
+
+// byteFallbackIDs returns the <0xXX> tokens of word, ok is false if one of them is missing.
+func byteFallbackIDs(vocab map[string]int, word string) ([]int, bool) {
+	ids := make([]int, 0, len(word))
+	for i := 0; i < len(word); i++ {
+		id, ok := vocab[fmt.Sprintf("<0x%02X>", word[i])]
+		if !ok {
+			return nil, false
+		}
+		ids = append(ids, id)
+	}
+	return ids, true
+}
+
+// reverseVocab returns the tokens by id.
+func reverseVocab(vocab map[string]int) map[int]string {
+	tokens := make(map[int]string, len(vocab))
+	for k, v := range vocab {
+		tokens[v] = k
+	}
+	return tokens
+}
+
+// mergePair is a pair of adjacent symbols.
+type mergePair struct {
+	left, right string
+}
+
+const (
+	// wordCacheCapacity is the number of words whose ids are cached by a BPE model.
+	wordCacheCapacity = 10000
+	// maxCachedWordLen skips the long words, they are rarely repeated.
+	maxCachedWordLen = 256
+)
+
+// wordCacheEntry is an element of the LRU list of the word cache.
+type wordCacheEntry struct {
+	word string
+	ids  []int
+}
+
+// wordCache is a bounded LRU cache of the ids of words.
+type wordCache struct {
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+// newWordCache creates an empty wordCache.
+func newWordCache() *wordCache {
+	return &wordCache{entries: make(map[string]*list.Element), lru: list.New()}
+}
+
+// get returns the cached ids of the word.
+func (c *wordCache) get(word string) ([]int, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[word]
+	if !ok {
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	return elem.Value.(*wordCacheEntry).ids, true
+}
+
+// put caches the ids of the word, the least recently used word is evicted when the cache is full.
+func (c *wordCache) put(word string, ids []int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[word]; ok {
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.entries[word] = c.lru.PushFront(&wordCacheEntry{word: word, ids: ids})
+	if c.lru.Len() > wordCacheCapacity {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*wordCacheEntry).word)
+	}
+}
+
+// bpeSymbol is a symbol of a word in a linked list, a merged symbol is emptied.
+type bpeSymbol struct {
+	text       string
+	prev, next int
+}
+
+// bpeMerge is a candidate merge of the symbol at pos with the next one.
+type bpeMerge struct {
+	rank        int
+	pos         int
+	left, right string
+}
+
+// mergeQueue is a min-heap of the candidate merges, the leftmost of the same rank first.
+type mergeQueue []bpeMerge
+
+func (q mergeQueue) Len() int { return len(q) }
+func (q mergeQueue) Less(i, j int) bool {
+	if q[i].rank != q[j].rank {
+		return q[i].rank < q[j].rank
+	}
+	return q[i].pos < q[j].pos
+}
+func (q mergeQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *mergeQueue) Push(x interface{}) { *q = append(*q, x.(bpeMerge)) }
+func (q *mergeQueue) Pop() interface{} {
+	old := *q
+	x := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return x
+}
+
+// hfBPE is the BPE model.
+type hfBPE struct {
+	vocab        map[string]int
+	ranks        map[mergePair]int
+	unk          string
+	prefix       string
+	suffix       string
+	fuseUnk      bool
+	byteFallback bool
+	ignoreMerges bool
+	cache        *wordCache
+}
+
+// newHFBPE creates the BPE model, merges are either "a b" strings or ["a", "b"] pairs.
+func newHFBPE(m hfModel) (*hfBPE, error) {
+	bpe := &hfBPE{
+		ranks:        make(map[mergePair]int),
+		unk:          stringOr(m.UnkToken, ""),
+		prefix:       stringOr(m.ContinuingSubwordPrefix, ""),
+		suffix:       stringOr(m.EndOfWordSuffix, ""),
+		fuseUnk:      m.FuseUnk,
+		byteFallback: m.ByteFallback,
+		ignoreMerges: m.IgnoreMerges,
+		cache:        newWordCache(),
+	}
+	if err := json.Unmarshal(m.Vocab, &bpe.vocab); err != nil {
+		return nil, fmt.Errorf("invalid bpe vocab: %w", err)
+	}
+
+	var raw []json.RawMessage
+	if len(m.Merges) != 0 {
+		if err := json.Unmarshal(m.Merges, &raw); err != nil {
+			return nil, fmt.Errorf("invalid bpe merges: %w", err)
+		}
+	}
+	for rank, v := range raw {
+		var pair []string
+		if err := json.Unmarshal(v, &pair); err != nil {
+			var merge string
+			if err := json.Unmarshal(v, &merge); err != nil {
+				return nil, fmt.Errorf("invalid bpe merge %d: %w", rank, err)
+			}
+			pair = strings.SplitN(merge, " ", 2)
+		}
+		if len(pair) != 2 {
+			return nil, fmt.Errorf("invalid bpe merge %d", rank)
+		}
+		key := mergePair{left: pair[0], right: pair[1]}
+		if _, ok := bpe.ranks[key]; !ok {
+			bpe.ranks[key] = rank
+		}
+	}
+	return bpe, nil
+}
+
+// idToToken implements the hfTokenModel interface.
+func (b *hfBPE) idToToken() map[int]string {
+	return reverseVocab(b.vocab)
+}
+
+// tokenize implements the hfTokenModel interface, the ids of the short words are cached.
+func (b *hfBPE) tokenize(word string) []int {
+	if len(word) > maxCachedWordLen {
+		return b.encodeWord(word)
+	}
+	if ids, ok := b.cache.get(word); ok {
+		return ids
+	}
+	ids := b.encodeWord(word)
+	b.cache.put(word, ids)
+	return ids
+}
+
+// encodeWord merges the symbols of the word by rank. The candidate merges are kept in a heap and
+// the symbols in a linked list, so a word of n symbols is merged in O(n log n).
+func (b *hfBPE) encodeWord(word string) []int {
+	if b.ignoreMerges {
+		if id, ok := b.vocab[word]; ok {
+			return []int{id}
+		}
+	}
+
+	symbols := make([]bpeSymbol, 0, utf8.RuneCountInString(word))
+	for idx, r := range word {
+		symbol := string(r)
+		if idx != 0 {
+			symbol = b.prefix + symbol
+		}
+		symbols = append(symbols, bpeSymbol{text: symbol, prev: len(symbols) - 1, next: len(symbols) + 1})
+	}
+	if len(symbols) == 0 {
+		return nil
+	}
+	symbols[len(symbols)-1].next = -1
+	if b.suffix != "" {
+		symbols[len(symbols)-1].text += b.suffix
+	}
+
+	queue := mergeQueue{}
+	push := func(pos int) {
+		next := symbols[pos].next
+		if next < 0 {
+			return
+		}
+		pair := mergePair{left: symbols[pos].text, right: symbols[next].text}
+		if rank, ok := b.ranks[pair]; ok {
+			heap.Push(&queue, bpeMerge{rank: rank, pos: pos, left: pair.left, right: pair.right})
+		}
+	}
+	for pos := range symbols {
+		push(pos)
+	}
+
+	for queue.Len() != 0 {
+		merge := heap.Pop(&queue).(bpeMerge)
+		left := &symbols[merge.pos]
+		// a symbol changed by an earlier merge makes the candidate stale.
+		if left.text != merge.left || left.next < 0 || symbols[left.next].text != merge.right {
+			continue
+		}
+
+		right := &symbols[left.next]
+		left.text += strings.TrimPrefix(right.text, b.prefix)
+		left.next = right.next
+		if right.next >= 0 {
+			symbols[right.next].prev = merge.pos
+		}
+		right.text = ""
+
+		if left.prev >= 0 {
+			push(left.prev)
+		}
+		push(merge.pos)
+	}
+
+	ids := make([]int, 0, len(symbols))
+	unknown := false
+	for pos := 0; pos >= 0; pos = symbols[pos].next {
+		symbol := symbols[pos].text
+		if id, ok := b.vocab[symbol]; ok {
+			ids = append(ids, id)
+			unknown = false
+			continue
+		}
+		if b.byteFallback {
+			raw := strings.TrimSuffix(strings.TrimPrefix(symbol, b.prefix), b.suffix)
+			if fallback, ok := byteFallbackIDs(b.vocab, raw); ok {
+				ids = append(ids, fallback...)
+				unknown = false
+				continue
+			}
+		}
+		if id, ok := b.vocab[b.unk]; ok && !(b.fuseUnk && unknown) {
+			ids = append(ids, id)
+		}
+		unknown = true
+	}
+	return ids
+}
+
+// hfWordPiece is the WordPiece model of BERT.
+type hfWordPiece struct {
+	vocab    map[string]int
+	unk      string
+	prefix   string
+	maxChars int
+}
+
+// newHFWordPiece creates the WordPiece model.
+func newHFWordPiece(m hfModel) (*hfWordPiece, error) {
+	wp := &hfWordPiece{
+		unk:      stringOr(m.UnkToken, "[UNK]"),
+		prefix:   stringOr(m.ContinuingSubwordPrefix, "##"),
+		maxChars: m.MaxInputCharsPerWord,
+	}
+	if wp.maxChars <= 0 {
+		wp.maxChars = 100
+	}
+	if err := json.Unmarshal(m.Vocab, &wp.vocab); err != nil {
+		return nil, fmt.Errorf("invalid wordpiece vocab: %w", err)
+	}
+	return wp, nil
+}
+
+// idToToken implements the hfTokenModel interface.
+func (w *hfWordPiece) idToToken() map[int]string {
+	return reverseVocab(w.vocab)
+}
+
+// tokenize implements the hfTokenModel interface, the longest known prefix is taken first.
+func (w *hfWordPiece) tokenize(word string) []int {
+	unk := []int{}
+	if id, ok := w.vocab[w.unk]; ok {
+		unk = []int{id}
+	}
+
+	runes := []rune(word)
+	if len(runes) > w.maxChars {
+		return unk
+	}
+
+	ids := make([]int, 0, 4)
+	for start := 0; start < len(runes); {
+		end := len(runes)
+		found := -1
+		for ; end > start; end-- {
+			piece := string(runes[start:end])
+			if start > 0 {
+				piece = w.prefix + piece
+			}
+			if id, ok := w.vocab[piece]; ok {
+				found = id
+				break
+			}
+		}
+		if found < 0 {
+			return unk
+		}
+		ids = append(ids, found)
+		start = end
+	}
+	return ids
+}
+
+// unigramPiece is a piece of the unigram vocabulary.
+type unigramPiece struct {
+	id    int
+	score float64
+}
+
+// hfUnigram is the Unigram model of sentencepiece.
+type hfUnigram struct {
+	pieces       map[string]unigramPiece
+	tokens       map[int]string
+	unkID        int
+	unkScore     float64
+	maxLen       int
+	byteFallback bool
+}
+
+// unkPenalty is subtracted from the lowest score for unknown characters, like sentencepiece.
+const unkPenalty = 10.0
+
+// newHFUnigram creates the Unigram model, the vocab is a list of [piece, score].
+func newHFUnigram(m hfModel) (*hfUnigram, error) {
+	var vocab [][]json.RawMessage
+	if err := json.Unmarshal(m.Vocab, &vocab); err != nil {
+		return nil, fmt.Errorf("invalid unigram vocab: %w", err)
+	}
+
+	u := &hfUnigram{
+		pieces:       make(map[string]unigramPiece, len(vocab)),
+		tokens:       make(map[int]string, len(vocab)),
+		unkID:        -1,
+		byteFallback: m.ByteFallback,
+	}
+	if m.UnkID != nil {
+		u.unkID = *m.UnkID
+	}
+
+	minScore := math.MaxFloat64
+	for id, v := range vocab {
+		if len(v) != 2 {
+			return nil, fmt.Errorf("invalid unigram piece %d", id)
+		}
+		var (
+			piece string
+			score float64
+		)
+		if err := json.Unmarshal(v[0], &piece); err != nil {
+			return nil, fmt.Errorf("invalid unigram piece %d: %w", id, err)
+		}
+		if err := json.Unmarshal(v[1], &score); err != nil {
+			return nil, fmt.Errorf("invalid unigram score %d: %w", id, err)
+		}
+
+		u.pieces[piece] = unigramPiece{id: id, score: score}
+		u.tokens[id] = piece
+		minScore = math.Min(minScore, score)
+		if n := utf8.RuneCountInString(piece); n > u.maxLen {
+			u.maxLen = n
+		}
+	}
+	u.unkScore = minScore - unkPenalty
+	return u, nil
+}
+
+// idToToken implements the hfTokenModel interface.
+func (u *hfUnigram) idToToken() map[int]string {
+	tokens := make(map[int]string, len(u.tokens))
+	for k, v := range u.tokens {
+		tokens[k] = v
+	}
+	return tokens
+}
+
+// tokenize implements the hfTokenModel interface, it finds the most likely segmentation with viterbi.
+func (u *hfUnigram) tokenize(word string) []int {
+	runes := []rune(word)
+	n := len(runes)
+
+	// best[i] is the best segmentation of runes[:i], from is where its last piece starts.
+	best := make([]float64, n+1)
+	from := make([]int, n+1)
+	pieces := make([]int, n+1)
+	for i := 1; i <= n; i++ {
+		best[i] = math.Inf(-1)
+	}
+
+	for start := 0; start < n; start++ {
+		if math.IsInf(best[start], -1) {
+			continue
+		}
+		for end := start + 1; end <= n && end-start <= u.maxLen; end++ {
+			piece, ok := u.pieces[string(runes[start:end])]
+			if ok && best[start]+piece.score > best[end] {
+				best[end] = best[start] + piece.score
+				from[end] = start
+				pieces[end] = piece.id
+			}
+		}
+		// an unknown character keeps the lattice connected.
+		if score := best[start] + u.unkScore; score > best[start+1] {
+			best[start+1] = score
+			from[start+1] = start
+			pieces[start+1] = -1
+		}
+	}
+
+	reversed := make([]int, 0, n)
+	for end := n; end > 0; end = from[end] {
+		id := pieces[end]
+		if id >= 0 {
+			reversed = append(reversed, id)
+			continue
+		}
+
+		if u.byteFallback {
+			raw := string(runes[from[end]:end])
+			fallback := make([]int, 0, len(raw))
+			for i := 0; i < len(raw); i++ {
+				if piece, ok := u.pieces[fmt.Sprintf("<0x%02X>", raw[i])]; ok {
+					fallback = append(fallback, piece.id)
+				}
+			}
+			if len(fallback) == len(raw) {
+				for i := len(fallback) - 1; i >= 0; i-- {
+					reversed = append(reversed, fallback[i])
+				}
+				continue
+			}
+		}
+		// consecutive unknown characters are fused into a single unk.
+		if u.unkID >= 0 && (len(reversed) == 0 || reversed[len(reversed)-1] != u.unkID) {
+			reversed = append(reversed, u.unkID)
+		}
+	}
+
+	ids := make([]int, len(reversed))
+	for i, id := range reversed {
+		ids[len(reversed)-1-i] = id
+	}
+	return ids
+}
@@ -0,0 +1,136 @@
+package token
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHuggingFaceBPE(t *testing.T) {
+	tokenizer, err := LoadHuggingFaceTokenizer("test-bpe", "testdata/bpe_tokenizer.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := tokenizer.Encode("hello hello<|im_end|>")
+	if !reflect.DeepEqual(ids, []int{8, 9, 10}) {
+		t.Errorf("unexpected ids: %v", ids)
+	}
+	if text := tokenizer.Decode(ids); text != "hello hello<|im_end|>" {
+		t.Errorf("unexpected text: %q", text)
+	}
+}
+
+func TestHuggingFaceUnigram(t *testing.T) {
+	tokenizer, err := LoadHuggingFaceTokenizer("test-unigram", "testdata/unigram_tokenizer.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := tokenizer.Encode("hello")
+	if !reflect.DeepEqual(ids, []int{2, 3}) {
+		t.Errorf("unexpected ids: %v", ids)
+	}
+	if text := tokenizer.Decode(ids); text != "hello" {
+		t.Errorf("unexpected text: %q", text)
+	}
+
+	// i is unknown.
+	if ids := tokenizer.Encode("hi"); !reflect.DeepEqual(ids, []int{1, 6, 0}) {
+		t.Errorf("unexpected ids: %v", ids)
+	}
+}
+
+func TestRegisterTokenizer(t *testing.T) {
+	tokenizer, err := LoadHuggingFaceTokenizer("test-bpe-registry", "testdata/bpe_tokenizer.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	registry := NewEncoderRegistry()
+	if err := registry.RegisterPrefixEncoding("qwen", tokenizer.Name()); err == nil {
+		t.Error("expected an error for an unregistered tokenizer")
+	}
+	if err := registry.RegisterTokenizer(tokenizer); err != nil {
+		t.Fatal(err)
+	}
+	if err := registry.RegisterPrefixEncoding("qwen", tokenizer.Name()); err != nil {
+		t.Fatal(err)
+	}
+
+	resolved, err := registry.Tokenizer("qwen-turbo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resolved.Name() != tokenizer.Name() {
+		t.Errorf("unexpected tokenizer: %s", resolved.Name())
+	}
+}
+
+// letterBPE creates a BPE model whose merges join every pair of the letters and then the pairs.
+func letterBPE(t testing.TB) *hfBPE {
+	vocab := map[string]int{}
+	var merges []string
+	for l := 'a'; l <= 'z'; l++ {
+		vocab[string(l)] = len(vocab)
+	}
+	for l := 'a'; l <= 'z'; l++ {
+		for r := 'a'; r <= 'z'; r++ {
+			vocab[string(l)+string(r)] = len(vocab)
+			merges = append(merges, string(l)+" "+string(r))
+		}
+	}
+	for _, pair := range []string{"ab", "cd", "ef", "ba", "dc"} {
+		for _, next := range []string{"ab", "cd", "ef", "ba", "dc"} {
+			vocab[pair+next] = len(vocab)
+			merges = append(merges, pair+" "+next)
+		}
+	}
+	data, _ := json.Marshal(map[string]interface{}{"type": "BPE", "vocab": vocab, "merges": merges})
+	var m hfModel
+	if err := json.Unmarshal(data, &m); err != nil {
+		t.Fatal(err)
+	}
+	bpe, err := newHFBPE(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bpe
+}
+
+func TestHuggingFaceBPEMerges(t *testing.T) {
+	bpe := letterBPE(t)
+	tokens := bpe.idToToken()
+	decode := func(ids []int) []string {
+		var out []string
+		for _, id := range ids {
+			out = append(out, tokens[id])
+		}
+		return out
+	}
+
+	// the pairs are merged first, then the pairs of pairs by rank.
+	if got := decode(bpe.tokenize("abcdxyz")); !reflect.DeepEqual(got, []string{"abcd", "xy", "z"}) {
+		t.Errorf("unexpected tokens: %v", got)
+	}
+	// the cached ids are the same.
+	if got := decode(bpe.tokenize("abcdxyz")); !reflect.DeepEqual(got, []string{"abcd", "xy", "z"}) {
+		t.Errorf("unexpected cached tokens: %v", got)
+	}
+	// abcdef is merged as abcd and ef.
+	long := strings.Repeat("abcdef", 1000)
+	if ids := bpe.tokenize(long); len(ids) != 2000 {
+		t.Errorf("got %d ids of the long word, want 2000", len(ids))
+	}
+}
+
+func BenchmarkHuggingFaceBPELongWord(b *testing.B) {
+	bpe := letterBPE(b)
+	// a long unspaced run is a single word, e.g. base64 or the text after a regex timeout.
+	word := strings.Repeat("abcdefghijklmnopqrstuvwxyz", 4000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bpe.tokenize(word)
+	}
+}
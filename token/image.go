@@ -0,0 +1,216 @@
+/*
+Copyright 2024 The corego Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package token
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/bytemind-io/corekit"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// ImageTokenPolicy calculates the prompt tokens of an image for a provider.[图片Token策略]
+type ImageTokenPolicy interface {
+	// NeedSize reports whether the image size is required, so a fixed policy skips the download.
+	NeedSize(detail openai.ImageURLDetail) bool
+	// ImageTokens returns the tokens of an image of width x height pixels.
+	ImageTokens(width, height int, detail openai.ImageURLDetail) int
+}
+
+// OpenAITilePolicy counts the 512px tiles of the image scaled to fit 2048x2048 with
+// its short side at most 768px, a low detail image costs BaseTokens only.
+type OpenAITilePolicy struct {
+	BaseTokens int
+	TileTokens int
+}
+
+var (
+	// OpenAIImagePolicy is the policy of gpt-4o and gpt-4-turbo.
+	OpenAIImagePolicy = OpenAITilePolicy{BaseTokens: 85, TileTokens: 170}
+	// OpenAIMiniImagePolicy is the policy of gpt-4o-mini, which bills 33.3x the tokens of gpt-4o.
+	OpenAIMiniImagePolicy = OpenAITilePolicy{BaseTokens: 2833, TileTokens: 5667}
+)
+
+// NeedSize implements the ImageTokenPolicy interface.
+func (p OpenAITilePolicy) NeedSize(detail openai.ImageURLDetail) bool {
+	return detail != openai.ImageURLDetailLow
+}
+
+// ImageTokens implements the ImageTokenPolicy interface.
+func (p OpenAITilePolicy) ImageTokens(width, height int, detail openai.ImageURLDetail) int {
+	if detail == openai.ImageURLDetailLow {
+		return p.BaseTokens
+	}
+
+	w, h := float64(width), float64(height)
+	if longSide := math.Max(w, h); longSide > 2048 {
+		w, h = w*2048/longSide, h*2048/longSide
+	}
+	if shortSide := math.Min(w, h); shortSide > 768 {
+		w, h = w*768/shortSide, h*768/shortSide
+	}
+	tiles := int(math.Ceil(w/512)) * int(math.Ceil(h/512))
+	return tiles*p.TileTokens + p.BaseTokens
+}
+
+// ClaudeImagePolicy counts (width x height) / 750 of the image scaled to a long edge of
+// at most 1568px, the detail is ignored.
+type ClaudeImagePolicy struct{}
+
+// claudeMaxEdge is the long edge that Claude scales the images down to.
+const claudeMaxEdge = 1568
+
+// NeedSize implements the ImageTokenPolicy interface.
+func (ClaudeImagePolicy) NeedSize(openai.ImageURLDetail) bool {
+	return true
+}
+
+// ImageTokens implements the ImageTokenPolicy interface.
+func (ClaudeImagePolicy) ImageTokens(width, height int, _ openai.ImageURLDetail) int {
+	w, h := float64(width), float64(height)
+	if longSide := math.Max(w, h); longSide > claudeMaxEdge {
+		w, h = math.Round(w*claudeMaxEdge/longSide), math.Round(h*claudeMaxEdge/longSide)
+	}
+	return int(math.Ceil(w * h / 750))
+}
+
+// FixedImagePolicy counts the same tokens for every image.
+type FixedImagePolicy struct {
+	Tokens int
+}
+
+// GeminiImagePolicy is the policy of Gemini, which bills 258 tokens per image.
+var GeminiImagePolicy = FixedImagePolicy{Tokens: 258}
+
+// NeedSize implements the ImageTokenPolicy interface.
+func (FixedImagePolicy) NeedSize(openai.ImageURLDetail) bool {
+	return false
+}
+
+// ImageTokens implements the ImageTokenPolicy interface.
+func (p FixedImagePolicy) ImageTokens(int, int, openai.ImageURLDetail) int {
+	return p.Tokens
+}
+
+// imagePolicyRule is a prefix rule.
+type imagePolicyRule struct {
+	prefix string
+	policy ImageTokenPolicy
+}
+
+// imagePolicies maps the models to their ImageTokenPolicy.
+var imagePolicies = struct {
+	mu       sync.RWMutex
+	models   map[string]ImageTokenPolicy
+	prefixes []imagePolicyRule
+}{models: make(map[string]ImageTokenPolicy)}
+
+func init() {
+	RegisterImagePolicy("glm-4v", FixedImagePolicy{Tokens: 1047})
+	RegisterImagePolicyPrefix("gpt-4o-mini", OpenAIMiniImagePolicy)
+	RegisterImagePolicyPrefix("claude", ClaudeImagePolicy{})
+	RegisterImagePolicyPrefix("anthropic.claude", ClaudeImagePolicy{})
+	RegisterImagePolicyPrefix("gemini", GeminiImagePolicy)
+}
+
+// RegisterImagePolicy sets the ImageTokenPolicy of a model.
+func RegisterImagePolicy(model string, policy ImageTokenPolicy) {
+	imagePolicies.mu.Lock()
+	defer imagePolicies.mu.Unlock()
+	imagePolicies.models[model] = policy
+}
+
+// RegisterImagePolicyPrefix sets the ImageTokenPolicy of the models starting with prefix,
+// the longest matching prefix wins.
+func RegisterImagePolicyPrefix(prefix string, policy ImageTokenPolicy) {
+	imagePolicies.mu.Lock()
+	defer imagePolicies.mu.Unlock()
+
+	for i, rule := range imagePolicies.prefixes {
+		if rule.prefix == prefix {
+			imagePolicies.prefixes[i].policy = policy
+			return
+		}
+	}
+	imagePolicies.prefixes = append(imagePolicies.prefixes, imagePolicyRule{prefix: prefix, policy: policy})
+	sort.SliceStable(imagePolicies.prefixes, func(i, j int) bool {
+		return len(imagePolicies.prefixes[i].prefix) > len(imagePolicies.prefixes[j].prefix)
+	})
+}
+
+// ImagePolicy returns the ImageTokenPolicy of a model, OpenAIImagePolicy by default.
+func ImagePolicy(model string) ImageTokenPolicy {
+	imagePolicies.mu.RLock()
+	defer imagePolicies.mu.RUnlock()
+
+	if policy, ok := imagePolicies.models[model]; ok {
+		return policy
+	}
+	for _, rule := range imagePolicies.prefixes {
+		if strings.HasPrefix(model, rule.prefix) {
+			return rule.policy
+		}
+	}
+	return OpenAIImagePolicy
+}
+
+// CalculateImageBytesToken calculates the chat token for the given image bytes.[计算图片Token]
+func CalculateImageBytesToken(imageBytes []byte, model string) (int, error) {
+	return calculateImageToken(model, openai.ImageURLDetailAuto, func() (image.Config, error) {
+		config, _, err := corekit.GetImageConfig(imageBytes)
+		return config, err
+	})
+}
+
+// CalculateImageToken gets the token number for the given image URL.[计算图片Token]
+func CalculateImageToken(imageUrl *openai.ChatMessageImageURL, model string) (int, error) {
+	return calculateImageToken(model, imageUrl.Detail, func() (image.Config, error) {
+		if strings.HasPrefix(imageUrl.URL, "http") {
+			config, _, err := corekit.DecodeUrlImage(imageUrl.URL)
+			return config, err
+		}
+		config, _, _, err := corekit.DecodeBase64Image(imageUrl.URL)
+		return config, err
+	})
+}
+
+// calculateImageToken applies the policy of the model, the image is decoded only when its size is needed.
+func calculateImageToken(model string, detail openai.ImageURLDetail, decode func() (image.Config, error)) (int, error) {
+	if detail == "" {
+		detail = openai.ImageURLDetailAuto
+	}
+
+	policy := ImagePolicy(model)
+	if !policy.NeedSize(detail) {
+		return policy.ImageTokens(0, 0, detail), nil
+	}
+
+	config, err := decode()
+	if err != nil {
+		return 0, err
+	}
+	if config.Width == 0 || config.Height == 0 {
+		return 0, fmt.Errorf("fail to decode image config")
+	}
+	return policy.ImageTokens(config.Width, config.Height, detail), nil
+}
@@ -0,0 +1,54 @@
+package token
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestImagePolicy(t *testing.T) {
+	tests := []struct {
+		model  string
+		width  int
+		height int
+		detail openai.ImageURLDetail
+		want   int
+	}{
+		{"gpt-4o", 1024, 1024, openai.ImageURLDetailHigh, 765},
+		{"gpt-4o", 2048, 4096, openai.ImageURLDetailHigh, 1105},
+		{"gpt-4o", 4096, 4096, openai.ImageURLDetailLow, 85},
+		{"gpt-4o-mini", 1024, 1024, openai.ImageURLDetailAuto, 25501},
+		{"claude-3-5-sonnet-20240620", 1000, 1000, openai.ImageURLDetailAuto, 1334},
+		{"claude-3-haiku-20240307", 3136, 1568, openai.ImageURLDetailAuto, 1640},
+		{"gemini-1.5-pro", 4096, 4096, openai.ImageURLDetailHigh, 258},
+		{"glm-4v", 0, 0, openai.ImageURLDetailAuto, 1047},
+	}
+	for _, tt := range tests {
+		if got := ImagePolicy(tt.model).ImageTokens(tt.width, tt.height, tt.detail); got != tt.want {
+			t.Errorf("%s %dx%d: got %d, want %d", tt.model, tt.width, tt.height, got, tt.want)
+		}
+	}
+}
+
+func TestCalculateImageBytesToken(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 750, 100))); err != nil {
+		t.Fatal(err)
+	}
+
+	num, err := CalculateImageBytesToken(buf.Bytes(), "claude-3-opus-20240229")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if num != 100 {
+		t.Errorf("got %d, want 100", num)
+	}
+
+	// A fixed policy does not decode the image.
+	if num, err := CalculateImageBytesToken(nil, "gemini-1.5-flash"); err != nil || num != 258 {
+		t.Errorf("got %d, %v", num, err)
+	}
+}
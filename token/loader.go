@@ -0,0 +1,150 @@
+/*
+Copyright 2024 The corego Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package token
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"strconv"
+
+	"github.com/pkoukk/tiktoken-go"
+)
+
+// Config is the configuration of the tokenizer files.
+type Config struct {
+	BpeDir    string `json:",optional,env=TOKEN_BPE_DIR"                  envconfig:"TOKEN_BPE_DIR"`                    // BPE 文件目录
+	Offline   bool   `json:",optional,env=TOKEN_OFFLINE,default=false"    envconfig:"TOKEN_OFFLINE"    default:"false"` // 禁止下载 BPE 文件
+	CacheSize int    `json:",optional,env=TOKEN_CACHE_SIZE,default=0"     envconfig:"TOKEN_CACHE_SIZE" default:"0"`     // Token 计数缓存条数, 0 为不缓存
+	Estimate  bool   `json:",optional,env=TOKEN_ESTIMATE,default=false"   envconfig:"TOKEN_ESTIMATE"   default:"false"` // 未知模型按文字估算 Token
+}
+
+// Validate validates the Config.
+func (c Config) Validate() error {
+	if c.Offline && c.BpeDir == "" {
+		return fmt.Errorf("bpe dir is required in offline mode")
+	}
+	if c.CacheSize < 0 {
+		return fmt.Errorf("cache size must not be negative")
+	}
+	if c.BpeDir != "" {
+		info, err := os.Stat(c.BpeDir)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("bpe dir %s is not a directory", c.BpeDir)
+		}
+	}
+	return nil
+}
+
+// Setup makes the encoders load their BPE files and the token cache as configured.
+func Setup(c Config) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	if c.BpeDir != "" {
+		SetBpeFS(os.DirFS(c.BpeDir), c.Offline)
+	}
+	EnableTokenCache(c.CacheSize)
+	EnableEstimatorFallback(c.Estimate)
+	return nil
+}
+
+// SetBpeFS makes the encoders read their BPE files from fsys, e.g. an embed.FS or os.DirFS.
+// A file is looked up by its name (cl100k_base.tiktoken) or by its tiktoken cache key.
+// Files missing from fsys are downloaded unless offline is set.[离线加载BPE文件]
+//
+//	//go:embed bpe/*.tiktoken
+//	var bpe embed.FS
+//
+//	sub, _ := fs.Sub(bpe, "bpe")
+//	token.SetBpeFS(sub, true)
+func SetBpeFS(fsys fs.FS, offline bool) {
+	loader := &FileBpeLoader{fsys: fsys}
+	if !offline {
+		loader.fallback = tiktoken.NewDefaultBpeLoader()
+	}
+	tiktoken.SetBpeLoader(loader)
+}
+
+// FileBpeLoader loads the BPE ranks from a file system.
+type FileBpeLoader struct {
+	fsys     fs.FS
+	fallback tiktoken.BpeLoader
+}
+
+// NewFileBpeLoader creates a FileBpeLoader reading from fsys, a nil fallback disables the download.
+func NewFileBpeLoader(fsys fs.FS, fallback tiktoken.BpeLoader) *FileBpeLoader {
+	return &FileBpeLoader{fsys: fsys, fallback: fallback}
+}
+
+// LoadTiktokenBpe implements the tiktoken.BpeLoader interface.
+func (l *FileBpeLoader) LoadTiktokenBpe(tiktokenBpeFile string) (map[string]int, error) {
+	names := []string{
+		path.Base(tiktokenBpeFile),
+		fmt.Sprintf("%x", sha1.Sum([]byte(tiktokenBpeFile))),
+	}
+	for _, name := range names {
+		contents, err := fs.ReadFile(l.fsys, name)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return parseBpe(contents)
+	}
+
+	if l.fallback != nil {
+		return l.fallback.LoadTiktokenBpe(tiktokenBpeFile)
+	}
+	return nil, fmt.Errorf("bpe file %s: %w", names[0], fs.ErrNotExist)
+}
+
+// parseBpe parses the ranks of a .tiktoken file, every line is a base64 token and its rank.
+func parseBpe(contents []byte) (map[string]int, error) {
+	ranks := make(map[string]int)
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		parts := bytes.SplitN(scanner.Bytes(), []byte(" "), 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid bpe line %d", line)
+		}
+		token, err := base64.StdEncoding.DecodeString(string(parts[0]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid bpe line %d: %w", line, err)
+		}
+		rank, err := strconv.Atoi(string(bytes.TrimSpace(parts[1])))
+		if err != nil {
+			return nil, fmt.Errorf("invalid bpe line %d: %w", line, err)
+		}
+		ranks[string(token)] = rank
+	}
+	return ranks, scanner.Err()
+}
@@ -0,0 +1,40 @@
+package token
+
+import (
+	"os"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	// the tests must not download the BPE files, testdata holds a tiny cl100k_base.
+	if err := Setup(Config{BpeDir: "testdata", Offline: true}); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+func TestOfflineCounterText(t *testing.T) {
+	num, err := CounterText("hello hello", "gpt-4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// "hello" and " " + "hello"
+	if num != 3 {
+		t.Errorf("expected 3 tokens, got %d", num)
+	}
+}
+
+func TestOfflineMissingEncoding(t *testing.T) {
+	if _, err := CounterText("hello", "gpt-4o"); err == nil {
+		t.Error("expected an error for the missing o200k_base file")
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	if err := (Config{Offline: true}).Validate(); err == nil {
+		t.Error("expected an error without bpe dir")
+	}
+	if err := (Config{BpeDir: "testdata/cl100k_base.tiktoken"}).Validate(); err == nil {
+		t.Error("expected an error for a file as bpe dir")
+	}
+}
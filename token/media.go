@@ -0,0 +1,108 @@
+/*
+Copyright 2024 The corego Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package token
+
+import (
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// imageSizeRatios are the price ratios of the image sizes to the Image price of the model.
+// https://openai.com/api/pricing
+var imageSizeRatios = map[string]map[string]float64{
+	openai.CreateImageModelDallE2: {
+		openai.CreateImageSize256x256:   1,
+		openai.CreateImageSize512x512:   1.125,
+		openai.CreateImageSize1024x1024: 1.25,
+	},
+	openai.CreateImageModelDallE3: {
+		openai.CreateImageSize1024x1024: 1,
+		openai.CreateImageSize1024x1792: 2,
+		openai.CreateImageSize1792x1024: 2,
+	},
+}
+
+// imageHDRatios are the price ratios of the hd quality to the standard quality by size.
+var imageHDRatios = map[string]float64{
+	openai.CreateImageSize1024x1024: 2,
+	openai.CreateImageSize1024x1792: 1.5,
+	openai.CreateImageSize1792x1024: 1.5,
+}
+
+// CalculateImageRequestBilling prices an image generation by model, size, quality and n.[图片生成计费]
+func CalculateImageRequestBilling(req openai.ImageRequest) (*Billing, error) {
+	return calculateImageBilling(req.Model, req.Size, req.Quality, req.N)
+}
+
+// CalculateImageEditBilling prices an image edit by model, size and n.[图片编辑计费]
+func CalculateImageEditBilling(req openai.ImageEditRequest) (*Billing, error) {
+	return calculateImageBilling(req.Model, req.Size, "", req.N)
+}
+
+// CalculateImageVariationBilling prices an image variation by model, size and n.[图片变体计费]
+func CalculateImageVariationBilling(req openai.ImageVariRequest) (*Billing, error) {
+	return calculateImageBilling(req.Model, req.Size, "", req.N)
+}
+
+// calculateImageBilling applies the size and quality ratios to the Image price of the model,
+// the defaults of the API are used for the missing fields.
+func calculateImageBilling(model, size, quality string, n int) (*Billing, error) {
+	if model == "" {
+		model = openai.CreateImageModelDallE2
+	}
+	if size == "" {
+		size = openai.CreateImageSize1024x1024
+	}
+	if n <= 0 {
+		n = 1
+	}
+
+	ratio := 1.0
+	if ratios, ok := imageSizeRatios[model]; ok {
+		sizeRatio, ok := ratios[size]
+		if !ok {
+			return nil, fmt.Errorf("size %s is not supported by %s", size, model)
+		}
+		ratio = sizeRatio
+	}
+	if quality == openai.CreateImageQualityHD {
+		if hdRatio, ok := imageHDRatios[size]; ok {
+			ratio *= hdRatio
+		}
+	}
+
+	cost, err := CalculateCost(model, Usage{Images: n})
+	if err != nil {
+		return nil, err
+	}
+	cost.Image *= ratio
+	cost.Total = cost.Image
+	billing := NewBilling(cost)
+	return &billing, nil
+}
+
+// CalculateSpeechBilling prices a text to speech request by the characters of the input.[语音合成计费]
+func CalculateSpeechBilling(req openai.CreateSpeechRequest) (*Billing, error) {
+	cost, err := CalculateCost(string(req.Model), Usage{Characters: utf8.RuneCountInString(req.Input)})
+	if err != nil {
+		return nil, err
+	}
+	billing := NewBilling(cost)
+	return &billing, nil
+}
@@ -0,0 +1,412 @@
+/*
+Copyright 2024 The corego Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package token
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/zeromicro/go-zero/core/logx"
+	"gopkg.in/yaml.v3"
+)
+
+// ModelPrice is the price of a model in USD, a zero price is free. A nil price of a token bucket
+// falls back to the Input or Output price it belongs to, PriceOf(0) makes the bucket free.
+type ModelPrice struct {
+	Input       float64  `json:"input,omitempty"        yaml:"input,omitempty"`        // $ / 1M 输入 token
+	CachedInput *float64 `json:"cached_input,omitempty" yaml:"cached_input,omitempty"` // $ / 1M 缓存命中 token, 未设置时按 Input 计费
+	CacheWrite  *float64 `json:"cache_write,omitempty"  yaml:"cache_write,omitempty"`  // $ / 1M 写入缓存 token, 未设置时按 Input 计费
+	AudioInput  *float64 `json:"audio_input,omitempty"  yaml:"audio_input,omitempty"`  // $ / 1M 输入音频 token, 未设置时按 Input 计费
+	Output      float64  `json:"output,omitempty"       yaml:"output,omitempty"`       // $ / 1M 输出 token
+	Reasoning   *float64 `json:"reasoning,omitempty"    yaml:"reasoning,omitempty"`    // $ / 1M 推理 token, 未设置时按 Output 计费
+	AudioOutput *float64 `json:"audio_output,omitempty" yaml:"audio_output,omitempty"` // $ / 1M 输出音频 token, 未设置时按 Output 计费
+	Image       float64  `json:"image,omitempty"        yaml:"image,omitempty"`        // $ / 张图片
+	AudioSecond float64  `json:"audio_second,omitempty" yaml:"audio_second,omitempty"` // $ / 秒音频
+	Character   float64  `json:"character,omitempty"    yaml:"character,omitempty"`    // $ / 1M 字符
+}
+
+// PriceOf returns the price of a token bucket of ModelPrice.
+func PriceOf(usd float64) *float64 {
+	return &usd
+}
+
+// Validate validates the ModelPrice.
+func (p ModelPrice) Validate() error {
+	prices := map[string]float64{
+		"input":        p.Input,
+		"output":       p.Output,
+		"image":        p.Image,
+		"audio_second": p.AudioSecond,
+		"character":    p.Character,
+	}
+	buckets := map[string]*float64{
+		"cached_input": p.CachedInput,
+		"cache_write":  p.CacheWrite,
+		"audio_input":  p.AudioInput,
+		"reasoning":    p.Reasoning,
+		"audio_output": p.AudioOutput,
+	}
+	for name, price := range buckets {
+		if price != nil {
+			prices[name] = *price
+		}
+	}
+	for name, price := range prices {
+		if price < 0 || math.IsNaN(price) || math.IsInf(price, 0) {
+			return fmt.Errorf("invalid %s price: %v", name, price)
+		}
+	}
+	return nil
+}
+
+// orElse returns price, or fallback if price is not set.
+func orElse(price *float64, fallback float64) float64 {
+	if price == nil {
+		return fallback
+	}
+	return *price
+}
+
+// RatioPrice converts a legacy model ratio to a ModelPrice, 1 === $0.002 / 1K tokens.
+func RatioPrice(ratio float64) ModelPrice {
+	price := ratio * 2
+	return ModelPrice{Input: price, Output: price}
+}
+
+// Usage is the billable usage of a request, the token buckets are included in InputTokens
+// and OutputTokens like the usage of OpenAI.
+type Usage struct {
+	InputTokens       int     `json:"input_tokens,omitempty"`
+	CachedInputTokens int     `json:"cached_input_tokens,omitempty"` // 已包含在 InputTokens 中
+	CacheWriteTokens  int     `json:"cache_write_tokens,omitempty"`  // 已包含在 InputTokens 中
+	AudioInputTokens  int     `json:"audio_input_tokens,omitempty"`  // 已包含在 InputTokens 中
+	OutputTokens      int     `json:"output_tokens,omitempty"`
+	ReasoningTokens   int     `json:"reasoning_tokens,omitempty"`    // 已包含在 OutputTokens 中
+	AudioOutputTokens int     `json:"audio_output_tokens,omitempty"` // 已包含在 OutputTokens 中
+	Images            int     `json:"images,omitempty"`
+	AudioSeconds      float64 `json:"audio_seconds,omitempty"`
+	Characters        int     `json:"characters,omitempty"`
+}
+
+// UsageFromOpenAI converts the usage of a chat completion.
+func UsageFromOpenAI(usage openai.Usage) Usage {
+	return Usage{InputTokens: usage.PromptTokens, OutputTokens: usage.CompletionTokens}
+}
+
+// Cost is the cost breakdown of a request in USD.[费用明细]
+type Cost struct {
+	// Model is the requested model.
+	Model string `json:"model"`
+	// Key is the matched price key, e.g. gpt-4-gizmo-*.
+	Key         string     `json:"key"`
+	Price       ModelPrice `json:"price"`
+	Input       float64    `json:"input"`
+	CachedInput float64    `json:"cached_input"`
+	CacheWrite  float64    `json:"cache_write"`
+	AudioInput  float64    `json:"audio_input"`
+	Output      float64    `json:"output"`
+	Reasoning   float64    `json:"reasoning"`
+	AudioOutput float64    `json:"audio_output"`
+	Image       float64    `json:"image"`
+	Audio       float64    `json:"audio"`
+	Character   float64    `json:"character"`
+	Total       float64    `json:"total"`
+}
+
+// pricingRule is a wildcard rule.
+type pricingRule struct {
+	prefix string
+	key    string
+}
+
+// Pricing is a registry of model prices, it is safe for concurrent use and can be changed at runtime.
+// A key ending with * matches the models starting with the rest of the key, the longest match wins.[模型定价]
+type Pricing struct {
+	mu        sync.RWMutex
+	prices    map[string]ModelPrice
+	wildcards []pricingRule
+}
+
+// NewPricing creates an empty Pricing.
+func NewPricing() *Pricing {
+	return &Pricing{prices: make(map[string]ModelPrice)}
+}
+
+// defaultPricing is the pricing used by the package functions.
+var defaultPricing = newDefaultPricing(defaultModelRatio, defaultModelPrices)
+
+// newDefaultPricing creates the pricing from the ratios overridden by the prices. An invalid key
+// or price is logged and skipped, so a typo can not break the importers of the package.
+func newDefaultPricing(ratios map[string]float64, modelPrices map[string]ModelPrice) *Pricing {
+	prices := make(map[string]ModelPrice, len(ratios)+len(modelPrices))
+	for model, ratio := range ratios {
+		prices[model] = RatioPrice(ratio)
+	}
+	for model, price := range modelPrices {
+		prices[model] = price
+	}
+
+	for key, price := range prices {
+		if err := ValidatePrices(map[string]ModelPrice{key: price}); err != nil {
+			logx.Errorf("skip default price: %v", err)
+			delete(prices, key)
+		}
+	}
+
+	p := NewPricing()
+	_ = p.Load(prices)
+	return p
+}
+
+// DefaultPricing returns the pricing used by the package functions.
+func DefaultPricing() *Pricing {
+	return defaultPricing
+}
+
+// ValidatePriceKey validates a price key, it must not contain spaces or control characters
+// and may only end with a *.
+func ValidatePriceKey(key string) error {
+	if key == "" {
+		return fmt.Errorf("empty price key")
+	}
+	for i, r := range key {
+		if unicode.IsSpace(r) || unicode.IsControl(r) {
+			return fmt.Errorf("invalid price key %q: unexpected %q", key, r)
+		}
+		if r == '*' && i != len(key)-1 {
+			return fmt.Errorf("invalid price key %q: * is only allowed at the end", key)
+		}
+	}
+	return nil
+}
+
+// ValidatePrices validates the keys and prices, all the errors are returned.
+func ValidatePrices(prices map[string]ModelPrice) error {
+	keys := make([]string, 0, len(prices))
+	for key := range prices {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var errs []error
+	for _, key := range keys {
+		if err := ValidatePriceKey(key); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := prices[key].Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", key, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Set sets the price of a model or a wildcard key.
+func (p *Pricing) Set(key string, price ModelPrice) error {
+	return p.Load(map[string]ModelPrice{key: price})
+}
+
+// Load sets the prices, nothing is changed if any of them is invalid.
+func (p *Pricing) Load(prices map[string]ModelPrice) error {
+	if err := ValidatePrices(prices); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for key, price := range prices {
+		if _, ok := p.prices[key]; !ok && strings.HasSuffix(key, "*") {
+			p.wildcards = append(p.wildcards, pricingRule{prefix: strings.TrimSuffix(key, "*"), key: key})
+		}
+		p.prices[key] = price
+	}
+	sort.SliceStable(p.wildcards, func(i, j int) bool {
+		return len(p.wildcards[i].prefix) > len(p.wildcards[j].prefix)
+	})
+	return nil
+}
+
+// LoadJSON sets the prices of a JSON object keyed by model.
+func (p *Pricing) LoadJSON(data []byte) error {
+	var prices map[string]ModelPrice
+	if err := json.Unmarshal(data, &prices); err != nil {
+		return err
+	}
+	return p.Load(prices)
+}
+
+// LoadYAML sets the prices of a YAML mapping keyed by model.
+func (p *Pricing) LoadYAML(data []byte) error {
+	var prices map[string]ModelPrice
+	if err := yaml.Unmarshal(data, &prices); err != nil {
+		return err
+	}
+	return p.Load(prices)
+}
+
+// LoadFile sets the prices of a .json, .yaml or .yml file.
+func (p *Pricing) LoadFile(filename string) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+
+	switch ext := strings.ToLower(filepath.Ext(filename)); ext {
+	case ".json":
+		return p.LoadJSON(data)
+	case ".yaml", ".yml":
+		return p.LoadYAML(data)
+	default:
+		return fmt.Errorf("unsupported pricing file: %s", filename)
+	}
+}
+
+// Delete removes the price of a model or a wildcard key.
+func (p *Pricing) Delete(key string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.prices, key)
+	for i, rule := range p.wildcards {
+		if rule.key == key {
+			p.wildcards = append(p.wildcards[:i], p.wildcards[i+1:]...)
+			break
+		}
+	}
+}
+
+// Price returns the price of a model and the matched key.
+func (p *Pricing) Price(model string) (ModelPrice, string, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if price, ok := p.prices[model]; ok {
+		return price, model, true
+	}
+	for _, rule := range p.wildcards {
+		if strings.HasPrefix(model, rule.prefix) {
+			return p.prices[rule.key], rule.key, true
+		}
+	}
+	return ModelPrice{}, "", false
+}
+
+// Cost calculates the cost of the usage.[计算费用]
+func (p *Pricing) Cost(model string, usage Usage) (Cost, error) {
+	price, key, ok := p.Price(model)
+	if !ok {
+		return Cost{}, fmt.Errorf("no price of model %s", model)
+	}
+
+	// the buckets are taken out of the input and output in order, so they never exceed them.
+	input := usage.InputTokens
+	cached := min(usage.CachedInputTokens, input)
+	input -= cached
+	cacheWrite := min(usage.CacheWriteTokens, input)
+	input -= cacheWrite
+	audioInput := min(usage.AudioInputTokens, input)
+	input -= audioInput
+
+	output := usage.OutputTokens
+	reasoning := min(usage.ReasoningTokens, output)
+	output -= reasoning
+	audioOutput := min(usage.AudioOutputTokens, output)
+	output -= audioOutput
+
+	cost := Cost{
+		Model:       model,
+		Key:         key,
+		Price:       price,
+		Input:       float64(input) * price.Input / 1e6,
+		CachedInput: float64(cached) * orElse(price.CachedInput, price.Input) / 1e6,
+		CacheWrite:  float64(cacheWrite) * orElse(price.CacheWrite, price.Input) / 1e6,
+		AudioInput:  float64(audioInput) * orElse(price.AudioInput, price.Input) / 1e6,
+		Output:      float64(output) * price.Output / 1e6,
+		Reasoning:   float64(reasoning) * orElse(price.Reasoning, price.Output) / 1e6,
+		AudioOutput: float64(audioOutput) * orElse(price.AudioOutput, price.Output) / 1e6,
+		Image:       float64(usage.Images) * price.Image,
+		Audio:       usage.AudioSeconds * price.AudioSecond,
+		Character:   float64(usage.Characters) * price.Character / 1e6,
+	}
+	cost.Total = cost.Input + cost.CachedInput + cost.CacheWrite + cost.AudioInput +
+		cost.Output + cost.Reasoning + cost.AudioOutput + cost.Image + cost.Audio + cost.Character
+	return cost, nil
+}
+
+// CostToQuota converts a cost in USD to quota units, a fraction of a unit is rounded up.
+func CostToQuota(usd float64) int {
+	return int(math.Ceil(usd * USD))
+}
+
+// Billing is the cost and quota of a request, and the amount charged in the currency of the customer.
+type Billing struct {
+	Cost     Cost     `json:"cost"`
+	Quota    int      `json:"quota"`
+	Currency Currency `json:"currency"` // 结算币种
+	Rate     float64  `json:"rate"`     // 结算时 1 USD 兑换的结算币种
+	Amount   float64  `json:"amount"`   // 以结算币种计的费用
+}
+
+// NewBilling creates the Billing of the cost charged in USD.
+func NewBilling(cost Cost) Billing {
+	return Billing{Cost: cost, Quota: CostToQuota(cost.Total), Currency: CurrencyUSD, Rate: 1, Amount: cost.Total}
+}
+
+// Convert charges the Billing in the currency at the current exchange rate, the rate is recorded.
+func (b *Billing) Convert(ctx context.Context, currency Currency) error {
+	rate, err := ExchangeRate(ctx, currency)
+	if err != nil {
+		return err
+	}
+	b.Currency = currency
+	b.Rate = rate
+	b.Amount = b.Cost.Total * rate
+	return nil
+}
+
+// Charge prices the usage with the default pricing and charges it in the currency.[计费]
+func Charge(ctx context.Context, model string, usage Usage, currency Currency) (*Billing, error) {
+	cost, err := CalculateCost(model, usage)
+	if err != nil {
+		return nil, err
+	}
+	billing := NewBilling(cost)
+	if err := billing.Convert(ctx, currency); err != nil {
+		return nil, err
+	}
+	return &billing, nil
+}
+
+// SetModelPrice sets the price of a model or a wildcard key in the default pricing.
+func SetModelPrice(key string, price ModelPrice) error {
+	return defaultPricing.Set(key, price)
+}
+
+// CalculateCost calculates the cost of the usage with the default pricing.[计算费用]
+func CalculateCost(model string, usage Usage) (Cost, error) {
+	return defaultPricing.Cost(model, usage)
+}
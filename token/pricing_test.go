@@ -0,0 +1,151 @@
+package token
+
+import (
+	"math"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestValidatePrices(t *testing.T) {
+	err := ValidatePrices(map[string]ModelPrice{"command-r-plus\t": RatioPrice(1.5), "command-r": RatioPrice(0.25)})
+	if err == nil || err.Error() != `invalid price key "command-r-plus\t": unexpected '\t'` {
+		t.Errorf("unexpected error for the key with a tab: %v", err)
+	}
+	if err := ValidatePrices(map[string]ModelPrice{"gpt-*-all": {}}); err == nil {
+		t.Error("expected an error for the wildcard in the middle")
+	}
+	if err := ValidatePrices(map[string]ModelPrice{"gpt-4o": {Input: -1}}); err == nil {
+		t.Error("expected an error for the negative price")
+	}
+	if _, _, ok := DefaultPricing().Price("command-r-plus"); !ok {
+		t.Error("expected the price of command-r-plus")
+	}
+
+	// the invalid defaults are skipped instead of failing the package initialization.
+	pricing := newDefaultPricing(map[string]float64{"command-r-plus\t": 1.5, "command-r": 0.25}, map[string]ModelPrice{"gpt-4o": {Input: -1}})
+	if _, _, ok := pricing.Price("command-r-plus\t"); ok {
+		t.Error("expected the key with a tab to be skipped")
+	}
+	if _, _, ok := pricing.Price("gpt-4o"); ok {
+		t.Error("expected the negative price to be skipped")
+	}
+	if _, _, ok := pricing.Price("command-r"); !ok {
+		t.Error("expected the price of command-r")
+	}
+}
+
+func TestPricingCost(t *testing.T) {
+	pricing := NewPricing()
+	err := pricing.LoadYAML([]byte(`
+gpt-4-gizmo-*:
+  input: 30
+  output: 60
+gpt-4o-mini:
+  input: 0.15
+  cached_input: 0.075
+  output: 0.6
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cost, err := pricing.Cost("gpt-4-gizmo-g-abc", Usage{InputTokens: 1000, OutputTokens: 500})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cost.Key != "gpt-4-gizmo-*" || math.Abs(cost.Total-0.06) > 1e-12 {
+		t.Errorf("unexpected cost: %+v", cost)
+	}
+
+	cost, err = pricing.Cost("gpt-4o-mini", Usage{InputTokens: 2000000, CachedInputTokens: 1000000, OutputTokens: 1000000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(cost.Input-0.15) > 1e-12 || math.Abs(cost.CachedInput-0.075) > 1e-12 || math.Abs(cost.Total-0.825) > 1e-12 {
+		t.Errorf("unexpected cost: %+v", cost)
+	}
+
+	if err := pricing.Set("gpt-4o-mini", ModelPrice{Input: 1, Output: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if cost, _ := pricing.Cost("gpt-4o-mini", Usage{InputTokens: 1000000}); cost.Total != 1 {
+		t.Errorf("unexpected cost after override: %+v", cost)
+	}
+	if _, err := pricing.Cost("unknown", Usage{}); err == nil {
+		t.Error("expected an error for an unknown model")
+	}
+}
+
+func TestCalculateMediaBilling(t *testing.T) {
+	tests := []struct {
+		req  openai.ImageRequest
+		want float64
+	}{
+		{openai.ImageRequest{}, 0.02},
+		{openai.ImageRequest{Model: openai.CreateImageModelDallE2, Size: openai.CreateImageSize512x512, N: 2}, 0.036},
+		{openai.ImageRequest{Model: openai.CreateImageModelDallE3, Quality: openai.CreateImageQualityHD}, 0.08},
+		{openai.ImageRequest{Model: openai.CreateImageModelDallE3, Size: openai.CreateImageSize1792x1024, Quality: openai.CreateImageQualityHD}, 0.12},
+	}
+	for _, tt := range tests {
+		billing, err := CalculateImageRequestBilling(tt.req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if math.Abs(billing.Cost.Total-tt.want) > 1e-12 {
+			t.Errorf("%+v: got %v, want %v", tt.req, billing.Cost.Total, tt.want)
+		}
+	}
+	if _, err := CalculateImageRequestBilling(openai.ImageRequest{Model: openai.CreateImageModelDallE3, Size: openai.CreateImageSize256x256}); err == nil {
+		t.Error("expected an error for the size")
+	}
+
+	billing, err := CalculateSpeechBilling(openai.CreateSpeechRequest{Model: openai.TTSModel1, Input: "你好, world"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(billing.Cost.Total-9*15/1e6) > 1e-12 || billing.Quota != 1 {
+		t.Errorf("unexpected billing: %+v", billing)
+	}
+}
+
+func TestPricingCostBuckets(t *testing.T) {
+	pricing := NewPricing()
+	price := ModelPrice{Input: 3, CachedInput: PriceOf(0.3), CacheWrite: PriceOf(3.75), Output: 15, Reasoning: PriceOf(30)}
+	if err := pricing.Set("test", price); err != nil {
+		t.Fatal(err)
+	}
+
+	cost, err := pricing.Cost("test", Usage{
+		InputTokens:       4000000,
+		CachedInputTokens: 1000000,
+		CacheWriteTokens:  1000000,
+		OutputTokens:      2000000,
+		ReasoningTokens:   1000000,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Cost{Input: 6, CachedInput: 0.3, CacheWrite: 3.75, Output: 15, Reasoning: 30}
+	if cost.Input != want.Input || cost.CachedInput != want.CachedInput || cost.CacheWrite != want.CacheWrite ||
+		cost.Output != want.Output || cost.Reasoning != want.Reasoning || math.Abs(cost.Total-55.05) > 1e-9 {
+		t.Errorf("unexpected cost: %+v", cost)
+	}
+}
+
+func TestPricingFreeBucket(t *testing.T) {
+	pricing := NewPricing()
+	err := pricing.LoadJSON([]byte(`{"free-cache":{"input":2,"cached_input":0,"output":4},"no-cache":{"input":2,"output":4}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	usage := Usage{InputTokens: 2000000, CachedInputTokens: 1000000}
+	if cost, _ := pricing.Cost("free-cache", usage); cost.CachedInput != 0 || cost.Total != 2 {
+		t.Errorf("expected the cached input to be free: %+v", cost)
+	}
+	// an unset cached price falls back to the input price.
+	if cost, _ := pricing.Cost("no-cache", usage); cost.CachedInput != 2 || cost.Total != 4 {
+		t.Errorf("expected the cached input at the input price: %+v", cost)
+	}
+}
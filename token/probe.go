@@ -0,0 +1,404 @@
+/*
+Copyright 2024 The corego Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package token
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// AudioFormatWAV is the format of WAV audio.
+	AudioFormatWAV = "wav"
+	// AudioFormatMP3 is the format of MP3 audio.
+	AudioFormatMP3 = "mp3"
+	// AudioFormatOpus is the format of Opus in an OGG container.
+	AudioFormatOpus = "opus"
+	// AudioFormatVorbis is the format of Vorbis in an OGG container.
+	AudioFormatVorbis = "ogg"
+	// AudioFormatFLAC is the format of FLAC audio.
+	AudioFormatFLAC = "flac"
+	// AudioFormatM4A is the format of MP4/M4A audio.
+	AudioFormatM4A = "m4a"
+)
+
+// ErrUnknownAudioFormat is returned when the container of the audio is not recognized.
+var ErrUnknownAudioFormat = errors.New("unknown audio format")
+
+// AudioInfo is the probed information of an audio.
+type AudioInfo struct {
+	Format   string        `json:"format"`
+	Duration time.Duration `json:"duration"`
+}
+
+// ProbeAudio reads the duration of WAV, MP3, OGG/Opus, OGG/Vorbis, FLAC and M4A audio from their
+// container headers. MP3 uses the frame count of its Xing, Info or VBRI header, and is measured by
+// scanning its frames when it has none.[音频时长探测]
+func ProbeAudio(data []byte) (*AudioInfo, error) {
+	var (
+		format   string
+		duration time.Duration
+		err      error
+	)
+	switch {
+	case len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WAVE":
+		format = AudioFormatWAV
+		duration, err = probeWAV(data)
+	case len(data) >= 4 && string(data[:4]) == "OggS":
+		format, duration, err = probeOGG(data)
+	case len(data) >= 8 && string(data[4:8]) == "ftyp":
+		format = AudioFormatM4A
+		duration, err = probeMP4(data)
+	default:
+		body := skipID3v2(data)
+		if len(body) >= 4 && string(body[:4]) == "fLaC" {
+			format = AudioFormatFLAC
+			duration, err = probeFLAC(body)
+		} else if _, ok := parseMP3Frame(body); ok {
+			format = AudioFormatMP3
+			duration, err = probeMP3(body)
+		} else {
+			return nil, ErrUnknownAudioFormat
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("probe %s: %w", format, err)
+	}
+	return &AudioInfo{Format: format, Duration: duration}, nil
+}
+
+// seconds converts a number of samples to a duration.
+func seconds(samples uint64, sampleRate uint64) time.Duration {
+	return time.Duration(float64(samples) / float64(sampleRate) * float64(time.Second))
+}
+
+// probeWAV divides the size of the data chunk by the byte rate of the fmt chunk.
+func probeWAV(data []byte) (time.Duration, error) {
+	var byteRate uint32
+	for offset := 12; offset+8 <= len(data); {
+		id := string(data[offset : offset+4])
+		size := int(binary.LittleEndian.Uint32(data[offset+4 : offset+8]))
+		body := offset + 8
+
+		switch id {
+		case "fmt ":
+			if body+12 > len(data) {
+				return 0, fmt.Errorf("truncated fmt chunk")
+			}
+			byteRate = binary.LittleEndian.Uint32(data[body+8 : body+12])
+		case "data":
+			if byteRate == 0 {
+				return 0, fmt.Errorf("missing fmt chunk")
+			}
+			// a streamed WAV may not know the size of its data.
+			if size <= 0 || body+size > len(data) {
+				size = len(data) - body
+			}
+			return seconds(uint64(size), uint64(byteRate)), nil
+		}
+		offset = body + size + size%2
+	}
+	return 0, fmt.Errorf("missing data chunk")
+}
+
+// probeFLAC reads the total samples and sample rate of the STREAMINFO block.
+func probeFLAC(data []byte) (time.Duration, error) {
+	// fLaC, block header, then the sample rate at bit 80 of STREAMINFO.
+	if len(data) < 8+18 || data[4]&0x7f != 0 {
+		return 0, fmt.Errorf("missing streaminfo block")
+	}
+	v := binary.BigEndian.Uint64(data[8+10 : 8+18])
+	sampleRate := v >> 44
+	totalSamples := v & (1<<36 - 1)
+	if sampleRate == 0 {
+		return 0, fmt.Errorf("invalid sample rate")
+	}
+	return seconds(totalSamples, sampleRate), nil
+}
+
+// probeOGG reads the granule position of the last page, the sample rate is read from the
+// identification header of the first page.
+func probeOGG(data []byte) (string, time.Duration, error) {
+	// the page header is followed by a segment table of data[26] bytes.
+	if len(data) < 27 || 27+int(data[26]) > len(data) {
+		return "", 0, fmt.Errorf("truncated page")
+	}
+	packet := data[27+int(data[26]):]
+
+	var (
+		format     string
+		sampleRate uint64
+		preSkip    uint64
+	)
+	switch {
+	case len(packet) >= 12 && string(packet[:8]) == "OpusHead":
+		format = AudioFormatOpus
+		sampleRate = 48000
+		preSkip = uint64(binary.LittleEndian.Uint16(packet[10:12]))
+	case len(packet) >= 16 && string(packet[:7]) == "\x01vorbis":
+		format = AudioFormatVorbis
+		sampleRate = uint64(binary.LittleEndian.Uint32(packet[12:16]))
+	default:
+		return "", 0, ErrUnknownAudioFormat
+	}
+	if sampleRate == 0 {
+		return format, 0, fmt.Errorf("invalid sample rate")
+	}
+
+	for end := len(data); end > 0; {
+		idx := bytes.LastIndex(data[:end], []byte("OggS"))
+		if idx < 0 {
+			break
+		}
+		// the header of a page cut off at the end of the data has no granule position.
+		if idx+14 > len(data) {
+			end = idx
+			continue
+		}
+		// a page without a finished packet has a granule position of -1.
+		granule := binary.LittleEndian.Uint64(data[idx+6 : idx+14])
+		if granule != ^uint64(0) {
+			if granule < preSkip {
+				return format, 0, nil
+			}
+			return format, seconds(granule-preSkip, sampleRate), nil
+		}
+		end = idx
+	}
+	return format, 0, fmt.Errorf("missing granule position")
+}
+
+// probeMP4 reads the duration of the mvhd box in the moov box.
+func probeMP4(data []byte) (time.Duration, error) {
+	moov, ok := findBox(data, "moov")
+	if !ok {
+		return 0, fmt.Errorf("missing moov box")
+	}
+	mvhd, ok := findBox(moov, "mvhd")
+	if !ok || len(mvhd) < 4 {
+		return 0, fmt.Errorf("missing mvhd box")
+	}
+
+	var timescale, duration uint64
+	switch mvhd[0] {
+	case 0:
+		if len(mvhd) < 20 {
+			return 0, fmt.Errorf("truncated mvhd box")
+		}
+		timescale = uint64(binary.BigEndian.Uint32(mvhd[12:16]))
+		duration = uint64(binary.BigEndian.Uint32(mvhd[16:20]))
+	case 1:
+		if len(mvhd) < 32 {
+			return 0, fmt.Errorf("truncated mvhd box")
+		}
+		timescale = uint64(binary.BigEndian.Uint32(mvhd[20:24]))
+		duration = binary.BigEndian.Uint64(mvhd[24:32])
+	default:
+		return 0, fmt.Errorf("unknown mvhd version %d", mvhd[0])
+	}
+	if timescale == 0 {
+		return 0, fmt.Errorf("invalid timescale")
+	}
+	return seconds(duration, timescale), nil
+}
+
+// findBox returns the body of the first box of the type at the top level of data.
+func findBox(data []byte, typ string) ([]byte, bool) {
+	for offset := 0; offset+8 <= len(data); {
+		size := uint64(binary.BigEndian.Uint32(data[offset : offset+4]))
+		header := uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(data) - offset)
+		case 1:
+			if offset+16 > len(data) {
+				return nil, false
+			}
+			size = binary.BigEndian.Uint64(data[offset+8 : offset+16])
+			header = 16
+		}
+		if size < header || uint64(offset)+size > uint64(len(data)) {
+			return nil, false
+		}
+		if string(data[offset+4:offset+8]) == typ {
+			return data[uint64(offset)+header : uint64(offset)+size], true
+		}
+		offset += int(size)
+	}
+	return nil, false
+}
+
+// skipID3v2 skips the ID3v2 tag at the start of data.
+func skipID3v2(data []byte) []byte {
+	if len(data) < 10 || string(data[:3]) != "ID3" {
+		return data
+	}
+	// the size is a syncsafe integer of 7 bits per byte.
+	size := int(data[6]&0x7f)<<21 | int(data[7]&0x7f)<<14 | int(data[8]&0x7f)<<7 | int(data[9]&0x7f)
+	size += 10
+	if data[5]&0x10 != 0 {
+		size += 10
+	}
+	if size > len(data) {
+		return nil
+	}
+	return data[size:]
+}
+
+// mp3Frame is the header of an MPEG audio frame.
+type mp3Frame struct {
+	size       int
+	samples    int
+	sampleRate int
+	// xingOffset is the offset of the Xing header in a layer III frame, 0 for the other layers.
+	xingOffset int
+}
+
+var (
+	// mp3Bitrates are the bitrates in kbps of MPEG-1 layer I, II, III and MPEG-2 layer I, II/III.
+	mp3Bitrates = [5][15]int{
+		{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448},
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384},
+		{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+	}
+	// mp3SampleRates are the sample rates of MPEG-1, MPEG-2 and MPEG-2.5.
+	mp3SampleRates = [3][3]int{
+		{44100, 48000, 32000},
+		{22050, 24000, 16000},
+		{11025, 12000, 8000},
+	}
+)
+
+// parseMP3Frame parses the frame header at the start of data.
+func parseMP3Frame(data []byte) (mp3Frame, bool) {
+	if len(data) < 4 || data[0] != 0xff || data[1]&0xe0 != 0xe0 {
+		return mp3Frame{}, false
+	}
+
+	version := (data[1] >> 3) & 0x03 // 00 MPEG-2.5, 10 MPEG-2, 11 MPEG-1
+	layer := (data[1] >> 1) & 0x03   // 01 layer III, 10 layer II, 11 layer I
+	bitrateIndex := data[2] >> 4
+	sampleRateIndex := (data[2] >> 2) & 0x03
+	padding := int(data[2]>>1) & 0x01
+	if version == 0x01 || layer == 0 || bitrateIndex == 0 || bitrateIndex == 0x0f || sampleRateIndex == 0x03 {
+		return mp3Frame{}, false
+	}
+
+	var (
+		table      int
+		sampleRate int
+	)
+	mpeg1 := version == 0x03
+	switch {
+	case mpeg1:
+		table = int(3 - layer)
+		sampleRate = mp3SampleRates[0][sampleRateIndex]
+	case layer == 0x03:
+		table = 3
+	default:
+		table = 4
+	}
+	if !mpeg1 {
+		if version == 0x02 {
+			sampleRate = mp3SampleRates[1][sampleRateIndex]
+		} else {
+			sampleRate = mp3SampleRates[2][sampleRateIndex]
+		}
+	}
+	bitrate := mp3Bitrates[table][bitrateIndex] * 1000
+
+	frame := mp3Frame{sampleRate: sampleRate}
+	if layer == 0x01 {
+		// the Xing header follows the side information, which is shorter for mono and MPEG-2.
+		mono := data[3]>>6 == 0x03
+		switch {
+		case mpeg1 && !mono:
+			frame.xingOffset = 4 + 32
+		case mpeg1 || !mono:
+			frame.xingOffset = 4 + 17
+		default:
+			frame.xingOffset = 4 + 9
+		}
+	}
+	switch {
+	case layer == 0x03:
+		frame.samples = 384
+		frame.size = (12*bitrate/sampleRate + padding) * 4
+	case layer == 0x01 && !mpeg1:
+		frame.samples = 576
+		frame.size = 72*bitrate/sampleRate + padding
+	default:
+		frame.samples = 1152
+		frame.size = 144*bitrate/sampleRate + padding
+	}
+	return frame, frame.size > 4
+}
+
+// vbrFrames returns the number of frames of the Xing, Info or VBRI header in the first frame.
+func vbrFrames(frame mp3Frame, data []byte) (uint64, bool) {
+	if frame.xingOffset == 0 {
+		return 0, false
+	}
+	if offset := frame.xingOffset; offset+12 <= len(data) {
+		tag := string(data[offset : offset+4])
+		// the frame count is present when bit 0 of the flags is set.
+		if (tag == "Xing" || tag == "Info") && binary.BigEndian.Uint32(data[offset+4:offset+8])&0x01 != 0 {
+			return uint64(binary.BigEndian.Uint32(data[offset+8 : offset+12])), true
+		}
+	}
+	// the VBRI header is always 32 bytes after the frame header.
+	if offset := 4 + 32; offset+18 <= len(data) && string(data[offset:offset+4]) == "VBRI" {
+		return uint64(binary.BigEndian.Uint32(data[offset+14 : offset+18])), true
+	}
+	return 0, false
+}
+
+// probeMP3 reads the frame count of the VBR header of the first frame, otherwise it sums the
+// samples of the frames, junk between the frames is skipped.
+func probeMP3(data []byte) (time.Duration, error) {
+	var (
+		duration time.Duration
+		frames   int
+	)
+	for offset := 0; offset+4 <= len(data); {
+		frame, ok := parseMP3Frame(data[offset:])
+		if !ok || offset+frame.size > len(data) && frames == 0 {
+			if string(data[offset:min(offset+3, len(data))]) == "TAG" {
+				break
+			}
+			offset++
+			continue
+		}
+		if frames == 0 && offset+frame.size <= len(data) {
+			if n, ok := vbrFrames(frame, data[offset:offset+frame.size]); ok && n > 0 {
+				return seconds(n*uint64(frame.samples), uint64(frame.sampleRate)), nil
+			}
+		}
+		duration += seconds(uint64(frame.samples), uint64(frame.sampleRate))
+		frames++
+		offset += frame.size
+	}
+	if frames == 0 {
+		return 0, fmt.Errorf("no frames")
+	}
+	return duration, nil
+}
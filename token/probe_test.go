@@ -0,0 +1,152 @@
+package token
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func wavAudio(byteRate uint32, dataSize int) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("RIFF")
+	binary.Write(&buf, binary.LittleEndian, uint32(36+dataSize))
+	buf.WriteString("WAVEfmt ")
+	binary.Write(&buf, binary.LittleEndian, uint32(16))
+	binary.Write(&buf, binary.LittleEndian, []uint16{1, 1})
+	binary.Write(&buf, binary.LittleEndian, []uint32{byteRate / 2, byteRate})
+	binary.Write(&buf, binary.LittleEndian, []uint16{2, 16})
+	buf.WriteString("data")
+	binary.Write(&buf, binary.LittleEndian, uint32(dataSize))
+	buf.Write(make([]byte, dataSize))
+	return buf.Bytes()
+}
+
+func mp3Audio(frames int) []byte {
+	// ID3v2 tag of 4 bytes.
+	buf := bytes.NewBuffer([]byte{'I', 'D', '3', 4, 0, 0, 0, 0, 0, 4, 0, 0, 0, 0})
+	for i := 0; i < frames; i++ {
+		// MPEG-1 layer III, 128 kbps, 44100 Hz, 417 bytes.
+		frame := make([]byte, 417)
+		copy(frame, []byte{0xff, 0xfb, 0x90, 0x00})
+		buf.Write(frame)
+	}
+	buf.WriteString("TAG")
+	buf.Write(make([]byte, 125))
+	return buf.Bytes()
+}
+
+// xingMP3 creates an MP3 whose Xing header claims more frames than it has.
+func xingMP3(frames uint32) []byte {
+	data := mp3Audio(2)
+	// MPEG-1 stereo, the Xing header is after 4 bytes of frame header and 32 of side information.
+	xing := append([]byte("Xing"), 0, 0, 0, 0x01)
+	copy(data[14+36:], binary.BigEndian.AppendUint32(xing, frames))
+	return data
+}
+
+func oggPage(granule uint64, packet []byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("OggS")
+	buf.Write([]byte{0, 0})
+	binary.Write(&buf, binary.LittleEndian, granule)
+	buf.Write(make([]byte, 12))
+	buf.Write([]byte{1, byte(len(packet))})
+	buf.Write(packet)
+	return buf.Bytes()
+}
+
+func opusAudio(samples uint64) []byte {
+	head := []byte("OpusHead\x01\x01")
+	head = binary.LittleEndian.AppendUint16(head, 312)
+	head = append(head, make([]byte, 7)...)
+
+	data := oggPage(0, head)
+	data = append(data, oggPage(samples+312, []byte("audio"))...)
+	return append(data, oggPage(^uint64(0), []byte("audio"))...)
+}
+
+func flacAudio(sampleRate, samples uint64) []byte {
+	data := []byte("fLaC")
+	data = append(data, 0x80, 0, 0, 34)
+	data = append(data, make([]byte, 10)...)
+	data = binary.BigEndian.AppendUint64(data, sampleRate<<44|1<<36|samples)
+	return append(data, make([]byte, 16)...)
+}
+
+func mp4Box(typ string, body []byte) []byte {
+	box := binary.BigEndian.AppendUint32(nil, uint32(8+len(body)))
+	return append(append(box, typ...), body...)
+}
+
+func m4aAudio(timescale, duration uint32) []byte {
+	mvhd := make([]byte, 12)
+	mvhd = binary.BigEndian.AppendUint32(mvhd, timescale)
+	mvhd = binary.BigEndian.AppendUint32(mvhd, duration)
+	mvhd = append(mvhd, make([]byte, 80)...)
+
+	data := mp4Box("ftyp", []byte("M4A \x00\x00\x00\x00"))
+	data = append(data, mp4Box("free", nil)...)
+	return append(data, mp4Box("moov", mp4Box("mvhd", mvhd))...)
+}
+
+func TestProbeAudio(t *testing.T) {
+	tests := []struct {
+		data     []byte
+		format   string
+		duration time.Duration
+	}{
+		{wavAudio(8000, 16000), AudioFormatWAV, 2 * time.Second},
+		{mp3Audio(100), AudioFormatMP3, 100 * 1152 * time.Second / 44100},
+		{xingMP3(1000), AudioFormatMP3, 1000 * 1152 * time.Second / 44100},
+		{opusAudio(3 * 48000), AudioFormatOpus, 3 * time.Second},
+		{append(opusAudio(2*48000), "OggS\x00\x04"...), AudioFormatOpus, 2 * time.Second}, // a cut off last page
+		{flacAudio(44100, 88200), AudioFormatFLAC, 2 * time.Second},
+		{m4aAudio(1000, 5500), AudioFormatM4A, 5500 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		info, err := ProbeAudio(tt.data)
+		if err != nil {
+			t.Errorf("%s: %v", tt.format, err)
+			continue
+		}
+		if info.Format != tt.format || (info.Duration-tt.duration).Abs() > time.Millisecond {
+			t.Errorf("%s: got %s %v, want %v", tt.format, info.Format, info.Duration, tt.duration)
+		}
+	}
+
+	if _, err := ProbeAudio([]byte("not audio")); err != ErrUnknownAudioFormat {
+		t.Errorf("expected ErrUnknownAudioFormat, got %v", err)
+	}
+}
+
+func TestProbeAudioTruncated(t *testing.T) {
+	ogg := append([]byte("OggS"), make([]byte, 26)...)
+	ogg[26] = 200
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"wav", wavAudio(8000, 16000)[:40]},
+		{"mp3", mp3Audio(1)[:20]},
+		{"ogg segment table", ogg},
+		{"ogg", opusAudio(48000)[:30]},
+		{"flac", flacAudio(44100, 88200)[:20]},
+		{"m4a", m4aAudio(1000, 5500)[:40]},
+	}
+	for _, tt := range tests {
+		if info, err := ProbeAudio(tt.data); err == nil {
+			t.Errorf("%s: expected an error, got %+v", tt.name, info)
+		}
+	}
+}
+
+func TestCalculateAudioBilling(t *testing.T) {
+	billing, err := CalculateAudioBilling(wavAudio(8000, 20000), "whisper-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if billing.Seconds != 3 || billing.Quota != 1 {
+		t.Errorf("unexpected billing: %+v", billing)
+	}
+}
@@ -17,7 +17,8 @@ limitations under the License.
 package token
 
 const (
-	// USD2RMB is the exchange rate from USD to RMB.
+	// USD2RMB is the exchange rate from USD to RMB of the prices, and the default rate of CNY,
+	// see SetExchangeRateProvider.
 	USD2RMB = 7.3
 	// USD $0.002 = 1 -> $1 = 500
 	USD = 500
@@ -25,6 +26,8 @@ const (
 	RMB = USD / USD2RMB
 )
 
+// defaultModelRatio is the legacy single-number price of the models, it is converted by RatioPrice
+// and overridden by defaultModelPrices.
 // https://platform.openai.com/docs/models/model-endpoint-compatibility
 // https://openai.com/pricing | 1 === $0.002 / 1K tokens | 1 === ￥0.014 / 1k tokens
 var defaultModelRatio = map[string]float64{
@@ -145,7 +148,7 @@ var defaultModelRatio = map[string]float64{
 	"command-light":                  0.5,
 	"command-light-nightly":          0.5,
 	"command-r":                      0.25,
-	"command-r-plus	":                1.5,
+	"command-r-plus":                 1.5,
 	"deepseek-chat":                  0.07,
 	"deepseek-coder":                 0.07,
 	"llama-3-sonar-small-32k-chat":   0.2 / 1000 * USD,
@@ -153,3 +156,50 @@ var defaultModelRatio = map[string]float64{
 	"llama-3-sonar-large-32k-chat":   1 / 1000 * USD,
 	"llama-3-sonar-large-32k-online": 1 / 1000 * USD,
 }
+
+// defaultModelPrices are the models whose input, output, image, audio or character prices differ.
+// https://openai.com/api/pricing | https://www.anthropic.com/pricing
+var defaultModelPrices = map[string]ModelPrice{
+	"gpt-4":                      {Input: 30, Output: 60},
+	"gpt-4-0314":                 {Input: 30, Output: 60},
+	"gpt-4-0613":                 {Input: 30, Output: 60},
+	"gpt-4-32k":                  {Input: 60, Output: 120},
+	"gpt-4-32k-0314":             {Input: 60, Output: 120},
+	"gpt-4-32k-0613":             {Input: 60, Output: 120},
+	"gpt-4-1106-preview":         {Input: 10, Output: 30},
+	"gpt-4-0125-preview":         {Input: 10, Output: 30},
+	"gpt-4-turbo-preview":        {Input: 10, Output: 30},
+	"gpt-4-vision-preview":       {Input: 10, Output: 30},
+	"gpt-4-1106-vision-preview":  {Input: 10, Output: 30},
+	"gpt-4-turbo":                {Input: 10, Output: 30},
+	"gpt-4-turbo-2024-04-09":     {Input: 10, Output: 30},
+	"gpt-4o":                     {Input: 5, Output: 15},
+	"gpt-4o-2024-05-13":          {Input: 5, Output: 15},
+	"gpt-4o-2024-08-06":          {Input: 2.5, CachedInput: PriceOf(1.25), Output: 10},
+	"gpt-4o-mini":                {Input: 0.15, CachedInput: PriceOf(0.075), Output: 0.6},
+	"gpt-4o-mini-2024-07-18":     {Input: 0.15, CachedInput: PriceOf(0.075), Output: 0.6},
+	"gpt-3.5-turbo":              {Input: 0.5, Output: 1.5},
+	"gpt-3.5-turbo-0613":         {Input: 1.5, Output: 2},
+	"gpt-3.5-turbo-16k":          {Input: 3, Output: 4},
+	"gpt-3.5-turbo-16k-0613":     {Input: 3, Output: 4},
+	"gpt-3.5-turbo-instruct":     {Input: 1.5, Output: 2},
+	"gpt-3.5-turbo-1106":         {Input: 1, Output: 2},
+	"gpt-3.5-turbo-0125":         {Input: 0.5, Output: 1.5},
+	"o1-preview":                 {Input: 15, CachedInput: PriceOf(7.5), Output: 60},
+	"o1-mini":                    {Input: 3, CachedInput: PriceOf(1.5), Output: 12},
+	"gpt-4o-audio-preview":       {Input: 2.5, AudioInput: PriceOf(100), Output: 10, AudioOutput: PriceOf(200)},
+	"whisper-1":                  {AudioSecond: 0.006 / 60},
+	"tts-1":                      {Character: 15},
+	"tts-1-1106":                 {Character: 15},
+	"tts-1-hd":                   {Character: 30},
+	"tts-1-hd-1106":              {Character: 30},
+	"dall-e-2":                   {Image: 0.016}, // 256x256, see imageSizeRatios
+	"dall-e-3":                   {Image: 0.04},  // 1024x1024 standard
+	"claude-instant-1":           {Input: 0.8, Output: 2.4},
+	"claude-2.0":                 {Input: 8, Output: 24},
+	"claude-2.1":                 {Input: 8, Output: 24},
+	"claude-3-haiku-20240307":    {Input: 0.25, CachedInput: PriceOf(0.03), CacheWrite: PriceOf(0.3), Output: 1.25},
+	"claude-3-sonnet-20240229":   {Input: 3, Output: 15},
+	"claude-3-5-sonnet-20240620": {Input: 3, CachedInput: PriceOf(0.3), CacheWrite: PriceOf(3.75), Output: 15},
+	"claude-3-opus-20240229":     {Input: 15, CachedInput: PriceOf(1.5), CacheWrite: PriceOf(18.75), Output: 75},
+}
@@ -0,0 +1,277 @@
+/*
+Copyright 2024 The corego Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package token
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/zeromicro/go-zero/core/logx"
+)
+
+const (
+	// DefaultDriftThreshold is the relative drift logged as a mismatch.
+	DefaultDriftThreshold = 0.1
+	// maxDriftSamples is the number of recent drifts kept per model for the percentiles.
+	maxDriftSamples = 1000
+)
+
+// UsageSource is the side of the usage that is authoritative.
+type UsageSource string
+
+const (
+	// UsageUpstream is the usage reported by the upstream.
+	UsageUpstream UsageSource = "upstream"
+	// UsageLocal is the usage counted by the tokenizers.
+	UsageLocal UsageSource = "local"
+)
+
+// DriftStats are the statistics of the drift of the local tokens to the upstream tokens,
+// a drift is (local - upstream) / upstream.
+type DriftStats struct {
+	Samples  int     `json:"samples"`
+	Mean     float64 `json:"mean"`     // 平均偏差, 正数表示本地多算
+	P95      float64 `json:"p95"`      // 最近样本偏差绝对值的 p95
+	Exceeded int     `json:"exceeded"` // 偏差超过阈值的次数
+}
+
+// ModelDrift is the drift of the prompt and completion tokens of a model.
+type ModelDrift struct {
+	Prompt     DriftStats `json:"prompt"`
+	Completion DriftStats `json:"completion"`
+}
+
+// driftRecorder accumulates the drifts of a kind of tokens.
+type driftRecorder struct {
+	samples  int
+	sum      float64
+	exceeded int
+	recent   []float64 // ring buffer of the absolute drifts
+	next     int
+}
+
+// add records a drift.
+func (r *driftRecorder) add(drift float64, exceeded bool) {
+	r.samples++
+	r.sum += drift
+	if exceeded {
+		r.exceeded++
+	}
+	if len(r.recent) < maxDriftSamples {
+		r.recent = append(r.recent, math.Abs(drift))
+		return
+	}
+	r.recent[r.next] = math.Abs(drift)
+	r.next = (r.next + 1) % maxDriftSamples
+}
+
+// stats returns the statistics of the recorded drifts.
+func (r *driftRecorder) stats() DriftStats {
+	stats := DriftStats{Samples: r.samples, Exceeded: r.exceeded}
+	if r.samples == 0 {
+		return stats
+	}
+	stats.Mean = r.sum / float64(r.samples)
+
+	recent := append([]float64(nil), r.recent...)
+	sort.Float64s(recent)
+	stats.P95 = recent[int(math.Ceil(0.95*float64(len(recent))))-1]
+	return stats
+}
+
+// modelDrift is the recorders of a model.
+type modelDrift struct {
+	prompt     driftRecorder
+	completion driftRecorder
+}
+
+// Reconciler compares the usage reported by the upstream with the usage counted locally per model,
+// it records the drift and picks the authoritative usage.[用量对账]
+type Reconciler struct {
+	threshold float64
+
+	mu          sync.Mutex
+	authorities map[string]UsageSource
+	prefixes    []prefixAuthority
+	drifts      map[string]*modelDrift
+}
+
+// prefixAuthority is the authority of the models starting with the prefix.
+type prefixAuthority struct {
+	prefix string
+	source UsageSource
+}
+
+// NewReconciler creates a Reconciler that logs a drift above threshold, DefaultDriftThreshold
+// if threshold <= 0. The upstream usage is authoritative by default.
+func NewReconciler(threshold float64) *Reconciler {
+	if threshold <= 0 {
+		threshold = DefaultDriftThreshold
+	}
+	return &Reconciler{
+		threshold:   threshold,
+		authorities: make(map[string]UsageSource),
+		drifts:      make(map[string]*modelDrift),
+	}
+}
+
+// SetAuthority sets the authoritative usage of the model.
+func (r *Reconciler) SetAuthority(model string, source UsageSource) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.authorities[model] = source
+}
+
+// SetAuthorityPrefix sets the authoritative usage of the models starting with the prefix,
+// the longest prefix wins.
+func (r *Reconciler) SetAuthorityPrefix(prefix string, source UsageSource) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.prefixes {
+		if r.prefixes[i].prefix == prefix {
+			r.prefixes[i].source = source
+			return
+		}
+	}
+	r.prefixes = append(r.prefixes, prefixAuthority{prefix: prefix, source: source})
+	sort.SliceStable(r.prefixes, func(i, j int) bool {
+		return len(r.prefixes[i].prefix) > len(r.prefixes[j].prefix)
+	})
+}
+
+// Authority returns the authoritative usage of the model.
+func (r *Reconciler) Authority(model string) UsageSource {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.authority(model)
+}
+
+// authority returns the authoritative usage of the model, r.mu must be held.
+func (r *Reconciler) authority(model string) UsageSource {
+	if source, ok := r.authorities[model]; ok {
+		return source
+	}
+	for _, p := range r.prefixes {
+		if strings.HasPrefix(model, p.prefix) {
+			return p.source
+		}
+	}
+	return UsageUpstream
+}
+
+// usageMismatch is a drift above the threshold, it is logged once r.mu is released.
+type usageMismatch struct {
+	kind     string
+	upstream int
+	local    int
+	drift    float64
+}
+
+// Reconcile records the drift of the local usage to the upstream usage and returns the
+// authoritative one, a drift above the threshold is logged with the request context.
+// The local usage is returned if the upstream reported none, the fields the upstream left
+// at 0 are filled from the local usage.
+func (r *Reconciler) Reconcile(ctx context.Context, model string, upstream, local openai.Usage) openai.Usage {
+	if upstream.PromptTokens == 0 && upstream.CompletionTokens == 0 {
+		return local
+	}
+
+	var mismatches []usageMismatch
+	r.mu.Lock()
+	drift, ok := r.drifts[model]
+	if !ok {
+		drift = &modelDrift{}
+		r.drifts[model] = drift
+	}
+	mismatches = r.record(mismatches, &drift.prompt, "prompt", upstream.PromptTokens, local.PromptTokens)
+	mismatches = r.record(mismatches, &drift.completion, "completion", upstream.CompletionTokens, local.CompletionTokens)
+	source := r.authority(model)
+	r.mu.Unlock()
+
+	for _, m := range mismatches {
+		logx.WithContext(ctx).Infow("token usage mismatch",
+			logx.Field("model", model),
+			logx.Field("kind", m.kind),
+			logx.Field("upstream", m.upstream),
+			logx.Field("local", m.local),
+			logx.Field("drift", m.drift),
+		)
+	}
+
+	if source == UsageLocal {
+		return local
+	}
+	return fillUsage(upstream, local)
+}
+
+// fillUsage fills the tokens the upstream reported as 0 from the local usage.
+func fillUsage(upstream, local openai.Usage) openai.Usage {
+	filled := upstream.TotalTokens == 0
+	if upstream.PromptTokens == 0 {
+		upstream.PromptTokens = local.PromptTokens
+		filled = true
+	}
+	if upstream.CompletionTokens == 0 {
+		upstream.CompletionTokens = local.CompletionTokens
+		filled = true
+	}
+	if filled {
+		upstream.TotalTokens = upstream.PromptTokens + upstream.CompletionTokens
+	}
+	return upstream
+}
+
+// record records the drift of a kind of tokens and appends it to mismatches if it exceeds
+// the threshold, r.mu must be held.
+func (r *Reconciler) record(mismatches []usageMismatch, recorder *driftRecorder, kind string, upstream, local int) []usageMismatch {
+	if upstream <= 0 {
+		return mismatches
+	}
+	drift := float64(local-upstream) / float64(upstream)
+	exceeded := math.Abs(drift) > r.threshold
+	recorder.add(drift, exceeded)
+	if exceeded {
+		mismatches = append(mismatches, usageMismatch{kind: kind, upstream: upstream, local: local, drift: drift})
+	}
+	return mismatches
+}
+
+// Drift returns the drift statistics of the model.
+func (r *Reconciler) Drift(model string) (ModelDrift, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	drift, ok := r.drifts[model]
+	if !ok {
+		return ModelDrift{}, false
+	}
+	return ModelDrift{Prompt: drift.prompt.stats(), Completion: drift.completion.stats()}, true
+}
+
+// Drifts returns the drift statistics of all the reconciled models.
+func (r *Reconciler) Drifts() map[string]ModelDrift {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	drifts := make(map[string]ModelDrift, len(r.drifts))
+	for model, drift := range r.drifts {
+		drifts[model] = ModelDrift{Prompt: drift.prompt.stats(), Completion: drift.completion.stats()}
+	}
+	return drifts
+}
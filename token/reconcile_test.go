@@ -0,0 +1,68 @@
+package token
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestReconciler(t *testing.T) {
+	r := NewReconciler(0)
+	r.SetAuthorityPrefix("claude", UsageLocal)
+
+	ctx := context.Background()
+	upstream := openai.Usage{PromptTokens: 100, CompletionTokens: 10, TotalTokens: 110}
+	for i := 0; i < 19; i++ {
+		r.Reconcile(ctx, "gpt-4o", upstream, openai.Usage{PromptTokens: 100, CompletionTokens: 10, TotalTokens: 110})
+	}
+	usage := r.Reconcile(ctx, "gpt-4o", upstream, openai.Usage{PromptTokens: 120, CompletionTokens: 10, TotalTokens: 130})
+	if usage != upstream {
+		t.Errorf("expected the upstream usage, got %+v", usage)
+	}
+
+	drift, ok := r.Drift("gpt-4o")
+	if !ok {
+		t.Fatal("expected the drift of gpt-4o")
+	}
+	if drift.Prompt.Samples != 20 || drift.Prompt.Exceeded != 1 || math.Abs(drift.Prompt.Mean-0.01) > 1e-12 || drift.Prompt.P95 != 0 {
+		t.Errorf("unexpected prompt drift: %+v", drift.Prompt)
+	}
+
+	local := openai.Usage{PromptTokens: 90, CompletionTokens: 10, TotalTokens: 100}
+	if usage := r.Reconcile(ctx, "claude-3-5-sonnet", upstream, local); usage != local {
+		t.Errorf("expected the local usage, got %+v", usage)
+	}
+	if usage := r.Reconcile(ctx, "gpt-4o", openai.Usage{}, local); usage != local {
+		t.Errorf("expected the local usage without upstream, got %+v", usage)
+	}
+}
+
+func TestReconcilerFillUsage(t *testing.T) {
+	r := NewReconciler(0)
+	ctx := context.Background()
+	local := openai.Usage{PromptTokens: 90, CompletionTokens: 12, TotalTokens: 102}
+
+	tests := []struct {
+		name     string
+		upstream openai.Usage
+		want     openai.Usage
+	}{
+		{"complete", openai.Usage{PromptTokens: 100, CompletionTokens: 10, TotalTokens: 111},
+			openai.Usage{PromptTokens: 100, CompletionTokens: 10, TotalTokens: 111}},
+		{"no prompt", openai.Usage{CompletionTokens: 10, TotalTokens: 10},
+			openai.Usage{PromptTokens: 90, CompletionTokens: 10, TotalTokens: 100}},
+		{"no completion", openai.Usage{PromptTokens: 100, TotalTokens: 100},
+			openai.Usage{PromptTokens: 100, CompletionTokens: 12, TotalTokens: 112}},
+		{"no total", openai.Usage{PromptTokens: 100, CompletionTokens: 10},
+			openai.Usage{PromptTokens: 100, CompletionTokens: 10, TotalTokens: 110}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if usage := r.Reconcile(ctx, "gpt-4o", tt.upstream, local); usage != tt.want {
+				t.Errorf("got %+v, want %+v", usage, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,354 @@
+/*
+Copyright 2024 The corego Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package token
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkoukk/tiktoken-go"
+)
+
+const (
+	// RuleAlias is the resolution rule of a model registered as an alias.
+	RuleAlias = "alias"
+	// RuleExact is the resolution rule of a model registered by its full name.
+	RuleExact = "exact"
+	// RulePrefix is the resolution rule of a model matching a registered prefix.
+	RulePrefix = "prefix"
+	// RuleDefault is the resolution rule of an unknown model.
+	RuleDefault = "default"
+)
+
+// maxAliasDepth limits the alias chain, so a misconfigured loop can not hang the lookup.
+const maxAliasDepth = 8
+
+// supportedEncodings are the encodings known by tiktoken, other encodings are registered tokenizers.
+var supportedEncodings = map[string]bool{
+	tiktoken.MODEL_O200K_BASE:  true,
+	tiktoken.MODEL_CL100K_BASE: true,
+	tiktoken.MODEL_P50K_BASE:   true,
+	tiktoken.MODEL_P50K_EDIT:   true,
+	tiktoken.MODEL_R50K_BASE:   true,
+}
+
+// EncodingResolution describes how the encoding of a model was resolved, it is meant for debugging.
+type EncodingResolution struct {
+	// Model is the requested model.
+	Model string `json:"model"`
+	// Target is the model after following the aliases.
+	Target string `json:"target"`
+	// Encoding is the resolved encoding.
+	Encoding string `json:"encoding"`
+	// Rule is the rule that matched, one of RuleAlias, RuleExact, RulePrefix and RuleDefault.
+	Rule string `json:"rule"`
+	// Prefix is the matched prefix of RulePrefix.
+	Prefix string `json:"prefix,omitempty"`
+}
+
+// EncodingConfig is the encoding configuration of models, e.g. loaded from the admin config.
+type EncodingConfig struct {
+	// Models maps a model name to an encoding.
+	Models map[string]string `json:"models,omitempty"`
+	// Prefixes maps a model name prefix to an encoding.
+	Prefixes map[string]string `json:"prefixes,omitempty"`
+	// Aliases maps a model name to another model name.
+	Aliases map[string]string `json:"aliases,omitempty"`
+}
+
+// encodingRule is a prefix rule.
+type encodingRule struct {
+	prefix   string
+	encoding string
+}
+
+// EncoderRegistry resolves the tokenizer of a model, it is safe for concurrent use
+// and can be changed at runtime.[模型编码注册表]
+type EncoderRegistry struct {
+	mu         sync.RWMutex
+	models     map[string]string
+	aliases    map[string]string
+	prefixes   []encodingRule
+	fallback   string
+	tokenizers map[string]Tokenizer
+	loadMu     sync.Mutex
+}
+
+// NewEncoderRegistry creates an empty EncoderRegistry, unknown models fall back to cl100k_base.
+func NewEncoderRegistry() *EncoderRegistry {
+	return &EncoderRegistry{
+		models:     make(map[string]string),
+		aliases:    make(map[string]string),
+		fallback:   tiktoken.MODEL_CL100K_BASE,
+		tokenizers: make(map[string]Tokenizer),
+	}
+}
+
+// defaultRegistry is the registry used by the package functions.
+var defaultRegistry = newDefaultEncoderRegistry()
+
+// newDefaultEncoderRegistry creates the registry with the tiktoken presets and ModelToEncoding.
+func newDefaultEncoderRegistry() *EncoderRegistry {
+	r := NewEncoderRegistry()
+
+	//预设的模型编码
+	for model, encoding := range tiktoken.MODEL_TO_ENCODING {
+		_ = r.RegisterModelEncoding(model, encoding)
+	}
+	for prefix, encoding := range tiktoken.MODEL_PREFIX_TO_ENCODING {
+		_ = r.RegisterPrefixEncoding(prefix, encoding)
+	}
+	_ = r.RegisterPrefixEncoding("gpt-4o", tiktoken.MODEL_O200K_BASE)
+
+	//自定义的模型编码
+	for model, encoding := range ModelToEncoding {
+		_ = r.RegisterModelEncoding(model, encoding)
+	}
+	return r
+}
+
+// DefaultEncoderRegistry returns the registry used by the package functions.
+func DefaultEncoderRegistry() *EncoderRegistry {
+	return defaultRegistry
+}
+
+// validateEncoding validates the encoding name, it is a tiktoken encoding or a registered tokenizer.
+func (r *EncoderRegistry) validateEncoding(encoding string) error {
+	if supportedEncodings[encoding] {
+		return nil
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if _, ok := r.tokenizers[encoding]; !ok {
+		return fmt.Errorf("unknown encoding: %s", encoding)
+	}
+	return nil
+}
+
+// RegisterModelEncoding registers the encoding of a model.
+func (r *EncoderRegistry) RegisterModelEncoding(model, encoding string) error {
+	if model == "" {
+		return fmt.Errorf("model is required")
+	}
+	if err := r.validateEncoding(encoding); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.models[model] = encoding
+	return nil
+}
+
+// RegisterPrefixEncoding registers the encoding of all models starting with prefix,
+// the longest matching prefix wins.
+func (r *EncoderRegistry) RegisterPrefixEncoding(prefix, encoding string) error {
+	if prefix == "" {
+		return fmt.Errorf("prefix is required")
+	}
+	if err := r.validateEncoding(encoding); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for idx, rule := range r.prefixes {
+		if rule.prefix == prefix {
+			r.prefixes[idx].encoding = encoding
+			return nil
+		}
+	}
+	r.prefixes = append(r.prefixes, encodingRule{prefix: prefix, encoding: encoding})
+	sort.SliceStable(r.prefixes, func(i, j int) bool {
+		return len(r.prefixes[i].prefix) > len(r.prefixes[j].prefix)
+	})
+	return nil
+}
+
+// RegisterModelAlias resolves alias as if it was model, e.g. a deployment name of a model.
+func (r *EncoderRegistry) RegisterModelAlias(alias, model string) error {
+	if alias == "" || model == "" {
+		return fmt.Errorf("alias and model are required")
+	}
+	if alias == model {
+		return fmt.Errorf("alias %s refers to itself", alias)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.aliases[alias] = model
+	return nil
+}
+
+// SetFallbackEncoding sets the encoding of unknown models.
+func (r *EncoderRegistry) SetFallbackEncoding(encoding string) error {
+	if err := r.validateEncoding(encoding); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.fallback = encoding
+	return nil
+}
+
+// Apply registers all the rules of the config, it stops at the first invalid rule and the rules
+// registered before it are kept.
+func (r *EncoderRegistry) Apply(cfg EncodingConfig) error {
+	for model, encoding := range cfg.Models {
+		if err := r.RegisterModelEncoding(model, encoding); err != nil {
+			return fmt.Errorf("model %s: %w", model, err)
+		}
+	}
+	for prefix, encoding := range cfg.Prefixes {
+		if err := r.RegisterPrefixEncoding(prefix, encoding); err != nil {
+			return fmt.Errorf("prefix %s: %w", prefix, err)
+		}
+	}
+	for alias, model := range cfg.Aliases {
+		if err := r.RegisterModelAlias(alias, model); err != nil {
+			return fmt.Errorf("alias %s: %w", alias, err)
+		}
+	}
+	return nil
+}
+
+// Resolve returns how the encoding of the model is resolved.
+func (r *EncoderRegistry) Resolve(model string) EncodingResolution {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.resolve(model)
+}
+
+// resolve resolves the encoding of the model, the caller must hold the lock.
+func (r *EncoderRegistry) resolve(model string) EncodingResolution {
+	res := EncodingResolution{Model: model, Target: model}
+
+	aliased := false
+	for i := 0; i < maxAliasDepth; i++ {
+		target, ok := r.aliases[res.Target]
+		if !ok {
+			break
+		}
+		res.Target = target
+		aliased = true
+	}
+
+	if encoding, ok := r.models[res.Target]; ok {
+		res.Encoding = encoding
+		res.Rule = RuleExact
+	} else {
+		for _, rule := range r.prefixes {
+			if strings.HasPrefix(res.Target, rule.prefix) {
+				res.Encoding = rule.encoding
+				res.Rule = RulePrefix
+				res.Prefix = rule.prefix
+				break
+			}
+		}
+	}
+
+	if res.Encoding == "" {
+		res.Encoding = r.fallback
+		res.Rule = RuleDefault
+	}
+	if aliased && res.Rule != RuleDefault {
+		res.Rule = RuleAlias
+	}
+	return res
+}
+
+// Tokenizer returns the tokenizer of the model.
+func (r *EncoderRegistry) Tokenizer(model string) (Tokenizer, error) {
+	return r.tokenizer(r.Resolve(model).Encoding)
+}
+
+// tokenizer returns the tokenizer of the encoding, tiktoken encodings are loaded on the first use.
+func (r *EncoderRegistry) tokenizer(encoding string) (Tokenizer, error) {
+	r.mu.RLock()
+	tokenizer, ok := r.tokenizers[encoding]
+	r.mu.RUnlock()
+	if ok {
+		return tokenizer, nil
+	}
+
+	// loading is serialized apart from mu, so lookups are not blocked meanwhile.
+	r.loadMu.Lock()
+	defer r.loadMu.Unlock()
+	r.mu.RLock()
+	tokenizer, ok = r.tokenizers[encoding]
+	r.mu.RUnlock()
+	if ok {
+		return tokenizer, nil
+	}
+
+	tokenEncoder, err := tiktoken.GetEncoding(encoding)
+	if err != nil {
+		return nil, err
+	}
+	tokenizer = NewTiktokenTokenizer(encoding, tokenEncoder)
+	r.mu.Lock()
+	r.tokenizers[encoding] = tokenizer
+	r.mu.Unlock()
+	return tokenizer, nil
+}
+
+// RegisterTokenizer registers a tokenizer under its name, models are mapped to it
+// like to any other encoding, e.g. RegisterModelEncoding("qwen-turbo", tokenizer.Name()).
+func (r *EncoderRegistry) RegisterTokenizer(tokenizer Tokenizer) error {
+	if tokenizer == nil || tokenizer.Name() == "" {
+		return fmt.Errorf("tokenizer name is required")
+	}
+	if supportedEncodings[tokenizer.Name()] {
+		return fmt.Errorf("tokenizer %s conflicts with a tiktoken encoding", tokenizer.Name())
+	}
+
+	r.mu.Lock()
+	r.tokenizers[tokenizer.Name()] = tokenizer
+	r.mu.Unlock()
+	// the cache is keyed on the name, the counts of a replaced tokenizer are stale.
+	clearCachedEncoding(tokenizer.Name())
+	return nil
+}
+
+// RegisterModelEncoding registers the encoding of a model in the default registry.
+func RegisterModelEncoding(model, encoding string) error {
+	return defaultRegistry.RegisterModelEncoding(model, encoding)
+}
+
+// RegisterPrefixEncoding registers the encoding of a model prefix in the default registry.
+func RegisterPrefixEncoding(prefix, encoding string) error {
+	return defaultRegistry.RegisterPrefixEncoding(prefix, encoding)
+}
+
+// RegisterModelAlias registers a model alias in the default registry.
+func RegisterModelAlias(alias, model string) error {
+	return defaultRegistry.RegisterModelAlias(alias, model)
+}
+
+// RegisterTokenizer registers a tokenizer in the default registry.
+func RegisterTokenizer(tokenizer Tokenizer) error {
+	return defaultRegistry.RegisterTokenizer(tokenizer)
+}
+
+// ResolveEncoding returns how the encoding of the model is resolved by the default registry.
+func ResolveEncoding(model string) EncodingResolution {
+	return defaultRegistry.Resolve(model)
+}
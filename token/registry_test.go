@@ -0,0 +1,144 @@
+package token
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pkoukk/tiktoken-go"
+)
+
+func TestEncoderRegistryResolve(t *testing.T) {
+	r := NewEncoderRegistry()
+	for _, err := range []error{
+		r.RegisterModelEncoding("gpt-4", tiktoken.MODEL_CL100K_BASE),
+		r.RegisterPrefixEncoding("gpt-4-", tiktoken.MODEL_CL100K_BASE),
+		r.RegisterPrefixEncoding("gpt-4o", tiktoken.MODEL_O200K_BASE),
+		r.RegisterPrefixEncoding("gpt-", tiktoken.MODEL_P50K_BASE),
+		r.RegisterModelAlias("prod", "staging"),
+		r.RegisterModelAlias("staging", "gpt-4o-mini"),
+		r.RegisterModelAlias("loop-a", "loop-b"),
+		r.RegisterModelAlias("loop-b", "loop-a"),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		model string
+		want  EncodingResolution
+	}{
+		{"gpt-4", EncodingResolution{Target: "gpt-4", Encoding: tiktoken.MODEL_CL100K_BASE, Rule: RuleExact}},
+		// the longest prefix wins whatever the registration order.
+		{"gpt-4o-mini", EncodingResolution{Target: "gpt-4o-mini", Encoding: tiktoken.MODEL_O200K_BASE, Rule: RulePrefix, Prefix: "gpt-4o"}},
+		{"gpt-4-turbo", EncodingResolution{Target: "gpt-4-turbo", Encoding: tiktoken.MODEL_CL100K_BASE, Rule: RulePrefix, Prefix: "gpt-4-"}},
+		{"gpt-3.5-turbo", EncodingResolution{Target: "gpt-3.5-turbo", Encoding: tiktoken.MODEL_P50K_BASE, Rule: RulePrefix, Prefix: "gpt-"}},
+		{"prod", EncodingResolution{Target: "gpt-4o-mini", Encoding: tiktoken.MODEL_O200K_BASE, Rule: RuleAlias, Prefix: "gpt-4o"}},
+		{"claude-3", EncodingResolution{Target: "claude-3", Encoding: tiktoken.MODEL_CL100K_BASE, Rule: RuleDefault}},
+		// a loop stops at maxAliasDepth and falls back.
+		{"loop-a", EncodingResolution{Target: "loop-a", Encoding: tiktoken.MODEL_CL100K_BASE, Rule: RuleDefault}},
+	}
+	for _, tt := range tests {
+		tt.want.Model = tt.model
+		if got := r.Resolve(tt.model); got != tt.want {
+			t.Errorf("%s: got %+v, want %+v", tt.model, got, tt.want)
+		}
+	}
+
+	if err := r.SetFallbackEncoding(tiktoken.MODEL_O200K_BASE); err != nil {
+		t.Fatal(err)
+	}
+	if got := r.Resolve("claude-3"); got.Encoding != tiktoken.MODEL_O200K_BASE || got.Rule != RuleDefault {
+		t.Errorf("unexpected fallback: %+v", got)
+	}
+}
+
+func TestEncoderRegistryAliasDepth(t *testing.T) {
+	r := NewEncoderRegistry()
+	if err := r.RegisterModelEncoding("base", tiktoken.MODEL_O200K_BASE); err != nil {
+		t.Fatal(err)
+	}
+	// a chain of maxAliasDepth aliases resolves, a longer one is cut.
+	for i := 0; i <= maxAliasDepth; i++ {
+		target := fmt.Sprintf("alias-%d", i+1)
+		if i == maxAliasDepth-1 {
+			target = "base"
+		}
+		if i == maxAliasDepth {
+			target = "alias-0"
+		}
+		if err := r.RegisterModelAlias(fmt.Sprintf("alias-%d", i), target); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := r.Resolve("alias-0"); got.Rule != RuleAlias || got.Target != "base" {
+		t.Errorf("unexpected resolution: %+v", got)
+	}
+	if got := r.Resolve(fmt.Sprintf("alias-%d", maxAliasDepth)); got.Rule != RuleDefault {
+		t.Errorf("expected the chain to be cut: %+v", got)
+	}
+}
+
+func TestEncoderRegistryApply(t *testing.T) {
+	r := NewEncoderRegistry()
+	err := r.Apply(EncodingConfig{
+		Models:  map[string]string{"qwen-turbo": "qwen"},
+		Aliases: map[string]string{"x": "y"},
+	})
+	if err == nil || !strings.Contains(err.Error(), "model qwen-turbo") || !strings.Contains(err.Error(), "unknown encoding: qwen") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := r.Apply(EncodingConfig{Aliases: map[string]string{"x": "x"}}); err == nil || !strings.Contains(err.Error(), "alias x") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := r.Apply(EncodingConfig{Prefixes: map[string]string{"": tiktoken.MODEL_O200K_BASE}}); err == nil || !strings.Contains(err.Error(), "prefix") {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	err = r.Apply(EncodingConfig{
+		Models:   map[string]string{"doubao-pro": tiktoken.MODEL_O200K_BASE},
+		Prefixes: map[string]string{"doubao-": tiktoken.MODEL_CL100K_BASE},
+		Aliases:  map[string]string{"ep-1": "doubao-pro"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := r.Resolve("ep-1"); got.Encoding != tiktoken.MODEL_O200K_BASE || got.Rule != RuleAlias {
+		t.Errorf("unexpected resolution: %+v", got)
+	}
+}
+
+func TestEncoderRegistryConcurrent(t *testing.T) {
+	r := NewEncoderRegistry()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				model := fmt.Sprintf("model-%d-%d", i, j)
+				_ = r.RegisterModelEncoding(model, tiktoken.MODEL_O200K_BASE)
+				_ = r.RegisterPrefixEncoding(model+"-", tiktoken.MODEL_P50K_BASE)
+				_ = r.RegisterModelAlias("alias-"+model, model)
+			}
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				r.Resolve(fmt.Sprintf("alias-model-%d-%d", i, j))
+				r.Resolve(fmt.Sprintf("model-%d-%d-x", i, j))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if got := r.Resolve("alias-model-7-99"); got.Encoding != tiktoken.MODEL_O200K_BASE || got.Rule != RuleAlias {
+		t.Errorf("unexpected resolution: %+v", got)
+	}
+	if got := r.Resolve("model-7-99-x"); got.Encoding != tiktoken.MODEL_P50K_BASE || got.Rule != RulePrefix {
+		t.Errorf("unexpected resolution: %+v", got)
+	}
+}
@@ -0,0 +1,277 @@
+/*
+Copyright 2024 The corego Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package token
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// tokensPerToolCall is the overhead the model spends on wrapping every generated tool call.
+const tokensPerToolCall = 3
+
+// StreamCounter accumulates the chunks of a streamed chat completion and
+// computes the usage once the stream ends.[流式Token累计]
+//
+//	counter := token.NewStreamCounter(req.Model, promptTokens)
+//	for {
+//		chunk, err := stream.Recv()
+//		...
+//		counter.Add(&chunk)
+//	}
+//	usage, err := counter.Usage()
+type StreamCounter struct {
+	mu           sync.Mutex
+	model        string
+	promptTokens int
+	choices      map[int]*streamChoice
+	upstream     *openai.Usage
+}
+
+// streamChoice is the accumulated state of a single choice.
+type streamChoice struct {
+	role         string
+	content      strings.Builder
+	functionCall *openai.FunctionCall
+	toolCalls    []*openai.ToolCall
+	finishReason openai.FinishReason
+}
+
+// NewStreamCounter creates a new StreamCounter for the given model.
+// promptTokens is the number of tokens of the request, see CalculateRequestToken.
+func NewStreamCounter(model string, promptTokens int) *StreamCounter {
+	return &StreamCounter{
+		model:        model,
+		promptTokens: promptTokens,
+		choices:      make(map[int]*streamChoice),
+	}
+}
+
+// Add adds a chunk of the stream to the counter.
+func (c *StreamCounter) Add(chunk *openai.ChatCompletionStreamResponse) {
+	if chunk == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.model == "" {
+		c.model = chunk.Model
+	}
+
+	if chunk.Usage != nil {
+		usage := *chunk.Usage
+		c.upstream = &usage
+	}
+
+	for _, v := range chunk.Choices {
+		choice, ok := c.choices[v.Index]
+		if !ok {
+			choice = &streamChoice{}
+			c.choices[v.Index] = choice
+		}
+		choice.add(v)
+	}
+}
+
+// add merges the delta of a chunk into the choice.
+func (s *streamChoice) add(v openai.ChatCompletionStreamChoice) {
+	if v.Delta.Role != "" {
+		s.role = v.Delta.Role
+	}
+
+	s.content.WriteString(v.Delta.Content)
+
+	if v.Delta.FunctionCall != nil {
+		if s.functionCall == nil {
+			s.functionCall = &openai.FunctionCall{}
+		}
+		s.functionCall.Name += v.Delta.FunctionCall.Name
+		s.functionCall.Arguments += v.Delta.FunctionCall.Arguments
+	}
+
+	for _, delta := range v.Delta.ToolCalls {
+		call := s.toolCall(delta)
+		if delta.ID != "" {
+			call.ID = delta.ID
+		}
+		if delta.Type != "" {
+			call.Type = delta.Type
+		}
+		call.Function.Name += delta.Function.Name
+		call.Function.Arguments += delta.Function.Arguments
+	}
+
+	if v.FinishReason != "" {
+		s.finishReason = v.FinishReason
+	}
+}
+
+// toolCall returns the call the delta belongs to, creating it if needed. A delta without an index
+// is keyed by its ID, or continues the last open call when it has no ID either, so the fragments
+// of different calls are never merged by their position in the chunk.
+func (s *streamChoice) toolCall(delta openai.ToolCall) *openai.ToolCall {
+	if delta.Index != nil {
+		for len(s.toolCalls) <= *delta.Index {
+			s.toolCalls = append(s.toolCalls, nil)
+		}
+		if s.toolCalls[*delta.Index] == nil {
+			s.toolCalls[*delta.Index] = &openai.ToolCall{Type: openai.ToolTypeFunction}
+		}
+		return s.toolCalls[*delta.Index]
+	}
+
+	if delta.ID != "" {
+		for _, call := range s.toolCalls {
+			if call != nil && call.ID == delta.ID {
+				return call
+			}
+		}
+	} else {
+		for idx := len(s.toolCalls) - 1; idx >= 0; idx-- {
+			if s.toolCalls[idx] != nil {
+				return s.toolCalls[idx]
+			}
+		}
+	}
+
+	call := &openai.ToolCall{Type: openai.ToolTypeFunction}
+	s.toolCalls = append(s.toolCalls, call)
+	return call
+}
+
+// message returns the accumulated message of the choice.
+func (s *streamChoice) message() openai.ChatCompletionMessage {
+	msg := openai.ChatCompletionMessage{
+		Role:    s.role,
+		Content: s.content.String(),
+	}
+	if msg.Role == "" {
+		msg.Role = openai.ChatMessageRoleAssistant
+	}
+
+	if s.functionCall != nil {
+		call := *s.functionCall
+		msg.FunctionCall = &call
+	}
+
+	for _, call := range s.toolCalls {
+		if call == nil {
+			continue
+		}
+		msg.ToolCalls = append(msg.ToolCalls, openai.ToolCall{
+			ID:       call.ID,
+			Type:     call.Type,
+			Function: call.Function,
+		})
+	}
+	return msg
+}
+
+// indexes returns the sorted choice indexes.
+func (c *StreamCounter) indexes() []int {
+	indexes := make([]int, 0, len(c.choices))
+	for idx := range c.choices {
+		indexes = append(indexes, idx)
+	}
+	sort.Ints(indexes)
+	return indexes
+}
+
+// Choices returns the choices rebuilt from the stream, ordered by index.
+func (c *StreamCounter) Choices() []openai.ChatCompletionChoice {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	choices := make([]openai.ChatCompletionChoice, 0, len(c.choices))
+	for _, idx := range c.indexes() {
+		choice := c.choices[idx]
+		choices = append(choices, openai.ChatCompletionChoice{
+			Index:        idx,
+			Message:      choice.message(),
+			FinishReason: choice.finishReason,
+		})
+	}
+	return choices
+}
+
+// FinishReasons returns the finish reason of every choice, keyed by index.
+func (c *StreamCounter) FinishReasons() map[int]openai.FinishReason {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	reasons := make(map[int]openai.FinishReason, len(c.choices))
+	for idx, choice := range c.choices {
+		reasons[idx] = choice.finishReason
+	}
+	return reasons
+}
+
+// Finished reports whether every choice has received a finish reason.
+func (c *StreamCounter) Finished() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.choices) == 0 {
+		return false
+	}
+	for _, choice := range c.choices {
+		if choice.finishReason == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// UpstreamUsage returns the usage reported by the upstream, it is only present
+// when the request was sent with stream_options.include_usage.
+func (c *StreamCounter) UpstreamUsage() (openai.Usage, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.upstream == nil {
+		return openai.Usage{}, false
+	}
+	return *c.upstream, true
+}
+
+// Usage returns the usage of the stream counted locally, the completion tokens
+// are the sum of all choices.
+func (c *StreamCounter) Usage() (openai.Usage, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	tokenEncoder, err := getTokenEncoder(c.model)
+	if err != nil {
+		return openai.Usage{}, err
+	}
+
+	completionTokens := 0
+	for _, idx := range c.indexes() {
+		completionTokens += countCompletionMessage(tokenEncoder, c.choices[idx].message())
+	}
+
+	return openai.Usage{
+		PromptTokens:     c.promptTokens,
+		CompletionTokens: completionTokens,
+		TotalTokens:      c.promptTokens + completionTokens,
+	}, nil
+}
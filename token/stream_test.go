@@ -0,0 +1,113 @@
+package token
+
+import (
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// toolDelta creates a chunk with a single tool call delta, index < 0 leaves it unset.
+func toolDelta(index int, id, name, arguments string) *openai.ChatCompletionStreamResponse {
+	call := openai.ToolCall{ID: id, Function: openai.FunctionCall{Name: name, Arguments: arguments}}
+	if index >= 0 {
+		call.Index = &index
+	}
+	return &openai.ChatCompletionStreamResponse{
+		Choices: []openai.ChatCompletionStreamChoice{{Delta: openai.ChatCompletionStreamChoiceDelta{ToolCalls: []openai.ToolCall{call}}}},
+	}
+}
+
+func TestStreamCounterToolCalls(t *testing.T) {
+	want := []openai.ToolCall{
+		{ID: "call_1", Type: openai.ToolTypeFunction, Function: openai.FunctionCall{Name: "lookup", Arguments: `{"q":"hello"}`}},
+		{ID: "call_2", Type: openai.ToolTypeFunction, Function: openai.FunctionCall{Name: "search", Arguments: `{"q":"world"}`}},
+	}
+
+	tests := []struct {
+		name   string
+		chunks []*openai.ChatCompletionStreamResponse
+	}{
+		{"indexed", []*openai.ChatCompletionStreamResponse{
+			toolDelta(0, "call_1", "lookup", ""),
+			toolDelta(0, "", "", `{"q":`),
+			toolDelta(1, "call_2", "search", ""),
+			toolDelta(0, "", "", `"hello"}`),
+			toolDelta(1, "", "", `{"q":"world"}`),
+		}},
+		{"without index", []*openai.ChatCompletionStreamResponse{
+			toolDelta(-1, "call_1", "lookup", `{"q":`),
+			toolDelta(-1, "", "", `"hello"}`),
+			toolDelta(-1, "call_2", "search", `{"q":`),
+			toolDelta(-1, "", "", `"world"}`),
+		}},
+		{"without index, repeated ids", []*openai.ChatCompletionStreamResponse{
+			toolDelta(-1, "call_1", "lookup", ""),
+			toolDelta(-1, "call_2", "search", ""),
+			toolDelta(-1, "call_1", "", `{"q":"hello"}`),
+			toolDelta(-1, "call_2", "", `{"q":"world"}`),
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counter := NewStreamCounter("gpt-4", 10)
+			for _, chunk := range tt.chunks {
+				counter.Add(chunk)
+			}
+			counter.Add(&openai.ChatCompletionStreamResponse{Choices: []openai.ChatCompletionStreamChoice{{FinishReason: openai.FinishReasonToolCalls}}})
+
+			choices := counter.Choices()
+			if len(choices) != 1 || len(choices[0].Message.ToolCalls) != len(want) {
+				t.Fatalf("unexpected choices: %+v", choices)
+			}
+			for idx, call := range choices[0].Message.ToolCalls {
+				if call != want[idx] {
+					t.Errorf("call %d: got %+v, want %+v", idx, call, want[idx])
+				}
+			}
+			if !counter.Finished() {
+				t.Error("expected the stream to be finished")
+			}
+
+			tokenEncoder, err := getTokenEncoder("gpt-4")
+			if err != nil {
+				t.Fatal(err)
+			}
+			completion := countCompletionMessage(tokenEncoder, openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, ToolCalls: want})
+			usage, err := counter.Usage()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if usage.PromptTokens != 10 || usage.CompletionTokens != completion || usage.TotalTokens != 10+completion {
+				t.Errorf("unexpected usage: %+v, want %d completion tokens", usage, completion)
+			}
+		})
+	}
+}
+
+func TestStreamCounterUpstreamUsage(t *testing.T) {
+	counter := NewStreamCounter("gpt-4", 3)
+	counter.Add(&openai.ChatCompletionStreamResponse{Choices: []openai.ChatCompletionStreamChoice{
+		{Index: 0, Delta: openai.ChatCompletionStreamChoiceDelta{Role: openai.ChatMessageRoleAssistant, Content: "hello"}},
+		{Index: 1, Delta: openai.ChatCompletionStreamChoiceDelta{Content: "hello hello"}},
+	}})
+	if _, ok := counter.UpstreamUsage(); ok {
+		t.Error("expected no upstream usage before the usage chunk")
+	}
+
+	// the usage chunk has no choices, a later usage chunk overrides an earlier one.
+	counter.Add(&openai.ChatCompletionStreamResponse{Usage: &openai.Usage{PromptTokens: 1, CompletionTokens: 1, TotalTokens: 2}})
+	counter.Add(&openai.ChatCompletionStreamResponse{Usage: &openai.Usage{PromptTokens: 4, CompletionTokens: 5, TotalTokens: 9}})
+	upstream, ok := counter.UpstreamUsage()
+	if !ok || upstream != (openai.Usage{PromptTokens: 4, CompletionTokens: 5, TotalTokens: 9}) {
+		t.Errorf("unexpected upstream usage: %+v, %v", upstream, ok)
+	}
+
+	// the local usage is still counted from the choices, 1 token of "hello" and 3 of "hello hello".
+	usage, err := counter.Usage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage.PromptTokens != 3 || usage.CompletionTokens != 4 || usage.TotalTokens != 7 {
+		t.Errorf("unexpected local usage: %+v", usage)
+	}
+}
@@ -0,0 +1,55 @@
+/*
+Copyright 2024 The corego Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package token
+
+import "github.com/pkoukk/tiktoken-go"
+
+// Tokenizer turns text into the token ids of a vocabulary.[分词器]
+type Tokenizer interface {
+	// Name returns the name of the vocabulary, e.g. cl100k_base.
+	Name() string
+	// Encode encodes the text, special tokens are encoded as plain text.
+	Encode(text string) []int
+	// Decode decodes the token ids.
+	Decode(ids []int) string
+}
+
+// tiktokenTokenizer is the Tokenizer of a tiktoken encoding.
+type tiktokenTokenizer struct {
+	name    string
+	encoder *tiktoken.Tiktoken
+}
+
+// NewTiktokenTokenizer wraps a tiktoken encoder as a Tokenizer.
+func NewTiktokenTokenizer(name string, encoder *tiktoken.Tiktoken) Tokenizer {
+	return &tiktokenTokenizer{name: name, encoder: encoder}
+}
+
+// Name implements the Tokenizer interface.
+func (t *tiktokenTokenizer) Name() string {
+	return t.name
+}
+
+// Encode implements the Tokenizer interface.
+func (t *tiktokenTokenizer) Encode(text string) []int {
+	return t.encoder.Encode(text, nil, nil)
+}
+
+// Decode implements the Tokenizer interface.
+func (t *tiktokenTokenizer) Decode(ids []int) string {
+	return t.encoder.Decode(ids)
+}
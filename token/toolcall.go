@@ -0,0 +1,89 @@
+/*
+Copyright 2024 The corego Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package token
+
+import (
+	"encoding/json"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+/*
+	OpenAI renders the tool calls of an assistant message into the prompt as a message
+	addressed to the function, and a tool result as a message authored by the function:
+
+	<|im_start|>assistant to=functions.get_weather<|im_sep|>{"location":"Paris"}<|im_end|>
+	<|im_start|>functions.get_weather<|im_sep|>{"temperature":22}<|im_end|>
+
+	Parallel tool calls are wrapped into a single call of multi_tool_use.parallel:
+
+	<|im_start|>assistant to=multi_tool_use.parallel<|im_sep|>{"tool_uses":[{"recipient_name":"functions.get_weather","parameters":{"location":"Paris"}}]}<|im_end|>
+*/
+
+const (
+	// toolNamespace is the namespace of the functions in the prompt.
+	toolNamespace = "functions"
+	// parallelToolRecipient is the recipient of parallel tool calls.
+	parallelToolRecipient = "multi_tool_use.parallel"
+)
+
+// toolRecipient returns the recipient of the given function.
+func toolRecipient(name string) string {
+	return toolNamespace + "." + name
+}
+
+// parallelToolUse is a single call of multi_tool_use.parallel.
+type parallelToolUse struct {
+	RecipientName string          `json:"recipient_name"`
+	Parameters    json.RawMessage `json:"parameters"`
+}
+
+// countPromptToolCall counts a rendered tool call addressed to the recipient. The call shares
+// the header of the assistant message unless the message has its own content.
+func countPromptToolCall(tokenEncoder Tokenizer, recipient, arguments string, hasContent bool, tokensPerMessage int) int {
+	tokenNum := 0
+	if hasContent {
+		tokenNum += tokensPerMessage
+		tokenNum += getTokenNum(tokenEncoder, openai.ChatMessageRoleAssistant)
+	}
+	tokenNum += getTokenNum(tokenEncoder, " to="+recipient)
+	tokenNum += getTokenNum(tokenEncoder, arguments)
+	return tokenNum
+}
+
+// countPromptToolCalls counts the rendered tool calls of an assistant message.
+func countPromptToolCalls(tokenEncoder Tokenizer, calls []openai.ToolCall, hasContent bool, tokensPerMessage int) int {
+	if len(calls) == 1 {
+		call := calls[0]
+		return countPromptToolCall(tokenEncoder, toolRecipient(call.Function.Name), call.Function.Arguments, hasContent, tokensPerMessage)
+	}
+
+	uses := make([]parallelToolUse, 0, len(calls))
+	for _, call := range calls {
+		parameters := json.RawMessage(call.Function.Arguments)
+		if !json.Valid(parameters) {
+			parameters, _ = json.Marshal(call.Function.Arguments)
+		}
+		uses = append(uses, parallelToolUse{
+			RecipientName: toolRecipient(call.Function.Name),
+			Parameters:    parameters,
+		})
+	}
+
+	arguments, _ := json.Marshal(map[string]interface{}{"tool_uses": uses})
+	return countPromptToolCall(tokenEncoder, parallelToolRecipient, string(arguments), hasContent, tokensPerMessage)
+}
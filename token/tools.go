@@ -0,0 +1,401 @@
+/*
+Copyright 2024 The corego Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package token
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+/*
+	OpenAI injects the tool definitions into the system prompt as a typescript namespace:
+
+	# Tools
+
+	## functions
+
+	namespace functions {
+
+	// Get the current weather
+	type get_current_weather = (_: {
+	// The city and state, e.g. San Francisco, CA
+	location: string,
+	unit?: "celsius" | "fahrenheit",
+	}) => any;
+
+	} // namespace functions
+
+	https://github.com/hmarr/openai-chat-tokens
+	https://community.openai.com/t/how-to-calculate-the-tokens-when-using-function-call/266573
+*/
+
+const (
+	// tokensPerTools is the overhead of the tools header around the namespace.
+	tokensPerTools = 9
+	// tokensToolsWithSystem is subtracted when the tools are merged into an existing system message.
+	tokensToolsWithSystem = 4
+	// tokensPerToolChoice is the overhead of forcing a named function.
+	tokensPerToolChoice = 4
+	// tokensToolChoiceNone is the overhead of disabling the tools.
+	tokensToolChoiceNone = 1
+)
+
+// maxDescriptionDepth is the deepest nesting whose property descriptions are rendered.
+const maxDescriptionDepth = 2
+
+// jsonSchema is the subset of JSON schema rendered into the prompt, the properties keep their order.
+type jsonSchema struct {
+	Type        schemaType        `json:"type"`
+	Description string            `json:"description"`
+	Enum        []json.RawMessage `json:"enum"`
+	Items       *jsonSchema       `json:"items"`
+	Properties  schemaProperties  `json:"properties"`
+	Required    []string          `json:"required"`
+	AnyOf       []*jsonSchema     `json:"anyOf"`
+}
+
+// schemaType is the type of schema, it may be a single type or a list of types.
+type schemaType []string
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (t *schemaType) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*t = schemaType{single}
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*t = list
+	return nil
+}
+
+// schemaProperty is a named property of an object schema.
+type schemaProperty struct {
+	Name   string
+	Schema *jsonSchema
+}
+
+// schemaProperties are the properties of an object schema in declaration order.
+type schemaProperties []schemaProperty
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (p *schemaProperties) UnmarshalJSON(data []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if _, err := decoder.Token(); err != nil {
+		return err
+	}
+
+	for decoder.More() {
+		key, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		name, ok := key.(string)
+		if !ok {
+			return fmt.Errorf("invalid property name: %v", key)
+		}
+
+		schema := &jsonSchema{}
+		if err := decoder.Decode(schema); err != nil {
+			return err
+		}
+		*p = append(*p, schemaProperty{Name: name, Schema: schema})
+	}
+	return nil
+}
+
+// required reports whether the property is required.
+func (s *jsonSchema) required(name string) bool {
+	for _, v := range s.Required {
+		if v == name {
+			return true
+		}
+	}
+	return false
+}
+
+// parseSchema parses the parameters of a function definition.
+func parseSchema(parameters any) (*jsonSchema, error) {
+	schema := &jsonSchema{}
+	if parameters == nil {
+		return schema, nil
+	}
+
+	var (
+		data []byte
+		err  error
+	)
+	switch v := parameters.(type) {
+	case json.RawMessage:
+		data = v
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		data, err = json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(bytes.TrimSpace(data)) == 0 || string(data) == "null" {
+		return schema, nil
+	}
+	if err := json.Unmarshal(data, schema); err != nil {
+		return nil, err
+	}
+	return schema, nil
+}
+
+// FormatToolDefinitions renders the tools the way they are injected into the prompt.[工具定义渲染]
+func FormatToolDefinitions(tools []openai.Tool) (string, error) {
+	lines := []string{"namespace functions {", ""}
+	for _, tool := range tools {
+		if tool.Function == nil {
+			continue
+		}
+
+		fn := tool.Function
+		if fn.Description != "" {
+			lines = append(lines, "// "+fn.Description)
+		}
+
+		schema, err := parseSchema(fn.Parameters)
+		if err != nil {
+			return "", fmt.Errorf("invalid parameters of function %s: %w", fn.Name, err)
+		}
+		if len(schema.Properties) != 0 {
+			lines = append(lines, "type "+fn.Name+" = (_: {")
+			lines = append(lines, formatObjectProperties(schema, 0))
+			lines = append(lines, "}) => any;")
+		} else {
+			lines = append(lines, "type "+fn.Name+" = () => any;")
+		}
+		lines = append(lines, "")
+	}
+	lines = append(lines, "} // namespace functions")
+	return strings.Join(lines, "\n"), nil
+}
+
+// formatObjectProperties renders the properties of an object schema.
+func formatObjectProperties(schema *jsonSchema, indent int) string {
+	lines := make([]string, 0, len(schema.Properties))
+	prefix := strings.Repeat(" ", indent)
+	for _, property := range schema.Properties {
+		if property.Schema.Description != "" && indent < maxDescriptionDepth {
+			lines = append(lines, prefix+"// "+property.Schema.Description)
+		}
+
+		name := property.Name
+		if !schema.required(name) {
+			name += "?"
+		}
+		lines = append(lines, prefix+name+": "+formatType(property.Schema, indent)+",")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// formatType renders the type of schema.
+func formatType(schema *jsonSchema, indent int) string {
+	if len(schema.AnyOf) != 0 {
+		types := make([]string, 0, len(schema.AnyOf))
+		for _, v := range schema.AnyOf {
+			types = append(types, formatType(v, indent))
+		}
+		return strings.Join(types, " | ")
+	}
+
+	if len(schema.Type) == 0 {
+		return "any"
+	}
+
+	types := make([]string, 0, len(schema.Type))
+	for _, v := range schema.Type {
+		types = append(types, formatSingleType(v, schema, indent))
+	}
+	return strings.Join(types, " | ")
+}
+
+// formatSingleType renders one of the types of schema.
+func formatSingleType(typ string, schema *jsonSchema, indent int) string {
+	switch typ {
+	case "string", "number", "integer":
+		if len(schema.Enum) != 0 {
+			values := make([]string, 0, len(schema.Enum))
+			for _, v := range schema.Enum {
+				values = append(values, string(v))
+			}
+			return strings.Join(values, " | ")
+		}
+		if typ == "integer" {
+			return "number"
+		}
+		return typ
+	case "array":
+		if schema.Items != nil {
+			return formatType(schema.Items, indent) + "[]"
+		}
+		return "any[]"
+	case "boolean", "null":
+		return typ
+	case "object":
+		return strings.Join([]string{"{", formatObjectProperties(schema, indent+2), "}"}, "\n")
+	}
+	return "any"
+}
+
+// functionsToTools converts the deprecated functions to tools.
+func functionsToTools(functions []openai.FunctionDefinition) []openai.Tool {
+	tools := make([]openai.Tool, 0, len(functions))
+	for idx := range functions {
+		tools = append(tools, openai.Tool{
+			Type:     openai.ToolTypeFunction,
+			Function: &functions[idx],
+		})
+	}
+	return tools
+}
+
+// CalculateToolsToken calculates the token of the tool definitions for the given model.[工具Token计算]
+func CalculateToolsToken(tools []openai.Tool, model string) (int, error) {
+	if len(tools) == 0 {
+		return 0, nil
+	}
+
+	definitions, err := FormatToolDefinitions(tools)
+	if err != nil {
+		return 0, err
+	}
+	tokenNum, err := CounterText(definitions, model)
+	if err != nil {
+		return 0, err
+	}
+	return tokenNum + tokensPerTools, nil
+}
+
+// CalculateToolChoiceToken calculates the token of tool_choice or the deprecated function_call.
+// The choice may be a string (none, auto, required) or an object naming the function.
+func CalculateToolChoiceToken(choice any, model string) (int, error) {
+	var name string
+	switch v := choice.(type) {
+	case nil:
+		return 0, nil
+	case string:
+		if v == "none" {
+			return tokensToolChoiceNone, nil
+		}
+		return 0, nil
+	case openai.ToolChoice:
+		name = v.Function.Name
+	case *openai.ToolChoice:
+		if v == nil {
+			return 0, nil
+		}
+		name = v.Function.Name
+	case openai.FunctionCall:
+		name = v.Name
+	case *openai.FunctionCall:
+		if v == nil {
+			return 0, nil
+		}
+		name = v.Name
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return 0, err
+		}
+		var named struct {
+			Name     string              `json:"name"`
+			Function openai.ToolFunction `json:"function"`
+		}
+		if err := json.Unmarshal(data, &named); err != nil {
+			return 0, nil
+		}
+		name = named.Name
+		if named.Function.Name != "" {
+			name = named.Function.Name
+		}
+	}
+
+	if name == "" {
+		return 0, nil
+	}
+	tokenNum, err := CounterText(name, model)
+	if err != nil {
+		return 0, err
+	}
+	return tokenNum + tokensPerToolChoice, nil
+}
+
+// ResponseFormat is the response_format of a request, including the json_schema
+// of structured outputs which is missing from go-openai.
+type ResponseFormat struct {
+	Type       string                    `json:"type"`
+	JSONSchema *ResponseFormatJSONSchema `json:"json_schema,omitempty"`
+}
+
+// ResponseFormatJSONSchema is the json_schema of a structured output.
+type ResponseFormatJSONSchema struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Schema      json.RawMessage `json:"schema"`
+	Strict      bool            `json:"strict,omitempty"`
+}
+
+// CalculateResponseFormatToken calculates the token of the response format. Structured outputs
+// inject their schema into the prompt as a response format section:
+//
+//	# Response Formats
+//
+//	## {name}
+//
+//	{description}
+//
+//	{schema}
+func CalculateResponseFormatToken(format *ResponseFormat, model string) (int, error) {
+	if format == nil || format.JSONSchema == nil {
+		return 0, nil
+	}
+
+	lines := []string{"# Response Formats", "", "## " + format.JSONSchema.Name, ""}
+	if format.JSONSchema.Description != "" {
+		lines = append(lines, format.JSONSchema.Description, "")
+	}
+
+	schema := &bytes.Buffer{}
+	if len(format.JSONSchema.Schema) != 0 {
+		if err := json.Compact(schema, format.JSONSchema.Schema); err != nil {
+			return 0, err
+		}
+	}
+	lines = append(lines, schema.String())
+
+	tokenNum, err := CounterText(strings.Join(lines, "\n"), model)
+	if err != nil {
+		return 0, err
+	}
+	return tokenNum + tokensPerTools, nil
+}
@@ -0,0 +1,212 @@
+package token
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkoukk/tiktoken-go"
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestFormatToolDefinitions(t *testing.T) {
+	got, err := FormatToolDefinitions([]openai.Tool{{Type: openai.ToolTypeFunction, Function: &openai.FunctionDefinition{
+		Name:        "get_weather",
+		Description: "Get the weather",
+		Parameters: json.RawMessage(`{"type":"object","properties":{
+			"location":{"type":"string","description":"The city"},
+			"unit":{"type":"string","enum":["celsius","fahrenheit"]},
+			"days":{"type":"array","items":{"type":"integer"}},
+			"options":{"type":"object","properties":{"hourly":{"type":"boolean","description":"Hourly data"}}}
+		},"required":["location"]}`),
+	}}, {Type: openai.ToolTypeFunction, Function: &openai.FunctionDefinition{Name: "ping"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the descriptions of nested properties are left out.
+	want := `namespace functions {
+
+// Get the weather
+type get_weather = (_: {
+// The city
+location: string,
+unit?: "celsius" | "fahrenheit",
+days?: number[],
+options?: {
+  hourly?: boolean,
+},
+}) => any;
+
+type ping = () => any;
+
+} // namespace functions`
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestCalculateRequestTokenOverhead(t *testing.T) {
+	tools := []openai.Tool{{Type: openai.ToolTypeFunction, Function: &openai.FunctionDefinition{
+		Name:       "foo",
+		Parameters: json.RawMessage(`{"type":"object","properties":{}}`),
+	}}}
+	definitions, err := FormatToolDefinitions(tools)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := func(text string) int {
+		num, err := CounterText(text, "gpt-4")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return num
+	}
+	user := []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "hello"}}
+	system := []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleSystem, Content: "hello"}, user[0]}
+	messages := func(msgs []openai.ChatCompletionMessage) int {
+		num, err := CalculateMessage(msgs, "gpt-4")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return num
+	}
+
+	tests := []struct {
+		name string
+		req  openai.ChatCompletionRequest
+		want int
+	}{
+		{"tools", openai.ChatCompletionRequest{Messages: user, Tools: tools}, messages(user) + n(definitions) + 9},
+		{"functions", openai.ChatCompletionRequest{Messages: user, Functions: []openai.FunctionDefinition{*tools[0].Function}}, messages(user) + n(definitions) + 9},
+		// the tools reuse the system message, which is ended by a newline.
+		{"with system", openai.ChatCompletionRequest{Messages: system, Tools: tools},
+			messages(system) + n(definitions) + 9 - 4 + n("hello\n") - n("hello")},
+		{"tool choice auto", openai.ChatCompletionRequest{Messages: user, Tools: tools, ToolChoice: "auto"}, messages(user) + n(definitions) + 9},
+		{"tool choice none", openai.ChatCompletionRequest{Messages: user, Tools: tools, ToolChoice: "none"}, messages(user) + n(definitions) + 9 + 1},
+		{"named tool choice", openai.ChatCompletionRequest{Messages: user, Tools: tools, ToolChoice: openai.ToolChoice{
+			Type: openai.ToolTypeFunction, Function: openai.ToolFunction{Name: "foo"},
+		}}, messages(user) + n(definitions) + 9 + n("foo") + 4},
+		{"named function call", openai.ChatCompletionRequest{Messages: user, Tools: tools, FunctionCall: map[string]interface{}{"name": "foo"}},
+			messages(user) + n(definitions) + 9 + n("foo") + 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := CalculateRequestToken(&tt.req, "gpt-4")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %d tokens, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCalculateRawRequestToken(t *testing.T) {
+	body := `{"model":"gpt-4","messages":[{"role":"user","content":"hello"}]}`
+	plain, err := CalculateRawRequestToken([]byte(body), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := CalculateMessage([]openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "hello"}}, "gpt-4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plain != want {
+		t.Errorf("got %d tokens, want %d", plain, want)
+	}
+
+	format := &ResponseFormat{Type: "json_schema", JSONSchema: &ResponseFormatJSONSchema{
+		Name:   "answer",
+		Schema: json.RawMessage(`{"type": "object", "properties": {"value": {"type": "string"}}}`),
+	}}
+	formatTokens, err := CalculateResponseFormatToken(format, "gpt-4")
+	if err != nil || formatTokens <= 9 {
+		t.Fatalf("unexpected response format tokens: %d, %v", formatTokens, err)
+	}
+	data, _ := json.Marshal(format)
+	structured, err := CalculateRawRequestToken([]byte(body[:len(body)-1]+`,"response_format":`+string(data)+`}`), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if structured != plain+formatTokens {
+		t.Errorf("got %d tokens, want %d", structured, plain+formatTokens)
+	}
+}
+
+// upstreamModel registers the ranks of the real cl100k_base under a model of its own, the other tests
+// use the tiny cl100k_base of testdata. testdata/cl100k_tools.tiktoken holds the real tokens that occur
+// in the texts of tool_tokens.json, TOKEN_TEST_BPE_DIR switches to the whole vocabulary.
+func upstreamModel(t *testing.T) string {
+	file := filepath.Join("testdata", "cl100k_tools.tiktoken")
+	if dir := os.Getenv("TOKEN_TEST_BPE_DIR"); dir != "" {
+		file = filepath.Join(dir, "cl100k_base.tiktoken")
+	}
+
+	const model = "gpt-4-upstream"
+	if ResolveEncoding(model).Rule == RuleExact {
+		return model
+	}
+	contents, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ranks, err := parseBpe(contents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoding := &tiktoken.Encoding{
+		Name:           "cl100k_upstream",
+		PatStr:         `(?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+(?!\S)|\s+`,
+		MergeableRanks: ranks,
+		SpecialTokens: map[string]int{
+			"<|endoftext|>":   100257,
+			"<|fim_prefix|>":  100258,
+			"<|fim_middle|>":  100259,
+			"<|fim_suffix|>":  100260,
+			"<|endofprompt|>": 100276,
+		},
+	}
+	bpe, err := tiktoken.NewCoreBPE(encoding.MergeableRanks, encoding.SpecialTokens, encoding.PatStr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterTokenizer(NewTiktokenTokenizer(encoding.Name, tiktoken.NewTiktoken(bpe, encoding, map[string]any{}))); err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterModelEncoding(model, encoding.Name); err != nil {
+		t.Fatal(err)
+	}
+	return model
+}
+
+func TestCalculateRequestTokenUpstream(t *testing.T) {
+	model := upstreamModel(t)
+
+	// prompt_tokens reported by the API, from the tests of github.com/hmarr/openai-chat-tokens.
+	data, err := os.ReadFile(filepath.Join("testdata", "tool_tokens.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tests []struct {
+		Name         string                       `json:"name"`
+		Request      openai.ChatCompletionRequest `json:"request"`
+		PromptTokens int                          `json:"prompt_tokens"`
+	}
+	if err := json.Unmarshal(data, &tests); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			got, err := CalculateRequestToken(&tt.Request, model)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.PromptTokens {
+				t.Errorf("got %d tokens, want %d", got, tt.PromptTokens)
+			}
+		})
+	}
+}